@@ -1,9 +1,13 @@
 package generate
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/safe-waters/docker-lock/pkg/generate"
@@ -90,7 +94,12 @@ func DefaultImageParser(flags *Flags) (generate.IImageParser, error) {
 
 	if !flags.DockerfileFlags.ExcludePaths ||
 		!flags.ComposefileFlags.ExcludePaths {
-		dockerfileImageParser = &parse.DockerfileImageParser{}
+		dockerfileImageParser = &parse.DockerfileImageParser{
+			PlatformArgs: flags.FlagsWithSharedValues.PlatformArgs,
+			BuildArgs:    flags.FlagsWithSharedValues.BuildArgs,
+			DockerfileBuildArgs: flags.FlagsWithSharedValues.
+				DockerfileBuildArgs,
+		}
 	}
 
 	if !flags.ComposefileFlags.ExcludePaths {
@@ -125,16 +134,109 @@ func DefaultImageDigestUpdater(
 		return nil, err
 	}
 
-	wrapperManager, err := DefaultWrapperManager(
-		client, flags.FlagsWithSharedValues.ConfigPath,
-	)
-	if err != nil {
-		return nil, err
+	var cache *update.DigestCache
+
+	var err error
+
+	if flags.FlagsWithSharedValues.CacheTTL > 0 {
+		cache, err = update.NewDigestCache(
+			DefaultDigestCachePath(), flags.FlagsWithSharedValues.CacheTTL,
+			flags.FlagsWithSharedValues.RefreshCache,
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	imageDigestUpdater, err := update.NewImageDigestUpdater(wrapperManager)
-	if err != nil {
-		return nil, err
+	var imageDigestUpdater update.IImageDigestUpdater
+
+	digestSource := flags.FlagsWithSharedValues.DigestSource
+
+	switch {
+	case digestSource == LocalDigestSource:
+		dockerHost := os.Getenv("DOCKER_HOST")
+		if dockerHost == "" {
+			dockerHost = update.DefaultDockerHost
+		}
+
+		imageDigestUpdater, err = update.NewLocalImageDigestUpdater(
+			dockerHost,
+		)
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(digestSource, OCILayoutDigestSourcePrefix):
+		imageDigestUpdater, err = update.NewOCILayoutImageDigestUpdater(
+			strings.TrimPrefix(digestSource, OCILayoutDigestSourcePrefix),
+			flags.FlagsWithSharedValues.Platforms,
+		)
+		if err != nil {
+			return nil, err
+		}
+	case flags.FlagsWithSharedValues.Offline:
+		var knownImages []*parse.Image
+
+		knownImages, err = lockfileImages(
+			flags.FlagsWithSharedValues.LockfileName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		imageDigestUpdater = update.NewOfflineImageDigestUpdater(
+			knownImages, cache, flags.FlagsWithSharedValues.Platforms,
+			flags.FlagsWithSharedValues.StrictDigests,
+		)
+	default:
+		if client == nil {
+			var transport *registry.Transport
+
+			transport, err = registry.NewTransport(
+				flags.FlagsWithSharedValues.TLS, registry.DefaultCertsDirs(),
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			client = &registry.HTTPClient{
+				Client: &http.Client{Transport: transport},
+				Retry: &registry.RetryPolicy{
+					Retries: flags.FlagsWithSharedValues.Retries,
+					Wait:    flags.FlagsWithSharedValues.RetryWait,
+					MaxWait: registry.DefaultRetryMaxWait,
+				},
+			}
+		}
+
+		var wrapperManager *registry.WrapperManager
+
+		wrapperManager, err = DefaultWrapperManager(
+			client, flags.FlagsWithSharedValues.ConfigPath,
+			flags.FlagsWithSharedValues.Registries,
+			flags.FlagsWithSharedValues.Mirrors,
+			flags.FlagsWithSharedValues.Plugins,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var limiter *update.RequestLimiter
+
+		limiter, err = update.NewRequestLimiter(
+			flags.FlagsWithSharedValues.MaxConcurrentRequests,
+			flags.FlagsWithSharedValues.RegistryMaxConcurrentRequests,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		imageDigestUpdater, err = update.NewImageDigestUpdater(
+			wrapperManager, flags.FlagsWithSharedValues.Platforms,
+			flags.FlagsWithSharedValues.StrictDigests, cache, limiter,
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return generate.NewImageDigestUpdater(
@@ -157,11 +259,29 @@ func DefaultConfigPath() string {
 	return ""
 }
 
+// DefaultDigestCachePath returns the default location of the digest cache,
+// such as ~/.cache/docker-lock/digests.json on linux.
+func DefaultDigestCachePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+
+	return filepath.Join(cacheDir, "docker-lock", "digests.json")
+}
+
 // DefaultWrapperManager creates a WrapperManager with all possible Wrappers,
-// the default being the docker wrapper.
+// the default being the docker wrapper, along with an InternalWrapper for
+// each declared registry and a PluginWrapper for each declared plugin.
+// Images prefixed with a registry host that no Wrapper matches use the
+// generic wrapper. Images from registries with declared mirrors are looked
+// up in the mirrors first.
 func DefaultWrapperManager(
 	client *registry.HTTPClient,
 	configPath string,
+	registries []*firstparty.RegistryConfig,
+	mirrors []*registry.MirrorConfig,
+	plugins []*registry.PluginConfig,
 ) (*registry.WrapperManager, error) {
 	defaultWrapper, err := firstparty.DefaultWrapper(client, configPath)
 	if err != nil {
@@ -169,9 +289,47 @@ func DefaultWrapperManager(
 	}
 
 	wrapperManager := registry.NewWrapperManager(defaultWrapper)
+	wrapperManager.SetHostWrapper(
+		registry.NewGenericWrapper(client, configPath),
+	)
 	wrapperManager.Add(firstparty.AllWrappers(client, configPath)...)
 	wrapperManager.Add(contrib.AllWrappers(client, configPath)...)
 
+	for _, config := range registries {
+		var wrapper *firstparty.InternalWrapper
+
+		wrapper, err = firstparty.NewInternalWrapperFromConfig(
+			client, configPath, config,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapperManager.Add(wrapper)
+	}
+
+	for _, config := range plugins {
+		var wrapper *registry.PluginWrapper
+
+		wrapper, err = registry.NewPluginWrapper(config)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapperManager.Add(wrapper)
+	}
+
+	for _, config := range mirrors {
+		var mirror *registry.Mirror
+
+		mirror, err = registry.NewMirror(client, configPath, config)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapperManager.AddMirrors(mirror)
+	}
+
 	return wrapperManager, nil
 }
 
@@ -189,6 +347,30 @@ func DefaultLoadEnv(path string) error {
 	return godotenv.Load(path)
 }
 
+// lockfileImages returns the images in the Lockfile at path, or nil if
+// there is no Lockfile.
+func lockfileImages(path string) ([]*parse.Image, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	lockfileByt, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var lockfile generate.Lockfile
+	if err = json.Unmarshal(lockfileByt, &lockfile); err != nil {
+		return nil, err
+	}
+
+	return lockfile.Images(), nil
+}
+
 func ensureFlagsNotNil(flags *Flags) error {
 	if flags == nil {
 		return errors.New("flags cannot be nil")
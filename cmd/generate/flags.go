@@ -1,10 +1,28 @@
 package generate
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry/firstparty"
+	"github.com/spf13/viper"
+)
+
+// Values of the digest-source flag. Digests are looked up in registries by
+// default, but may come from the local image store or an OCI image layout
+// directory, as in oci-layout:./build/image.
+const (
+	RegistryDigestSource        = "registry"
+	LocalDigestSource           = "local"
+	OCILayoutDigestSourcePrefix = "oci-layout:"
 )
 
 // FlagsWithSharedValues represents flags whose values
@@ -15,6 +33,29 @@ type FlagsWithSharedValues struct {
 	ConfigPath           string
 	EnvPath              string
 	IgnoreMissingDigests bool
+	Platforms            []string
+	StrictDigests        bool
+	CacheTTL             time.Duration
+	RefreshCache         bool
+	Offline              bool
+	DigestSource         string
+
+	MaxConcurrentRequests         int
+	RegistryMaxConcurrentRequests map[string]int
+	Retries                       int
+	RetryWait                     time.Duration
+	BuildArgs                     []string
+
+	// Registries, Mirrors, TLS, Plugins, PlatformArgs, and
+	// DockerfileBuildArgs are declared in the registries, mirrors, tls,
+	// plugins, platform-args, and build-args sections of the configuration
+	// file, rather than with flags.
+	Registries          []*firstparty.RegistryConfig
+	Mirrors             []*registry.MirrorConfig
+	TLS                 []*registry.TLSConfig
+	Plugins             []*registry.PluginConfig
+	PlatformArgs        *parse.PlatformArgs
+	DockerfileBuildArgs []*parse.DockerfileBuildArgs
 }
 
 // FlagsWithSharedNames represents flags whose values
@@ -43,6 +84,17 @@ func NewFlagsWithSharedValues(
 	configPath string,
 	envPath string,
 	ignoreMissingDigests bool,
+	platforms []string,
+	strictDigests bool,
+	cacheTTL time.Duration,
+	refreshCache bool,
+	offline bool,
+	digestSource string,
+	maxConcurrentRequests int,
+	registryMaxConcurrentRequests map[string]int,
+	retries int,
+	retryWait time.Duration,
+	buildArgs []string,
 ) (*FlagsWithSharedValues, error) {
 	if baseDir != "" {
 		if err := validateBaseDirectory(baseDir); err != nil {
@@ -56,12 +108,60 @@ func NewFlagsWithSharedValues(
 		}
 	}
 
+	if len(platforms) != 0 {
+		if err := validatePlatforms(platforms); err != nil {
+			return nil, err
+		}
+	}
+
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("'%s' cache-ttl cannot be negative", cacheTTL)
+	}
+
+	if err := validateMaxConcurrentRequests(
+		maxConcurrentRequests, registryMaxConcurrentRequests,
+	); err != nil {
+		return nil, err
+	}
+
+	if retries < 0 {
+		return nil, fmt.Errorf("'%d' retries cannot be negative", retries)
+	}
+
+	if retryWait < 0 {
+		return nil, fmt.Errorf("'%s' retry-wait cannot be negative", retryWait)
+	}
+
+	if _, err := parse.ParseBuildArgs(buildArgs); err != nil {
+		return nil, err
+	}
+
+	if digestSource != "" {
+		if err := validateDigestSource(
+			digestSource, offline, platforms,
+		); err != nil {
+			return nil, err
+		}
+	}
+
 	return &FlagsWithSharedValues{
 		BaseDir:              baseDir,
 		LockfileName:         lockfileName,
 		ConfigPath:           configPath,
 		EnvPath:              envPath,
 		IgnoreMissingDigests: ignoreMissingDigests,
+		Platforms:            platforms,
+		StrictDigests:        strictDigests,
+		CacheTTL:             cacheTTL,
+		RefreshCache:         refreshCache,
+		Offline:              offline,
+		DigestSource:         digestSource,
+
+		MaxConcurrentRequests:         maxConcurrentRequests,
+		RegistryMaxConcurrentRequests: registryMaxConcurrentRequests,
+		Retries:                       retries,
+		RetryWait:                     retryWait,
+		BuildArgs:                     buildArgs,
 	}, nil
 }
 
@@ -107,6 +207,17 @@ func NewFlags(
 	configPath string,
 	envPath string,
 	ignoreMissingDigests bool,
+	platforms []string,
+	strictDigests bool,
+	cacheTTL time.Duration,
+	refreshCache bool,
+	offline bool,
+	digestSource string,
+	maxConcurrentRequests int,
+	registryMaxConcurrentRequests map[string]int,
+	retries int,
+	retryWait time.Duration,
+	buildArgs []string,
 	dockerfilePaths []string,
 	composefilePaths []string,
 	kubernetesfilePaths []string,
@@ -122,6 +233,9 @@ func NewFlags(
 ) (*Flags, error) {
 	sharedFlags, err := NewFlagsWithSharedValues(
 		baseDir, lockfileName, configPath, envPath, ignoreMissingDigests,
+		platforms, strictDigests, cacheTTL, refreshCache, offline,
+		digestSource, maxConcurrentRequests, registryMaxConcurrentRequests,
+		retries, retryWait, buildArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -159,6 +273,163 @@ func NewFlags(
 	}, nil
 }
 
+// ParseRegistries reads the registries section of the configuration file,
+// which declares any number of internal registries, and validates each
+// registry.
+func ParseRegistries() ([]*firstparty.RegistryConfig, error) {
+	var registries []*firstparty.RegistryConfig
+
+	if err := viper.UnmarshalKey("registries", &registries); err != nil {
+		return nil, fmt.Errorf("invalid registries section: %s", err)
+	}
+
+	for _, r := range registries {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return registries, nil
+}
+
+// ParseMirrors reads the mirrors section of the configuration file, which
+// maps upstream registry hosts to mirrors such as pull-through caches, and
+// validates each mirror.
+func ParseMirrors() ([]*registry.MirrorConfig, error) {
+	var mirrors []*registry.MirrorConfig
+
+	if err := viper.UnmarshalKey("mirrors", &mirrors); err != nil {
+		return nil, fmt.Errorf("invalid mirrors section: %s", err)
+	}
+
+	for _, m := range mirrors {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return mirrors, nil
+}
+
+// ParseTLS reads the tls section of the configuration file, which declares
+// CA bundles, client certificates, and insecure registries by host, and
+// validates each entry.
+func ParseTLS() ([]*registry.TLSConfig, error) {
+	var tlsConfigs []*registry.TLSConfig
+
+	if err := viper.UnmarshalKey("tls", &tlsConfigs); err != nil {
+		return nil, fmt.Errorf("invalid tls section: %s", err)
+	}
+
+	for _, t := range tlsConfigs {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfigs, nil
+}
+
+// ParsePlatformArgs reads the platform-args section of the configuration
+// file, which sets the automatic platform ARGs of Dockerfiles, such as
+// $BUILDPLATFORM, and validates the platforms.
+func ParsePlatformArgs() (*parse.PlatformArgs, error) {
+	var platformArgs parse.PlatformArgs
+
+	if err := viper.UnmarshalKey(
+		"platform-args", &platformArgs,
+	); err != nil {
+		return nil, fmt.Errorf("invalid platform-args section: %s", err)
+	}
+
+	for _, platform := range []string{
+		platformArgs.BuildPlatform, platformArgs.TargetPlatform,
+	} {
+		if platform == "" {
+			continue
+		}
+
+		if err := validatePlatforms([]string{platform}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &platformArgs, nil
+}
+
+// ParseBuildArgs reads the build-args section of the configuration file,
+// which sets the build args of Dockerfiles by path, and validates the args
+// and the names of their variants.
+func ParseBuildArgs() ([]*parse.DockerfileBuildArgs, error) {
+	var dockerfileBuildArgs []*parse.DockerfileBuildArgs
+
+	if err := viper.UnmarshalKey(
+		"build-args", &dockerfileBuildArgs,
+	); err != nil {
+		return nil, fmt.Errorf("invalid build-args section: %s", err)
+	}
+
+	paths := map[string]bool{}
+
+	for _, d := range dockerfileBuildArgs {
+		if d.Path == "" {
+			return nil, errors.New("build-args entries must have a path")
+		}
+
+		path := filepath.ToSlash(filepath.Clean(d.Path))
+		if paths[path] {
+			return nil, fmt.Errorf("build-args path '%s' is repeated", path)
+		}
+
+		paths[path] = true
+
+		if _, err := parse.ParseBuildArgs(d.Args); err != nil {
+			return nil, fmt.Errorf("in build-args path '%s', %s", path, err)
+		}
+
+		names := map[string]bool{}
+
+		for _, variant := range d.Variants {
+			if variant.Name == "" || names[variant.Name] {
+				return nil, fmt.Errorf(
+					"variants of build-args path '%s' must have unique names",
+					path,
+				)
+			}
+
+			names[variant.Name] = true
+
+			if _, err := parse.ParseBuildArgs(variant.Args); err != nil {
+				return nil, fmt.Errorf(
+					"in variant '%s' of build-args path '%s', %s",
+					variant.Name, path, err,
+				)
+			}
+		}
+	}
+
+	return dockerfileBuildArgs, nil
+}
+
+// ParsePlugins reads the plugins section of the configuration file, which
+// declares exec plugins that look up digests for images with a prefix, and
+// validates each plugin.
+func ParsePlugins() ([]*registry.PluginConfig, error) {
+	var plugins []*registry.PluginConfig
+
+	if err := viper.UnmarshalKey("plugins", &plugins); err != nil {
+		return nil, fmt.Errorf("invalid plugins section: %s", err)
+	}
+
+	for _, p := range plugins {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return plugins, nil
+}
+
 func validateBaseDirectory(baseDir string) error {
 	if filepath.IsAbs(baseDir) {
 		return fmt.Errorf(
@@ -235,3 +506,121 @@ func validateGlobs(globs []string) error {
 
 	return nil
 }
+
+func validatePlatforms(platforms []string) error {
+	for _, platform := range platforms {
+		if _, err := registry.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateDigestSource(
+	digestSource string,
+	offline bool,
+	platforms []string,
+) error {
+	if digestSource == RegistryDigestSource {
+		return nil
+	}
+
+	if offline {
+		return fmt.Errorf(
+			"'%s' digest-source cannot be used with offline", digestSource,
+		)
+	}
+
+	if digestSource == LocalDigestSource {
+		// the local image store only records the digest of the image list
+		if len(platforms) != 0 {
+			return fmt.Errorf(
+				"'%s' digest-source cannot be used with platforms",
+				digestSource,
+			)
+		}
+
+		return nil
+	}
+
+	if !strings.HasPrefix(digestSource, OCILayoutDigestSourcePrefix) {
+		return fmt.Errorf(
+			"'%s' digest-source must be '%s', '%s', or '%s<dir>'",
+			digestSource, RegistryDigestSource, LocalDigestSource,
+			OCILayoutDigestSourcePrefix,
+		)
+	}
+
+	dir := strings.TrimPrefix(digestSource, OCILayoutDigestSourcePrefix)
+
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("'%s' digest-source is not a directory", dir)
+	}
+
+	return nil
+}
+
+// ParseRegistryMaxConcurrentRequests converts the values of the
+// registry-max-concurrent-requests flag, such as docker.io=4, to integers.
+// Hosts are normalized as in image names, so index.docker.io is docker.io.
+func ParseRegistryMaxConcurrentRequests(
+	registryMaxConcurrentRequests map[string]string,
+) (map[string]int, error) {
+	if len(registryMaxConcurrentRequests) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[string]int, len(registryMaxConcurrentRequests))
+
+	for host, maxRequests := range registryMaxConcurrentRequests {
+		normalizedHost := reference.NormalizeDomain(host)
+
+		if _, ok := parsed[normalizedHost]; ok {
+			return nil, fmt.Errorf(
+				"registry-max-concurrent-requests for '%s' is set more "+
+					"than once", normalizedHost,
+			)
+		}
+
+		var err error
+
+		parsed[normalizedHost], err = strconv.Atoi(maxRequests)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"'%s' registry-max-concurrent-requests for '%s' is not an "+
+					"integer", maxRequests, host,
+			)
+		}
+	}
+
+	return parsed, nil
+}
+
+func validateMaxConcurrentRequests(
+	maxConcurrentRequests int,
+	registryMaxConcurrentRequests map[string]int,
+) error {
+	if maxConcurrentRequests < 0 {
+		return fmt.Errorf(
+			"'%d' max-concurrent-requests cannot be negative",
+			maxConcurrentRequests,
+		)
+	}
+
+	for host, maxRequests := range registryMaxConcurrentRequests {
+		if maxRequests < 0 {
+			return fmt.Errorf(
+				"'%d' registry-max-concurrent-requests for '%s' cannot be "+
+					"negative", maxRequests, host,
+			)
+		}
+	}
+
+	return nil
+}
@@ -2,7 +2,9 @@ package generate_test
 
 import (
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/safe-waters/docker-lock/cmd/generate"
 )
@@ -143,13 +145,107 @@ func TestFlagsWithSharedValues(t *testing.T) {
 			},
 			ShouldFail: true,
 		},
+		{
+			Name: "Negative Cache TTL",
+			Expected: &generate.FlagsWithSharedValues{
+				CacheTTL: -time.Hour,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Invalid Platform",
+			Expected: &generate.FlagsWithSharedValues{
+				Platforms: []string{"linux"},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Negative Max Concurrent Requests",
+			Expected: &generate.FlagsWithSharedValues{
+				MaxConcurrentRequests: -1,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Negative Registry Max Concurrent Requests",
+			Expected: &generate.FlagsWithSharedValues{
+				RegistryMaxConcurrentRequests: map[string]int{
+					"docker.io": -1,
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Negative Retries",
+			Expected: &generate.FlagsWithSharedValues{
+				Retries: -1,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Invalid Digest Source",
+			Expected: &generate.FlagsWithSharedValues{
+				DigestSource: "daemon",
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Missing OCI Layout Digest Source",
+			Expected: &generate.FlagsWithSharedValues{
+				DigestSource: "oci-layout:does-not-exist",
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Local Digest Source With Offline",
+			Expected: &generate.FlagsWithSharedValues{
+				DigestSource: "local",
+				Offline:      true,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Local Digest Source With Platforms",
+			Expected: &generate.FlagsWithSharedValues{
+				DigestSource: "local",
+				Platforms:    []string{"linux/amd64"},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Build Arg Without Name",
+			Expected: &generate.FlagsWithSharedValues{
+				BuildArgs: []string{"=3.9"},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "OCI Layout Digest Source",
+			Expected: &generate.FlagsWithSharedValues{
+				DigestSource: "oci-layout:.",
+			},
+		},
 		{
 			Name: "Normal",
 			Expected: &generate.FlagsWithSharedValues{
-				BaseDir:      ".",
-				LockfileName: "docker-lock.json",
-				ConfigPath:   filepath.Join("~", ".docker", "config.json"),
-				EnvPath:      ".env",
+				BaseDir:       ".",
+				LockfileName:  "docker-lock.json",
+				ConfigPath:    filepath.Join("~", ".docker", "config.json"),
+				EnvPath:       ".env",
+				Platforms:     []string{"linux/amd64", "linux/arm64/v8"},
+				StrictDigests: true,
+				CacheTTL:      time.Hour,
+				RefreshCache:  true,
+				Offline:       true,
+				DigestSource:  "registry",
+
+				MaxConcurrentRequests: 8,
+				RegistryMaxConcurrentRequests: map[string]int{
+					"docker.io": 4,
+				},
+				Retries:   3,
+				RetryWait: time.Second,
+				BuildArgs: []string{"PY_VERSION=3.9"},
 			},
 		},
 	}
@@ -163,7 +259,14 @@ func TestFlagsWithSharedValues(t *testing.T) {
 			got, err := generate.NewFlagsWithSharedValues(
 				test.Expected.BaseDir, test.Expected.LockfileName,
 				test.Expected.ConfigPath, test.Expected.EnvPath,
-				test.Expected.IgnoreMissingDigests,
+				test.Expected.IgnoreMissingDigests, test.Expected.Platforms,
+				test.Expected.StrictDigests, test.Expected.CacheTTL,
+				test.Expected.RefreshCache, test.Expected.Offline,
+				test.Expected.DigestSource,
+				test.Expected.MaxConcurrentRequests,
+				test.Expected.RegistryMaxConcurrentRequests,
+				test.Expected.Retries, test.Expected.RetryWait,
+				test.Expected.BuildArgs,
 			)
 			if test.ShouldFail {
 				if err == nil {
@@ -182,6 +285,68 @@ func TestFlagsWithSharedValues(t *testing.T) {
 	}
 }
 
+func TestParseRegistryMaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name                          string
+		RegistryMaxConcurrentRequests map[string]string
+		Expected                      map[string]int
+		ShouldFail                    bool
+	}{
+		{
+			Name: "Normalized Host",
+			RegistryMaxConcurrentRequests: map[string]string{
+				"index.docker.io": "4",
+				"ghcr.io":         "2",
+			},
+			Expected: map[string]int{"docker.io": 4, "ghcr.io": 2},
+		},
+		{
+			Name: "Duplicate Host",
+			RegistryMaxConcurrentRequests: map[string]string{
+				"docker.io":       "4",
+				"index.docker.io": "2",
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Not An Integer",
+			RegistryMaxConcurrentRequests: map[string]string{
+				"docker.io": "four",
+			},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := generate.ParseRegistryMaxConcurrentRequests(
+				test.RegistryMaxConcurrentRequests,
+			)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(test.Expected, got) {
+				t.Fatalf("expected %v, got %v", test.Expected, got)
+			}
+		})
+	}
+}
+
 func TestFlags(t *testing.T) {
 	t.Parallel()
 
@@ -274,6 +439,18 @@ func TestFlags(t *testing.T) {
 				test.Expected.FlagsWithSharedValues.ConfigPath,
 				test.Expected.FlagsWithSharedValues.EnvPath,
 				test.Expected.FlagsWithSharedValues.IgnoreMissingDigests,
+				test.Expected.FlagsWithSharedValues.Platforms,
+				test.Expected.FlagsWithSharedValues.StrictDigests,
+				test.Expected.FlagsWithSharedValues.CacheTTL,
+				test.Expected.FlagsWithSharedValues.RefreshCache,
+				test.Expected.FlagsWithSharedValues.Offline,
+				test.Expected.FlagsWithSharedValues.DigestSource,
+				test.Expected.FlagsWithSharedValues.MaxConcurrentRequests,
+				test.Expected.FlagsWithSharedValues.
+					RegistryMaxConcurrentRequests,
+				test.Expected.FlagsWithSharedValues.Retries,
+				test.Expected.FlagsWithSharedValues.RetryWait,
+				test.Expected.FlagsWithSharedValues.BuildArgs,
 				test.Expected.DockerfileFlags.ManualPaths,
 				test.Expected.ComposefileFlags.ManualPaths,
 				test.Expected.KubernetesfileFlags.ManualPaths,
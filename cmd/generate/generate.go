@@ -37,6 +37,17 @@ func NewGenerateCmd(client *registry.HTTPClient) (*cobra.Command, error) {
 				"exclude-all-composefiles",
 				"exclude-all-kubernetesfiles",
 				"ignore-missing-digests",
+				"platforms",
+				"strict-digests",
+				"cache-ttl",
+				"refresh",
+				"offline",
+				"digest-source",
+				"max-concurrent-requests",
+				"registry-max-concurrent-requests",
+				"retries",
+				"retry-wait",
+				"build-arg",
 			})
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -122,6 +133,58 @@ func NewGenerateCmd(client *registry.HTTPClient) (*cobra.Command, error) {
 		"ignore-missing-digests", false,
 		"Do not fail if unable to find digests",
 	)
+	generateCmd.Flags().StringSlice(
+		"platforms", []string{},
+		"Platforms such as linux/amd64,linux/arm64 whose digests should "+
+			"be recorded along with the digests of manifest lists",
+	)
+	generateCmd.Flags().Bool(
+		"strict-digests", false,
+		"Compute digests by hashing manifests instead of trusting the "+
+			"registry's Docker-Content-Digest header",
+	)
+	generateCmd.Flags().Duration(
+		"cache-ttl", 0,
+		"How long to cache digests on disk, such as 1h, 0 disables the cache",
+	)
+	generateCmd.Flags().Bool(
+		"refresh", false,
+		"Ignore cached digests, but cache the digests that are found",
+	)
+	generateCmd.Flags().Bool(
+		"offline", false,
+		"Resolve digests from the existing Lockfile and digest cache "+
+			"instead of registries",
+	)
+	generateCmd.Flags().String(
+		"digest-source", RegistryDigestSource,
+		"Where to find digests: 'registry', 'local' for the local docker "+
+			"image store, or 'oci-layout:<dir>' for an OCI image layout",
+	)
+	generateCmd.Flags().Int(
+		"max-concurrent-requests", 0,
+		"Maximum number of images to query registries for at the same "+
+			"time, 0 means unlimited",
+	)
+	generateCmd.Flags().StringToString(
+		"registry-max-concurrent-requests", map[string]string{},
+		"Maximum number of images to query each registry for at the same "+
+			"time, such as docker.io=4,ghcr.io=8",
+	)
+	generateCmd.Flags().Int(
+		"retries", registry.DefaultRetries,
+		"Number of times to retry registry requests that fail with "+
+			"connection errors or status codes such as 429 or 503",
+	)
+	generateCmd.Flags().Duration(
+		"retry-wait", registry.DefaultRetryWait,
+		"How long to wait before the first retry, doubling for each retry",
+	)
+	generateCmd.Flags().StringSlice(
+		"build-arg", []string{},
+		"Build args such as PY_VERSION=3.9 to expand ARGs in Dockerfiles, "+
+			"as with docker build --build-arg",
+	)
 
 	return generateCmd, nil
 }
@@ -228,12 +291,97 @@ func parseFlags() (*Flags, error) {
 	ignoreMissingDigests := viper.GetBool(
 		fmt.Sprintf("%s.%s", namespace, "ignore-missing-digests"),
 	)
+	platforms := viper.GetStringSlice(
+		fmt.Sprintf("%s.%s", namespace, "platforms"),
+	)
+	strictDigests := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "strict-digests"),
+	)
+	cacheTTL := viper.GetDuration(
+		fmt.Sprintf("%s.%s", namespace, "cache-ttl"),
+	)
+	refreshCache := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "refresh"),
+	)
+	offline := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "offline"),
+	)
+	digestSource := viper.GetString(
+		fmt.Sprintf("%s.%s", namespace, "digest-source"),
+	)
+	maxConcurrentRequests := viper.GetInt(
+		fmt.Sprintf("%s.%s", namespace, "max-concurrent-requests"),
+	)
+
+	registryMaxConcurrentRequests, err := ParseRegistryMaxConcurrentRequests(
+		viper.GetStringMapString(
+			fmt.Sprintf("%s.%s", namespace, "registry-max-concurrent-requests"),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := viper.GetInt(
+		fmt.Sprintf("%s.%s", namespace, "retries"),
+	)
+	retryWait := viper.GetDuration(
+		fmt.Sprintf("%s.%s", namespace, "retry-wait"),
+	)
+	buildArgs := viper.GetStringSlice(
+		fmt.Sprintf("%s.%s", namespace, "build-arg"),
+	)
+
+	registries, err := ParseRegistries()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors, err := ParseMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfigs, err := ParseTLS()
+	if err != nil {
+		return nil, err
+	}
 
-	return NewFlags(
+	plugins, err := ParsePlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	platformArgs, err := ParsePlatformArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	dockerfileBuildArgs, err := ParseBuildArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := NewFlags(
 		baseDir, lockfileName, configPath, envPath, ignoreMissingDigests,
+		platforms, strictDigests, cacheTTL, refreshCache, offline,
+		digestSource, maxConcurrentRequests, registryMaxConcurrentRequests,
+		retries, retryWait, buildArgs,
 		dockerfilePaths, composefilePaths, kubernetesfilePaths,
 		dockerfileGlobs, composefileGlobs, kubernetesfileGlobs,
 		dockerfileRecursive, composefileRecursive, kubernetesfileRecursive,
 		dockerfileExcludeAll, composefileExcludeAll, kubernetesfileExcludeAll,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	flags.FlagsWithSharedValues.Registries = registries
+	flags.FlagsWithSharedValues.Mirrors = mirrors
+	flags.FlagsWithSharedValues.TLS = tlsConfigs
+	flags.FlagsWithSharedValues.Plugins = plugins
+	flags.FlagsWithSharedValues.PlatformArgs = platformArgs
+	flags.FlagsWithSharedValues.DockerfileBuildArgs = dockerfileBuildArgs
+
+	return flags, nil
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
 )
 
 // Flags are all possible flags to initialize a Rewriter.
@@ -11,6 +13,8 @@ type Flags struct {
 	LockfileName string
 	TempDir      string
 	ExcludeTags  bool
+	Platform     string
+	Variant      string
 }
 
 // NewFlags returns Flags after validating its fields.
@@ -18,15 +22,25 @@ func NewFlags(
 	lockfileName string,
 	tempDir string,
 	excludeTags bool,
+	platform string,
+	variant string,
 ) (*Flags, error) {
 	if err := validateLockfileName(lockfileName); err != nil {
 		return nil, err
 	}
 
+	if platform != "" {
+		if _, err := registry.ParsePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Flags{
 		LockfileName: lockfileName,
 		TempDir:      tempDir,
 		ExcludeTags:  excludeTags,
+		Platform:     platform,
+		Variant:      variant,
 	}, nil
 }
 
@@ -22,10 +22,20 @@ func TestFlags(t *testing.T) {
 			},
 			ShouldFail: true,
 		},
+		{
+			Name: "Invalid Platform",
+			Expected: &rewrite.Flags{
+				LockfileName: "docker-lock.json",
+				Platform:     "linux",
+			},
+			ShouldFail: true,
+		},
 		{
 			Name: "Normal",
 			Expected: &rewrite.Flags{
 				LockfileName: "docker-lock.json",
+				Platform:     "linux/arm64",
+				Variant:      "py3.9",
 			},
 		},
 	}
@@ -40,6 +50,8 @@ func TestFlags(t *testing.T) {
 				test.Expected.LockfileName,
 				test.Expected.TempDir,
 				test.Expected.ExcludeTags,
+				test.Expected.Platform,
+				test.Expected.Variant,
 			)
 			if test.ShouldFail {
 				if err == nil {
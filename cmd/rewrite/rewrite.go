@@ -23,6 +23,8 @@ func NewRewriteCmd() (*cobra.Command, error) {
 				"lockfile-name",
 				"tempdir",
 				"exclude-tags",
+				"platform",
+				"variant",
 			})
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -56,6 +58,16 @@ func NewRewriteCmd() (*cobra.Command, error) {
 	rewriteCmd.Flags().Bool(
 		"exclude-tags", false, "Exclude image tags from rewritten files",
 	)
+	rewriteCmd.Flags().String(
+		"platform", "",
+		"Pin platform specific digests, such as those for linux/arm64, "+
+			"instead of manifest list digests",
+	)
+	rewriteCmd.Flags().String(
+		"variant", "",
+		"Build arg variant, such as py3.9, to pin Dockerfiles that were "+
+			"locked for several variants to",
+	)
 
 	return rewriteCmd, nil
 }
@@ -64,17 +76,21 @@ func NewRewriteCmd() (*cobra.Command, error) {
 func SetupRewriter(flags *Flags) (*rewrite.Rewriter, error) {
 	dockerfileWriter := &write.DockerfileWriter{
 		ExcludeTags: flags.ExcludeTags,
+		Platform:    flags.Platform,
+		Variant:     flags.Variant,
 		Directory:   flags.TempDir,
 	}
 
 	composefileWriter := &write.ComposefileWriter{
 		DockerfileWriter: dockerfileWriter,
 		ExcludeTags:      flags.ExcludeTags,
+		Platform:         flags.Platform,
 		Directory:        flags.TempDir,
 	}
 
 	kubernetesfileWriter := &write.KubernetesfileWriter{
 		ExcludeTags: flags.ExcludeTags,
+		Platform:    flags.Platform,
 		Directory:   flags.TempDir,
 	}
 
@@ -112,6 +128,12 @@ func parseFlags() (*Flags, error) {
 	excludeTags := viper.GetBool(
 		fmt.Sprintf("%s.%s", namespace, "exclude-tags"),
 	)
+	platform := viper.GetString(
+		fmt.Sprintf("%s.%s", namespace, "platform"),
+	)
+	variant := viper.GetString(
+		fmt.Sprintf("%s.%s", namespace, "variant"),
+	)
 
-	return NewFlags(lockfileName, tempDir, excludeTags)
+	return NewFlags(lockfileName, tempDir, excludeTags, platform, variant)
 }
@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry/firstparty"
 )
 
 // Flags are all possible flags to initialize a Verifier.
@@ -13,6 +18,28 @@ type Flags struct {
 	EnvPath              string
 	IgnoreMissingDigests bool
 	ExcludeTags          bool
+	StrictDigests        bool
+	CacheTTL             time.Duration
+	RefreshCache         bool
+	Offline              bool
+	DigestSource         string
+
+	MaxConcurrentRequests         int
+	RegistryMaxConcurrentRequests map[string]int
+	Retries                       int
+	RetryWait                     time.Duration
+	BuildArgs                     []string
+
+	// Registries, Mirrors, TLS, Plugins, PlatformArgs, and
+	// DockerfileBuildArgs are declared in the registries, mirrors, tls,
+	// plugins, platform-args, and build-args sections of the configuration
+	// file, rather than with flags.
+	Registries          []*firstparty.RegistryConfig
+	Mirrors             []*registry.MirrorConfig
+	TLS                 []*registry.TLSConfig
+	Plugins             []*registry.PluginConfig
+	PlatformArgs        *parse.PlatformArgs
+	DockerfileBuildArgs []*parse.DockerfileBuildArgs
 }
 
 // NewFlags returns Flags after validating its fields.
@@ -22,17 +49,61 @@ func NewFlags(
 	envPath string,
 	ignoreMissingDigests bool,
 	excludeTags bool,
+	strictDigests bool,
+	cacheTTL time.Duration,
+	refreshCache bool,
+	offline bool,
+	digestSource string,
+	maxConcurrentRequests int,
+	registryMaxConcurrentRequests map[string]int,
+	retries int,
+	retryWait time.Duration,
+	buildArgs []string,
 ) (*Flags, error) {
 	if err := validateLockfileName(lockfileName); err != nil {
 		return nil, err
 	}
 
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("'%s' cache-ttl cannot be negative", cacheTTL)
+	}
+
+	if maxConcurrentRequests < 0 {
+		return nil, fmt.Errorf(
+			"'%d' max-concurrent-requests cannot be negative",
+			maxConcurrentRequests,
+		)
+	}
+
+	if retries < 0 {
+		return nil, fmt.Errorf("'%d' retries cannot be negative", retries)
+	}
+
+	if retryWait < 0 {
+		return nil, fmt.Errorf("'%s' retry-wait cannot be negative", retryWait)
+	}
+
+	if _, err := parse.ParseBuildArgs(buildArgs); err != nil {
+		return nil, err
+	}
+
 	return &Flags{
 		LockfileName:         lockfileName,
 		ConfigPath:           configPath,
 		EnvPath:              envPath,
 		IgnoreMissingDigests: ignoreMissingDigests,
 		ExcludeTags:          excludeTags,
+		StrictDigests:        strictDigests,
+		CacheTTL:             cacheTTL,
+		RefreshCache:         refreshCache,
+		Offline:              offline,
+		DigestSource:         digestSource,
+
+		MaxConcurrentRequests:         maxConcurrentRequests,
+		RegistryMaxConcurrentRequests: registryMaxConcurrentRequests,
+		Retries:                       retries,
+		RetryWait:                     retryWait,
+		BuildArgs:                     buildArgs,
 	}, nil
 }
 
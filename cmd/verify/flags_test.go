@@ -3,6 +3,7 @@ package verify_test
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/safe-waters/docker-lock/cmd/verify"
 )
@@ -24,10 +25,45 @@ func TestFlags(t *testing.T) {
 			ShouldFail: true,
 		},
 		{
-			Name: "Normal",
+			Name: "Negative Cache TTL",
 			Expected: &verify.Flags{
 				LockfileName: "docker-lock.json",
-				EnvPath:      ".env",
+				CacheTTL:     -time.Hour,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Negative Max Concurrent Requests",
+			Expected: &verify.Flags{
+				LockfileName:          "docker-lock.json",
+				MaxConcurrentRequests: -1,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Negative Retry Wait",
+			Expected: &verify.Flags{
+				LockfileName: "docker-lock.json",
+				RetryWait:    -time.Second,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Normal",
+			Expected: &verify.Flags{
+				LockfileName:          "docker-lock.json",
+				EnvPath:               ".env",
+				StrictDigests:         true,
+				CacheTTL:              time.Hour,
+				RefreshCache:          true,
+				Offline:               true,
+				DigestSource:          "registry",
+				MaxConcurrentRequests: 8,
+				RegistryMaxConcurrentRequests: map[string]int{
+					"docker.io": 4,
+				},
+				Retries:   3,
+				RetryWait: time.Second,
 			},
 		},
 	}
@@ -44,6 +80,16 @@ func TestFlags(t *testing.T) {
 				test.Expected.EnvPath,
 				test.Expected.IgnoreMissingDigests,
 				test.Expected.ExcludeTags,
+				test.Expected.StrictDigests,
+				test.Expected.CacheTTL,
+				test.Expected.RefreshCache,
+				test.Expected.Offline,
+				test.Expected.DigestSource,
+				test.Expected.MaxConcurrentRequests,
+				test.Expected.RegistryMaxConcurrentRequests,
+				test.Expected.Retries,
+				test.Expected.RetryWait,
+				test.Expected.BuildArgs,
 			)
 			if test.ShouldFail {
 				if err == nil {
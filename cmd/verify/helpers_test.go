@@ -2,6 +2,7 @@ package verify_test
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/safe-waters/docker-lock/cmd/verify"
@@ -14,7 +15,7 @@ func assertFlagsEqual(
 ) {
 	t.Helper()
 
-	if *expected != *got {
+	if !reflect.DeepEqual(expected, got) {
 		t.Fatalf(
 			"expected %+v, got %+v",
 			jsonPrettyPrint(t, expected), jsonPrettyPrint(t, got),
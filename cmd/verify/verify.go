@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	cmd_generate "github.com/safe-waters/docker-lock/cmd/generate"
 	"github.com/safe-waters/docker-lock/pkg/generate"
@@ -31,6 +32,16 @@ func NewVerifyCmd(client *registry.HTTPClient) (*cobra.Command, error) {
 				"env-file",
 				"ignore-missing-digests",
 				"exclude-tags",
+				"strict-digests",
+				"cache-ttl",
+				"refresh",
+				"offline",
+				"digest-source",
+				"max-concurrent-requests",
+				"registry-max-concurrent-requests",
+				"retries",
+				"retry-wait",
+				"build-arg",
 			})
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -70,6 +81,53 @@ func NewVerifyCmd(client *registry.HTTPClient) (*cobra.Command, error) {
 	verifyCmd.Flags().Bool(
 		"exclude-tags", false, "Exclude image tags from verification",
 	)
+	verifyCmd.Flags().Bool(
+		"strict-digests", false,
+		"Compute digests by hashing manifests instead of trusting the "+
+			"registry's Docker-Content-Digest header",
+	)
+	verifyCmd.Flags().Duration(
+		"cache-ttl", 0,
+		"How long to cache digests on disk, such as 1h, 0 disables the cache",
+	)
+	verifyCmd.Flags().Bool(
+		"refresh", false,
+		"Ignore cached digests, but cache the digests that are found",
+	)
+	verifyCmd.Flags().Bool(
+		"offline", false,
+		"Only verify the files and images in the Lockfile, resolving "+
+			"digests from the Lockfile and digest cache instead of registries",
+	)
+	verifyCmd.Flags().String(
+		"digest-source", cmd_generate.RegistryDigestSource,
+		"Where to find digests: 'registry', 'local' for the local docker "+
+			"image store, or 'oci-layout:<dir>' for an OCI image layout",
+	)
+	verifyCmd.Flags().Int(
+		"max-concurrent-requests", 0,
+		"Maximum number of images to query registries for at the same "+
+			"time, 0 means unlimited",
+	)
+	verifyCmd.Flags().StringToString(
+		"registry-max-concurrent-requests", map[string]string{},
+		"Maximum number of images to query each registry for at the same "+
+			"time, such as docker.io=4,ghcr.io=8",
+	)
+	verifyCmd.Flags().Int(
+		"retries", registry.DefaultRetries,
+		"Number of times to retry registry requests that fail with "+
+			"connection errors or status codes such as 429 or 503",
+	)
+	verifyCmd.Flags().Duration(
+		"retry-wait", registry.DefaultRetryWait,
+		"How long to wait before the first retry, doubling for each retry",
+	)
+	verifyCmd.Flags().StringSlice(
+		"build-arg", []string{},
+		"Build args such as PY_VERSION=3.9 to expand ARGs in Dockerfiles, "+
+			"as with docker build --build-arg",
+	)
 
 	return verifyCmd, nil
 }
@@ -122,6 +180,10 @@ func SetupVerifier(
 
 	generatorFlags, err := cmd_generate.NewFlags(
 		".", "", flags.ConfigPath, flags.EnvPath, flags.IgnoreMissingDigests,
+		lockfilePlatforms(&existingLockfile), flags.StrictDigests,
+		flags.CacheTTL, flags.RefreshCache, flags.Offline, flags.DigestSource,
+		flags.MaxConcurrentRequests, flags.RegistryMaxConcurrentRequests,
+		flags.Retries, flags.RetryWait, flags.BuildArgs,
 		dockerfilePaths, composefilePaths, kubernetesfilePaths, nil, nil, nil,
 		false, false, false, len(dockerfilePaths) == 0,
 		len(composefilePaths) == 0, len(kubernetesfilePaths) == 0,
@@ -130,6 +192,17 @@ func SetupVerifier(
 		return nil, err
 	}
 
+	// in offline mode, digests are resolved from the existing Lockfile,
+	// which, unlike the Lockfile that generate writes, can be anywhere
+	generatorFlags.FlagsWithSharedValues.LockfileName = flags.LockfileName
+	generatorFlags.FlagsWithSharedValues.Registries = flags.Registries
+	generatorFlags.FlagsWithSharedValues.Mirrors = flags.Mirrors
+	generatorFlags.FlagsWithSharedValues.TLS = flags.TLS
+	generatorFlags.FlagsWithSharedValues.Plugins = flags.Plugins
+	generatorFlags.FlagsWithSharedValues.PlatformArgs = flags.PlatformArgs
+	generatorFlags.FlagsWithSharedValues.DockerfileBuildArgs =
+		flags.DockerfileBuildArgs
+
 	generator, err := cmd_generate.SetupGenerator(client, generatorFlags)
 	if err != nil {
 		return nil, err
@@ -151,6 +224,34 @@ func SetupVerifier(
 	)
 }
 
+// lockfilePlatforms returns the platforms that were requested when the
+// Lockfile was generated, so that the new Lockfile records the same
+// platform specific digests. Images that request their own platform
+// are skipped, since they do not depend on the requested platforms.
+func lockfilePlatforms(lockfile *generate.Lockfile) []string {
+	platformsCache := map[string]struct{}{}
+
+	for _, image := range lockfile.Images() {
+		if image.Platform != "" {
+			continue
+		}
+
+		for platform := range image.Platforms {
+			platformsCache[platform] = struct{}{}
+		}
+	}
+
+	platforms := make([]string, 0, len(platformsCache))
+
+	for platform := range platformsCache {
+		platforms = append(platforms, platform)
+	}
+
+	sort.Strings(platforms)
+
+	return platforms
+}
+
 func bindPFlags(cmd *cobra.Command, flagNames []string) error {
 	for _, name := range flagNames {
 		if err := viper.BindPFlag(
@@ -179,8 +280,93 @@ func parseFlags() (*Flags, error) {
 	excludeTags := viper.GetBool(
 		fmt.Sprintf("%s.%s", namespace, "exclude-tags"),
 	)
+	strictDigests := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "strict-digests"),
+	)
+	cacheTTL := viper.GetDuration(
+		fmt.Sprintf("%s.%s", namespace, "cache-ttl"),
+	)
+	refreshCache := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "refresh"),
+	)
+	offline := viper.GetBool(
+		fmt.Sprintf("%s.%s", namespace, "offline"),
+	)
+	digestSource := viper.GetString(
+		fmt.Sprintf("%s.%s", namespace, "digest-source"),
+	)
+	maxConcurrentRequests := viper.GetInt(
+		fmt.Sprintf("%s.%s", namespace, "max-concurrent-requests"),
+	)
+
+	registryMaxConcurrentRequests, err := cmd_generate.
+		ParseRegistryMaxConcurrentRequests(
+			viper.GetStringMapString(
+				fmt.Sprintf(
+					"%s.%s", namespace, "registry-max-concurrent-requests",
+				),
+			),
+		)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := viper.GetInt(
+		fmt.Sprintf("%s.%s", namespace, "retries"),
+	)
+	retryWait := viper.GetDuration(
+		fmt.Sprintf("%s.%s", namespace, "retry-wait"),
+	)
+	buildArgs := viper.GetStringSlice(
+		fmt.Sprintf("%s.%s", namespace, "build-arg"),
+	)
+
+	registries, err := cmd_generate.ParseRegistries()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors, err := cmd_generate.ParseMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfigs, err := cmd_generate.ParseTLS()
+	if err != nil {
+		return nil, err
+	}
 
-	return NewFlags(
+	plugins, err := cmd_generate.ParsePlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	platformArgs, err := cmd_generate.ParsePlatformArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	dockerfileBuildArgs, err := cmd_generate.ParseBuildArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := NewFlags(
 		lockfileName, configPath, envPath, ignoreMissingDigests, excludeTags,
+		strictDigests, cacheTTL, refreshCache, offline, digestSource,
+		maxConcurrentRequests, registryMaxConcurrentRequests, retries,
+		retryWait, buildArgs,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	flags.Registries = registries
+	flags.Mirrors = mirrors
+	flags.TLS = tlsConfigs
+	flags.Plugins = plugins
+	flags.PlatformArgs = platformArgs
+	flags.DockerfileBuildArgs = dockerfileBuildArgs
+
+	return flags, nil
 }
@@ -28,6 +28,7 @@ func TestGenerator(t *testing.T) {
 				false, false, false, false, false, false,
 			),
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				DockerfileImages: map[string][]*parse.DockerfileImage{
 					"testdata/success/nocompose/Dockerfile": {
 						{
@@ -89,6 +90,7 @@ func TestGenerator(t *testing.T) {
 				false, false, false, true, false, true,
 			),
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				ComposefileImages: map[string][]*parse.ComposefileImage{
 					"testdata/success/docker-compose.yml": {
 						{
@@ -120,6 +122,7 @@ func TestGenerator(t *testing.T) {
 				false, false, false, true, true, false,
 			),
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				KubernetesfileImages: map[string][]*parse.KubernetesfileImage{
 					"testdata/success/pod.yml": {
 						{
@@ -143,6 +146,7 @@ func TestGenerator(t *testing.T) {
 				false, false, false, false, true, true,
 			),
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				DockerfileImages: map[string][]*parse.DockerfileImage{
 					"testdata/success/nocompose/Dockerfile": {
 						{
@@ -172,7 +176,7 @@ func TestGenerator(t *testing.T) {
 				[]string{"docker-compose.yml"}, nil, nil, nil, nil,
 				false, false, false, true, true, true,
 			),
-			Expected: &generate.Lockfile{},
+			Expected: &generate.Lockfile{Version: generate.LockfileVersion},
 		},
 		{
 			Name: "Service Typo",
@@ -199,7 +203,6 @@ func TestGenerator(t *testing.T) {
 			client := &registry.HTTPClient{
 				Client:      server.Client(),
 				RegistryURL: server.URL,
-				TokenURL:    server.URL + "?scope=repository%s",
 			}
 
 			generator, err := cmd_generate.SetupGenerator(client, test.Flags)
@@ -20,9 +20,9 @@ import (
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
 )
 
-const busyboxLatestSHA = "bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
-const golangLatestSHA = "6cb55c08bbf44793f16e3572bd7d2ae18f7a858f6ae4faa474c0a6eae1174a5d"  // nolint: lll
-const redisLatestSHA = "09c33840ec47815dc0351f1eca3befe741d7105b3e95bc8fdb9a7e4985b9e1e5"   // nolint: lll
+const busyboxLatestSHA = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+const golangLatestSHA = "sha256:6cb55c08bbf44793f16e3572bd7d2ae18f7a858f6ae4faa474c0a6eae1174a5d"  // nolint: lll
+const redisLatestSHA = "sha256:09c33840ec47815dc0351f1eca3befe741d7105b3e95bc8fdb9a7e4985b9e1e5"   // nolint: lll
 
 type DockerfileImageWithoutStructTags struct {
 	*parse.Image
@@ -50,6 +50,7 @@ type KubernetesfileImageWithoutStructTags struct {
 }
 
 type LockfileWithoutStructTags struct {
+	Version              int
 	DockerfileImages     map[string][]*DockerfileImageWithoutStructTags
 	ComposefileImages    map[string][]*ComposefileImageWithoutStructTags
 	KubernetesfileImages map[string][]*KubernetesfileImageWithoutStructTags
@@ -342,6 +343,7 @@ func copyLockfileToLockfileWithoutStructTags(
 	t.Helper()
 
 	lockfileWithoutStructTags := &LockfileWithoutStructTags{
+		Version:              lockfile.Version,
 		ComposefileImages:    map[string][]*ComposefileImageWithoutStructTags{},
 		DockerfileImages:     map[string][]*DockerfileImageWithoutStructTags{},
 		KubernetesfileImages: map[string][]*KubernetesfileImageWithoutStructTags{}, // nolint: lll
@@ -371,16 +373,31 @@ func copyLockfileToLockfileWithoutStructTags(
 func mockServer(t *testing.T, numNetworkCalls *uint64) *httptest.Server {
 	t.Helper()
 
-	server := httptest.NewServer(
+	var server *httptest.Server
+
+	// like Docker Hub, the registry challenges clients to request a bearer
+	// token from its realm
+	server = httptest.NewServer(
 		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 			switch url := req.URL.String(); {
+			case url == "/":
+				res.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="registry.docker.io"`,
+					server.URL,
+				))
+				res.WriteHeader(http.StatusUnauthorized)
 			case strings.Contains(url, "scope"):
-				byt := []byte(`{"token": "NOT_USED"}`)
+				byt := []byte(`{"token": "token"}`)
 				_, err := res.Write(byt)
 				if err != nil {
 					t.Fatal(err)
 				}
 			case strings.Contains(url, "manifests"):
+				if req.Header.Get("Authorization") != "Bearer token" {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
 				atomic.AddUint64(numNetworkCalls, 1)
 
 				urlParts := strings.Split(url, "/")
@@ -444,6 +461,7 @@ func makeFlags(
 
 	flags, err := cmd_generate.NewFlags(
 		baseDir, lockfileName, configPath, envPath, ignoreMissingDigests,
+		nil, false, 0, false, false, "", 0, nil, 0, 0, nil,
 		dockerfilePaths, composefilePaths, kubernetesfilePaths,
 		dockerfileGlobs, composefileGlobs, kubernetesfileGlobs,
 		dockerfileRecursive, composefileRecursive, kubernetesfileRecursive,
@@ -3,6 +3,7 @@ package generate
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"path/filepath"
 	"reflect"
@@ -12,9 +13,16 @@ import (
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
 )
 
+// LockfileVersion is the version of the Lockfile schema that is written.
+// Version 2 records digests with their algorithm, as in
+// sha256:bae015c28bc7..., whereas version 1, which did not record its
+// version, assumed sha256 and recorded only the hex.
+const LockfileVersion = 2
+
 // Lockfile represents the canonical 'docker-lock.json'. It provides
 // the capability to write its contents in JSON format.
 type Lockfile struct {
+	Version              int                                     `json:"lockfileVersion,omitempty"` // nolint: lll
 	DockerfileImages     map[string][]*parse.DockerfileImage     `json:"dockerfiles,omitempty"`     // nolint: lll
 	ComposefileImages    map[string][]*parse.ComposefileImage    `json:"composefiles,omitempty"`    // nolint: lll
 	KubernetesfileImages map[string][]*parse.KubernetesfileImage `json:"kubernetesfiles,omitempty"` // nolint: lll
@@ -23,7 +31,7 @@ type Lockfile struct {
 // NewLockfile sorts images and returns a Lockfile.
 func NewLockfile(anyImages <-chan *AnyImage) (*Lockfile, error) {
 	if anyImages == nil {
-		return &Lockfile{}, nil
+		return &Lockfile{Version: LockfileVersion}, nil
 	}
 
 	var dockerfileImages map[string][]*parse.DockerfileImage
@@ -84,6 +92,7 @@ func NewLockfile(anyImages <-chan *AnyImage) (*Lockfile, error) {
 	}
 
 	lockfile := &Lockfile{
+		Version:              LockfileVersion,
 		DockerfileImages:     dockerfileImages,
 		ComposefileImages:    composefileImages,
 		KubernetesfileImages: kubernetesfileImages,
@@ -94,6 +103,37 @@ func NewLockfile(anyImages <-chan *AnyImage) (*Lockfile, error) {
 	return lockfile, nil
 }
 
+// UnmarshalJSON reads a Lockfile of any version up to LockfileVersion,
+// migrating it to LockfileVersion.
+func (l *Lockfile) UnmarshalJSON(byt []byte) error {
+	// lockfile does not have this method, so unmarshaling it does not recurse
+	type lockfile Lockfile
+
+	var decoded lockfile
+	if err := json.Unmarshal(byt, &decoded); err != nil {
+		return err
+	}
+
+	*l = Lockfile(decoded)
+
+	if l.Version > LockfileVersion {
+		return fmt.Errorf(
+			"lockfile version %d is newer than the supported version %d, "+
+				"please upgrade docker-lock", l.Version, LockfileVersion,
+		)
+	}
+
+	if l.Version < LockfileVersion {
+		for _, image := range l.Images() {
+			image.MigrateDigests()
+		}
+
+		l.Version = LockfileVersion
+	}
+
+	return nil
+}
+
 // Write writes the Lockfile in JSON format to an io.Writer.
 func (l *Lockfile) Write(writer io.Writer) error {
 	if writer == nil || reflect.ValueOf(writer).IsNil() {
@@ -112,6 +152,37 @@ func (l *Lockfile) Write(writer io.Writer) error {
 	return nil
 }
 
+// Images returns the images from all files in the Lockfile.
+func (l *Lockfile) Images() []*parse.Image {
+	var images []*parse.Image
+
+	for _, dockerfileImages := range l.DockerfileImages {
+		for _, image := range dockerfileImages {
+			if image != nil && image.Image != nil {
+				images = append(images, image.Image)
+			}
+		}
+	}
+
+	for _, composefileImages := range l.ComposefileImages {
+		for _, image := range composefileImages {
+			if image != nil && image.Image != nil {
+				images = append(images, image.Image)
+			}
+		}
+	}
+
+	for _, kubernetesfileImages := range l.KubernetesfileImages {
+		for _, image := range kubernetesfileImages {
+			if image != nil && image.Image != nil {
+				images = append(images, image.Image)
+			}
+		}
+	}
+
+	return images
+}
+
 func (l *Lockfile) sortImages() {
 	var waitGroup sync.WaitGroup
 
@@ -142,7 +213,12 @@ func (l *Lockfile) sortDockerfileImages(waitGroup *sync.WaitGroup) {
 			defer waitGroup.Done()
 
 			sort.Slice(images, func(i, j int) bool {
-				return images[i].Position < images[j].Position
+				switch {
+				case images[i].Variant != images[j].Variant:
+					return images[i].Variant < images[j].Variant
+				default:
+					return images[i].Position < images[j].Position
+				}
 			})
 		}()
 	}
@@ -1,6 +1,8 @@
 package generate_test
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/safe-waters/docker-lock/pkg/generate"
@@ -17,7 +19,7 @@ func TestLockfile(t *testing.T) {
 	}{
 		{
 			Name:     "Nil Images",
-			Expected: &generate.Lockfile{},
+			Expected: &generate.Lockfile{Version: generate.LockfileVersion},
 		},
 		{
 			Name: "Non Nil Images",
@@ -54,6 +56,7 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				DockerfileImages: map[string][]*parse.DockerfileImage{
 					"Dockerfile": {
 						{
@@ -92,6 +95,63 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "Dockerfile Variants",
+			AnyImages: []*generate.AnyImage{
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image:    &parse.Image{Name: "python", Tag: "3.9"},
+						Variant:  "py3.9",
+						Position: 0,
+						Path:     "Dockerfile",
+					},
+				},
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+						Variant:  "py3.8",
+						Position: 1,
+						Path:     "Dockerfile",
+					},
+				},
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image:    &parse.Image{Name: "python", Tag: "3.8"},
+						Variant:  "py3.8",
+						Position: 0,
+						Path:     "Dockerfile",
+					},
+				},
+			},
+			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
+				DockerfileImages: map[string][]*parse.DockerfileImage{
+					"Dockerfile": {
+						{
+							Image:    &parse.Image{Name: "python", Tag: "3.8"},
+							Variant:  "py3.8",
+							Position: 0,
+							Path:     "Dockerfile",
+						},
+						{
+							Image: &parse.Image{
+								Name: "busybox",
+								Tag:  "latest",
+							},
+							Variant:  "py3.8",
+							Position: 1,
+							Path:     "Dockerfile",
+						},
+						{
+							Image:    &parse.Image{Name: "python", Tag: "3.9"},
+							Variant:  "py3.9",
+							Position: 0,
+							Path:     "Dockerfile",
+						},
+					},
+				},
+			},
+		},
 		{
 			Name: "Only Composefile Images",
 			AnyImages: []*generate.AnyImage{
@@ -108,6 +168,7 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				ComposefileImages: map[string][]*parse.ComposefileImage{
 					"docker-compose.yml": {
 						{
@@ -137,6 +198,7 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				DockerfileImages: map[string][]*parse.DockerfileImage{
 					"Dockerfile": {
 						{
@@ -165,6 +227,7 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				KubernetesfileImages: map[string][]*parse.KubernetesfileImage{
 					"pod.yml": {
 						{
@@ -276,6 +339,7 @@ func TestLockfile(t *testing.T) {
 				},
 			},
 			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
 				DockerfileImages: map[string][]*parse.DockerfileImage{
 					"Dockerfile": {
 						{
@@ -396,3 +460,134 @@ func TestLockfile(t *testing.T) {
 		})
 	}
 }
+
+func TestLockfileUnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		Contents   string
+		Expected   *generate.Lockfile
+		ShouldFail bool
+	}{
+		{
+			Name: "Version 1",
+			Contents: `
+{
+	"dockerfiles": {
+		"Dockerfile": [
+			{
+				"name": "busybox",
+				"tag": "latest",
+				"digest": "` + busyboxLatestSHA[len("sha256:"):] + `",
+				"platforms": {
+					"linux/amd64": "` + golangLatestSHA[len("sha256:"):] + `"
+				}
+			}
+		]
+	},
+	"kubernetesfiles": {
+		"pod.yml": [
+			{
+				"name": "redis",
+				"tag": "latest",
+				"digest": "` + redisLatestSHA[len("sha256:"):] + `",
+				"container": "redis"
+			}
+		]
+	}
+}
+`,
+			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
+				DockerfileImages: map[string][]*parse.DockerfileImage{
+					"Dockerfile": {
+						{
+							Image: &parse.Image{
+								Name:   "busybox",
+								Tag:    "latest",
+								Digest: busyboxLatestSHA,
+								Platforms: map[string]string{
+									"linux/amd64": golangLatestSHA,
+								},
+							},
+						},
+					},
+				},
+				KubernetesfileImages: map[string][]*parse.KubernetesfileImage{
+					"pod.yml": {
+						{
+							Image: &parse.Image{
+								Name:   "redis",
+								Tag:    "latest",
+								Digest: redisLatestSHA,
+							},
+							ContainerName: "redis",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "Version 2",
+			Contents: `
+{
+	"lockfileVersion": 2,
+	"dockerfiles": {
+		"Dockerfile": [
+			{
+				"name": "busybox",
+				"tag": "latest",
+				"digest": "sha512:` + strings.Repeat("ab", 64) + `"
+			}
+		]
+	}
+}
+`,
+			Expected: &generate.Lockfile{
+				Version: generate.LockfileVersion,
+				DockerfileImages: map[string][]*parse.DockerfileImage{
+					"Dockerfile": {
+						{
+							Image: &parse.Image{
+								Name:   "busybox",
+								Tag:    "latest",
+								Digest: "sha512:" + strings.Repeat("ab", 64),
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:       "Newer Version",
+			Contents:   `{"lockfileVersion": 3}`,
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var got generate.Lockfile
+
+			err := json.Unmarshal([]byte(test.Contents), &got)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertLockfilesEqual(t, test.Expected, &got)
+		})
+	}
+}
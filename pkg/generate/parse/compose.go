@@ -2,6 +2,7 @@ package parse
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -29,10 +30,13 @@ type IComposefileImageParser interface {
 }
 
 // ComposefileImage annotates an image with data about the docker-compose file
-// and/or the Dockerfile from which it was parsed.
+// and/or the Dockerfile from which it was parsed. Instruction and Arg are set
+// as in DockerfileImage for images in the Dockerfile.
 type ComposefileImage struct {
 	*Image
 	DockerfilePath string `json:"dockerfile,omitempty"`
+	Instruction    string `json:"instruction,omitempty"`
+	Arg            string `json:"arg,omitempty"`
 	Position       int    `json:"-"`
 	ServiceName    string `json:"service"`
 	Path           string `json:"-"`
@@ -136,6 +140,8 @@ func (c *ComposefileImageParser) parseFile(
 		}
 	}
 
+	servicePlatforms := c.popServicePlatforms(composefileData, envVars)
+
 	loadedComposefile, err := loader.Load(
 		types.ConfigDetails{
 			ConfigFiles: []types.ConfigFile{
@@ -161,7 +167,8 @@ func (c *ComposefileImageParser) parseFile(
 		waitGroup.Add(1)
 
 		go c.parseService(
-			serviceConfig, path, envVars, composefileImages, waitGroup, done,
+			serviceConfig, path, servicePlatforms[serviceConfig.Name],
+			envVars, composefileImages, waitGroup, done,
 		)
 	}
 }
@@ -169,6 +176,7 @@ func (c *ComposefileImageParser) parseFile(
 func (c *ComposefileImageParser) parseService(
 	serviceConfig types.ServiceConfig,
 	path string,
+	platform string,
 	envVars map[string]string,
 	composefileImages chan<- *ComposefileImage,
 	waitGroup *sync.WaitGroup,
@@ -177,7 +185,19 @@ func (c *ComposefileImageParser) parseService(
 	defer waitGroup.Done()
 
 	if serviceConfig.Build.Context == "" {
-		image := convertImageLineToImage(serviceConfig.Image)
+		image, err := convertImageLineToImage(serviceConfig.Image)
+		if err != nil {
+			err = fmt.Errorf("in docker-compose file '%s': %s", path, err)
+
+			select {
+			case <-done:
+			case composefileImages <- &ComposefileImage{Err: err}:
+			}
+
+			return
+		}
+
+		image.Platform = platform
 
 		select {
 		case <-done:
@@ -229,7 +249,7 @@ func (c *ComposefileImageParser) parseService(
 		dockerfileImageWaitGroup.Add(1)
 
 		go c.DockerfileImageParser.parseFile(
-			dockerfilePath, buildArgs, dockerfileImages,
+			dockerfilePath, buildArgs, "", platform, dockerfileImages,
 			done, &dockerfileImageWaitGroup,
 		)
 	}()
@@ -257,6 +277,8 @@ func (c *ComposefileImageParser) parseService(
 		case composefileImages <- &ComposefileImage{
 			Image:          dockerfileImage.Image,
 			DockerfilePath: dockerfileImage.Path,
+			Instruction:    dockerfileImage.Instruction,
+			Arg:            dockerfileImage.Arg,
 			Position:       dockerfileImage.Position,
 			ServiceName:    serviceConfig.Name,
 			Path:           path,
@@ -264,3 +286,37 @@ func (c *ComposefileImageParser) parseService(
 		}
 	}
 }
+
+// popServicePlatforms removes the platform key from each service, since the
+// compose file loader does not support it, and returns the platforms keyed
+// by service name, with environment variables expanded.
+func (c *ComposefileImageParser) popServicePlatforms(
+	composefileData map[string]interface{},
+	envVars map[string]string,
+) map[string]string {
+	servicePlatforms := map[string]string{}
+
+	services, ok := composefileData["services"].(map[string]interface{})
+	if !ok {
+		return servicePlatforms
+	}
+
+	for serviceName, service := range services {
+		serviceData, ok := service.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if platform, ok := serviceData["platform"].(string); ok {
+			servicePlatforms[serviceName] = os.Expand(
+				platform, func(envVar string) string {
+					return envVars[envVar]
+				},
+			)
+		}
+
+		delete(serviceData, "platform")
+	}
+
+	return servicePlatforms
+}
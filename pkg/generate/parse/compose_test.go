@@ -93,6 +93,47 @@ services:
 				},
 			},
 		},
+		{
+			Name:             "Build Copy From",
+			ComposefilePaths: []string{"docker-compose.yml"},
+			ComposefileContents: [][]byte{
+				[]byte(`
+version: '3'
+services:
+  svc:
+    build: ./build
+`),
+			},
+			DockerfilePaths: []string{filepath.Join("build", "Dockerfile")},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM busybox
+COPY --from=golang:1.15 /usr/local/go /usr/local/go
+`),
+			},
+			Expected: []*parse.ComposefileImage{
+				{
+					Image: &parse.Image{
+						Name: "busybox",
+						Tag:  "latest",
+					},
+					DockerfilePath: filepath.Join("build", "Dockerfile"),
+					Path:           "docker-compose.yml",
+					ServiceName:    "svc",
+				},
+				{
+					Image: &parse.Image{
+						Name: "golang",
+						Tag:  "1.15",
+					},
+					DockerfilePath: filepath.Join("build", "Dockerfile"),
+					Instruction:    parse.CopyInstruction,
+					Position:       1,
+					Path:           "docker-compose.yml",
+					ServiceName:    "svc",
+				},
+			},
+		},
 		{
 			Name:             "Context",
 			ComposefilePaths: []string{"docker-compose.yml"},
@@ -434,6 +475,7 @@ FROM ${IMAGE}
 						Tag:  "latest",
 					},
 					DockerfilePath: "Dockerfile",
+					Arg:            "IMAGE",
 					Path:           "docker-compose.yml",
 					ServiceName:    "svc",
 				},
@@ -2,24 +2,97 @@
 package parse
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
 )
 
-// DockerfileImageParser extracts image values from Dockerfiles.
-type DockerfileImageParser struct{}
+// Instructions other than FROM that can reference images, as in
+// COPY --from=golang:1.15 and RUN --mount=type=bind,from=alpine:3.12.
+// SyntaxInstruction refers to the # syntax= parser directive, which names
+// the frontend image that buildkit pulls to build the Dockerfile.
+// OnbuildInstruction refers to COPY and RUN triggers, as in
+// ONBUILD COPY --from=golang:1.15.
+const (
+	CopyInstruction    = "copy"
+	RunInstruction     = "run"
+	SyntaxInstruction  = "syntax"
+	OnbuildInstruction = "onbuild"
+)
+
+// imageLineArgRegex matches image lines that are a single variable, as in
+// ${BASE_IMAGE} or $BASE_IMAGE.
+var imageLineArgRegex = regexp.MustCompile( // nolint: gochecknoglobals
+	`^\$(?:\{([a-zA-Z_][a-zA-Z0-9_]*)\}|([a-zA-Z_][a-zA-Z0-9_]*))$`,
+)
+
+// directiveRegex matches parser directives, as in
+// # syntax=docker/dockerfile:1.2, the same way buildkit does.
+var directiveRegex = regexp.MustCompile( // nolint: gochecknoglobals
+	`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.+?)\s*$`,
+)
+
+// DockerfileImageParser extracts image values from Dockerfiles. If
+// PlatformArgs is nil, the automatic platform ARGs are undefined.
+// BuildArgs, such as PY_VERSION=3.9, apply to every Dockerfile, as with
+// docker build --build-arg, and are overridden by DockerfileBuildArgs.
+type DockerfileImageParser struct {
+	PlatformArgs        *PlatformArgs
+	BuildArgs           []string
+	DockerfileBuildArgs []*DockerfileBuildArgs
+}
+
+// DockerfileBuildArgs sets the build args of the Dockerfile at Path, as in
+// the build-args section of .docker-lock.yml. If there are Variants, the
+// Dockerfile is parsed once for each of them.
+type DockerfileBuildArgs struct {
+	Path     string              `mapstructure:"path"`
+	Args     []string            `mapstructure:"args"`
+	Variants []*BuildArgsVariant `mapstructure:"variants"`
+}
+
+// BuildArgsVariant is a named set of build args, such as a py3.9 variant
+// with PY_VERSION=3.9, that override the args of its Dockerfile. Images
+// parsed with the variant record its Name.
+type BuildArgsVariant struct {
+	Name string   `mapstructure:"name"`
+	Args []string `mapstructure:"args"`
+}
+
+// PlatformArgs sets the automatic platform ARGs that buildkit defines, as
+// in FROM --platform=$BUILDPLATFORM golang, as in the platform-args section
+// of .docker-lock.yml. The ARGs of empty platforms are undefined, so that
+// Lockfiles do not depend on a platform that the user did not choose.
+type PlatformArgs struct {
+	BuildPlatform  string `mapstructure:"build-platform"`
+	TargetPlatform string `mapstructure:"target-platform"`
+}
 
 // DockerfileImage annotates an image with data about the Dockerfile
-// from which it was parsed.
+// from which it was parsed. Instruction is CopyInstruction or
+// RunInstruction if the image was referenced by a flag of that instruction,
+// and is empty for images in FROM instructions. Variant is the name of the
+// BuildArgsVariant that the Dockerfile was parsed with, if any. Arg is the
+// name of the ARG whose value is the image, as in FROM ${BASE_IMAGE}, unless
+// a build arg outside of a variant overrode the ARG's default.
 type DockerfileImage struct {
 	*Image
-	Position int    `json:"-"`
-	Path     string `json:"-"`
-	Err      error  `json:"-"`
+	Instruction string `json:"instruction,omitempty"`
+	Variant     string `json:"variant,omitempty"`
+	Arg         string `json:"arg,omitempty"`
+	Position    int    `json:"-"`
+	Path        string `json:"-"`
+	Err         error  `json:"-"`
 }
 
 // IDockerfileImageParser provides an interface for DockerfileImageParser's
@@ -31,7 +104,9 @@ type IDockerfileImageParser interface {
 	) <-chan *DockerfileImage
 }
 
-// ParseFiles reads Dockerfiles to parse all images in FROM instructions.
+// ParseFiles reads Dockerfiles to parse all images in FROM instructions,
+// COPY --from flags, RUN --mount flags, and # syntax= parser directives.
+// Dockerfiles with build arg variants are parsed once for each variant.
 func (d *DockerfileImageParser) ParseFiles(
 	paths <-chan string,
 	done <-chan struct{},
@@ -50,11 +125,24 @@ func (d *DockerfileImageParser) ParseFiles(
 		defer waitGroup.Done()
 
 		for path := range paths {
-			waitGroup.Add(1)
+			variantBuildArgs, err := d.variantBuildArgs(path)
+			if err != nil {
+				select {
+				case <-done:
+				case dockerfileImages <- &DockerfileImage{Err: err}:
+				}
 
-			go d.parseFile(
-				path, nil, dockerfileImages, done, &waitGroup,
-			)
+				return
+			}
+
+			for variant, buildArgs := range variantBuildArgs {
+				waitGroup.Add(1)
+
+				go d.parseFile(
+					path, buildArgs, variant, "", dockerfileImages, done,
+					&waitGroup,
+				)
+			}
 		}
 	}()
 
@@ -66,16 +154,21 @@ func (d *DockerfileImageParser) ParseFiles(
 	return dockerfileImages
 }
 
+// parseFile parses a Dockerfile. If defaultPlatform is not empty, it is
+// used for images whose FROM instruction does not specify --platform, and
+// for images in COPY and RUN flags, and it is the value of $TARGETPLATFORM.
 func (d *DockerfileImageParser) parseFile(
 	path string,
 	buildArgs map[string]string,
+	variant string,
+	defaultPlatform string,
 	dockerfileImages chan<- *DockerfileImage,
 	done <-chan struct{},
 	waitGroup *sync.WaitGroup,
 ) {
 	defer waitGroup.Done()
 
-	f, err := os.Open(path)
+	byt, err := ioutil.ReadFile(path)
 	if err != nil {
 		select {
 		case <-done:
@@ -84,9 +177,8 @@ func (d *DockerfileImageParser) parseFile(
 
 		return
 	}
-	defer f.Close()
 
-	loadedDockerfile, err := parser.Parse(f)
+	loadedDockerfile, err := parser.Parse(bytes.NewReader(byt))
 	if err != nil {
 		select {
 		case <-done:
@@ -96,10 +188,41 @@ func (d *DockerfileImageParser) parseFile(
 		return
 	}
 
-	position := 0                     // order of image in Dockerfile
-	stages := map[string]bool{}       // FROM <image line> as <stage>
-	globalArgs := map[string]string{} // ARGs before the first FROM
-	globalContext := true             // true if before first FROM
+	position := 0               // order of image in Dockerfile
+	stages := map[string]bool{} // FROM <image line> as <stage>
+	numStages := 0              // number of FROM instructions
+	globalContext := true       // true if before first FROM
+
+	// ARGs before the first FROM, starting with the automatic platform ARGs
+	globalArgs := d.platformArgs(defaultPlatform)
+	declaredArgs := map[string]bool{} // ARGs declared before the first FROM
+
+	if syntaxImageLine := SyntaxImageLine(byt); syntaxImageLine != "" {
+		image, err := convertImageLineToImage(syntaxImageLine)
+		if err != nil {
+			err = fmt.Errorf("in Dockerfile '%s': %s", path, err)
+
+			select {
+			case <-done:
+			case dockerfileImages <- &DockerfileImage{Err: err}:
+			}
+
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case dockerfileImages <- &DockerfileImage{
+			Image:       image,
+			Instruction: SyntaxInstruction,
+			Variant:     variant,
+			Position:    position,
+			Path:        path,
+		}:
+			position++
+		}
+	}
 
 	for _, child := range loadedDockerfile.AST.Children {
 		switch child.Value {
@@ -134,11 +257,17 @@ func (d *DockerfileImageParser) parseFile(
 					strippedVal := d.stripQuotes(varVal[valIndex])
 
 					globalArgs[strippedVar] = strippedVal
+					declaredArgs[strippedVar] = true
 				} else {
 					// ARG VAR1
 					strippedVar := d.stripQuotes(raw[0])
 
-					globalArgs[strippedVar] = ""
+					// ARG TARGETPLATFORM keeps the automatic value
+					if _, ok := globalArgs[strippedVar]; !ok {
+						globalArgs[strippedVar] = ""
+					}
+
+					declaredArgs[strippedVar] = true
 				}
 			}
 		case "from":
@@ -160,17 +289,41 @@ func (d *DockerfileImageParser) parseFile(
 			}
 
 			globalContext = false
+			numStages++
 
 			if !stages[raw[0]] {
 				imageLine := expandField(raw[0], globalArgs, buildArgs)
 
-				image := convertImageLineToImage(imageLine)
+				image, err := convertImageLineToImage(imageLine)
+				if err != nil {
+					err = fmt.Errorf("in Dockerfile '%s': %s", path, err)
+
+					select {
+					case <-done:
+					case dockerfileImages <- &DockerfileImage{Err: err}:
+					}
+
+					return
+				}
+
+				image.Platform = d.parsePlatform(
+					child.Flags, globalArgs, buildArgs,
+				)
+				if image.Platform == "" {
+					image.Platform = defaultPlatform
+				}
 
 				select {
 				case <-done:
 					return
 				case dockerfileImages <- &DockerfileImage{
-					Image: image, Position: position, Path: path,
+					Image:   image,
+					Variant: variant,
+					Arg: imageLineArg(
+						raw[0], declaredArgs, buildArgs, variant,
+					),
+					Position: position,
+					Path:     path,
 				}:
 					position++
 				}
@@ -185,8 +338,342 @@ func (d *DockerfileImageParser) parseFile(
 				stage := raw[stageIndex]
 				stages[stage] = true
 			}
+		case CopyInstruction, RunInstruction, OnbuildInstruction:
+			var imageLines []string
+
+			if child.Value == OnbuildInstruction {
+				imageLines = OnbuildImageFlagValues(child)
+			} else {
+				for _, imageLine := range ImageFlagValues(
+					child.Value, child.Flags,
+				) {
+					if !isStage(imageLine, stages, numStages) {
+						imageLines = append(imageLines, imageLine)
+					}
+				}
+			}
+
+			for _, imageLine := range imageLines {
+				// triggers are expanded with the ARGs of downstream builds
+				if child.Value != OnbuildInstruction {
+					imageLine = expandField(imageLine, globalArgs, buildArgs)
+				}
+
+				image, err := convertImageLineToImage(imageLine)
+				if err != nil {
+					err = fmt.Errorf("in Dockerfile '%s': %s", path, err)
+
+					select {
+					case <-done:
+					case dockerfileImages <- &DockerfileImage{Err: err}:
+					}
+
+					return
+				}
+
+				image.Platform = defaultPlatform
+
+				select {
+				case <-done:
+					return
+				case dockerfileImages <- &DockerfileImage{
+					Image:       image,
+					Instruction: child.Value,
+					Variant:     variant,
+					Position:    position,
+					Path:        path,
+				}:
+					position++
+				}
+			}
+		}
+	}
+}
+
+// SyntaxImageLine returns the image line of a Dockerfile's # syntax= parser
+// directive, as in docker/dockerfile:1.2, or an empty string if it does not
+// have one. Like all parser directives, it must precede any other line.
+func SyntaxImageLine(dockerfile []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+
+	for scanner.Scan() {
+		match := directiveRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			return ""
+		}
+
+		const keyIndex, valueIndex = 1, 2
+
+		if strings.ToLower(match[keyIndex]) == SyntaxInstruction {
+			// buildkit ignores anything after the image
+			fields := strings.Fields(match[valueIndex])
+			if len(fields) == 0 {
+				return ""
+			}
+
+			return fields[0]
+		}
+	}
+
+	return ""
+}
+
+// ImageFlagValues returns the values of the flags of a COPY or RUN
+// instruction that reference images or build stages, as in golang:1.15
+// for COPY --from=golang:1.15 and alpine:3.12 for
+// RUN --mount=type=bind,from=alpine:3.12,target=/src. Values are returned
+// in the order of the flags, and are not expanded.
+func ImageFlagValues(instruction string, flags []string) []string {
+	const (
+		copyFromFlag = "--from="
+		runMountFlag = "--mount="
+		mountFromKey = "from="
+	)
+
+	var values []string
+
+	for _, flag := range flags {
+		switch {
+		case instruction == CopyInstruction &&
+			strings.HasPrefix(flag, copyFromFlag):
+			values = append(values, strings.TrimPrefix(flag, copyFromFlag))
+		case instruction == RunInstruction &&
+			strings.HasPrefix(flag, runMountFlag):
+			mount := strings.TrimPrefix(flag, runMountFlag)
+
+			for _, field := range strings.Split(mount, ",") {
+				if strings.HasPrefix(strings.ToLower(field), mountFromKey) {
+					values = append(values, field[len(mountFromKey):])
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// OnbuildImageFlagValues returns the values of the flags of an ONBUILD
+// instruction's COPY or RUN trigger that reference images, as in
+// ONBUILD COPY --from=golang:1.15. Triggers run in downstream builds, whose
+// stages and ARGs cannot be known, so values that could name a stage because
+// they have no tag, digest, or registry, such as golang, and values with
+// variables, such as golang:${GO_VERSION}, are skipped.
+func OnbuildImageFlagValues(node *parser.Node) []string {
+	if node.Next == nil || len(node.Next.Children) == 0 {
+		return nil
+	}
+
+	trigger := node.Next.Children[0]
+
+	var values []string
+
+	for _, value := range ImageFlagValues(trigger.Value, trigger.Flags) {
+		if strings.ContainsAny(value, ":/@") && !strings.Contains(value, "$") {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// ImageLineArg returns the name of the variable if an image line is a
+// single variable, as in FROM ${BASE_IMAGE}, and otherwise returns an empty
+// string.
+func ImageLineArg(imageLine string) string {
+	matches := imageLineArgRegex.FindStringSubmatch(imageLine)
+	if matches == nil {
+		return ""
+	}
+
+	if matches[1] != "" {
+		return matches[1]
+	}
+
+	return matches[2]
+}
+
+// imageLineArg returns the name of the ARG that an image line is, if the
+// ARG was declared before the first FROM, rather than being an automatic
+// platform ARG or undeclared. Without a variant, an ARG overridden by a
+// build arg is not returned, since the image is not the ARG's default and
+// rewriting the default with it would change the Dockerfile's image.
+func imageLineArg(
+	imageLine string,
+	declaredArgs map[string]bool,
+	buildArgs map[string]string,
+	variant string,
+) string {
+	arg := ImageLineArg(imageLine)
+	if !declaredArgs[arg] {
+		return ""
+	}
+
+	if _, ok := buildArgs[arg]; ok && variant == "" {
+		return ""
+	}
+
+	return arg
+}
+
+// ParseBuildArgs converts build args such as PY_VERSION=3.9 to a map. As
+// with docker build --build-arg, an arg without a value, such as
+// PY_VERSION, takes its value from the environment, and is skipped if the
+// environment does not set it.
+func ParseBuildArgs(args []string) (map[string]string, error) {
+	buildArgs := map[string]string{}
+
+	for _, arg := range args {
+		key, val := arg, ""
+
+		i := strings.Index(arg, "=")
+		if i != -1 {
+			key, val = arg[:i], arg[i+1:]
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("build arg '%s' does not have a name", arg)
+		}
+
+		if i == -1 {
+			var ok bool
+			if val, ok = os.LookupEnv(key); !ok {
+				continue
+			}
 		}
+
+		buildArgs[key] = val
 	}
+
+	return buildArgs, nil
+}
+
+// variantBuildArgs returns the build args of the Dockerfile at path, keyed
+// by variant. Without variants, the only key is the empty string.
+func (d *DockerfileImageParser) variantBuildArgs(
+	path string,
+) (map[string]map[string]string, error) {
+	buildArgs, err := ParseBuildArgs(d.BuildArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	variantBuildArgs := map[string]map[string]string{"": buildArgs}
+
+	for _, dockerfileBuildArgs := range d.DockerfileBuildArgs {
+		if filepath.ToSlash(filepath.Clean(dockerfileBuildArgs.Path)) !=
+			filepath.ToSlash(filepath.Clean(path)) {
+			continue
+		}
+
+		pathBuildArgs, err := ParseBuildArgs(dockerfileBuildArgs.Args)
+		if err != nil {
+			return nil, fmt.Errorf("in '%s', %s", path, err)
+		}
+
+		for key, val := range pathBuildArgs {
+			buildArgs[key] = val
+		}
+
+		if len(dockerfileBuildArgs.Variants) == 0 {
+			continue
+		}
+
+		variantBuildArgs = map[string]map[string]string{}
+
+		for _, variant := range dockerfileBuildArgs.Variants {
+			variantArgs, err := ParseBuildArgs(variant.Args)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"in variant '%s' of '%s', %s", variant.Name, path, err,
+				)
+			}
+
+			for key, val := range buildArgs {
+				if _, ok := variantArgs[key]; !ok {
+					variantArgs[key] = val
+				}
+			}
+
+			variantBuildArgs[variant.Name] = variantArgs
+		}
+	}
+
+	return variantBuildArgs, nil
+}
+
+// platformArgs returns the automatic platform ARGs that are set, as in
+// BUILDPLATFORM=linux/amd64, BUILDOS=linux, and BUILDARCH=amd64. If
+// defaultPlatform is set, it is the target platform.
+func (d *DockerfileImageParser) platformArgs(
+	defaultPlatform string,
+) map[string]string {
+	var buildPlatform, targetPlatform string
+
+	if d.PlatformArgs != nil {
+		buildPlatform = d.PlatformArgs.BuildPlatform
+		targetPlatform = d.PlatformArgs.TargetPlatform
+	}
+
+	if defaultPlatform != "" {
+		targetPlatform = defaultPlatform
+	}
+
+	args := map[string]string{}
+
+	for prefix, platform := range map[string]string{
+		"BUILD":  buildPlatform,
+		"TARGET": targetPlatform,
+	} {
+		if platform == "" {
+			continue
+		}
+
+		const maxNumFields = 3
+
+		fields := make([]string, maxNumFields)
+		copy(fields, strings.SplitN(platform, "/", maxNumFields))
+
+		args[prefix+"PLATFORM"] = platform
+		args[prefix+"OS"] = fields[0]
+		args[prefix+"ARCH"] = fields[1]
+		args[prefix+"VARIANT"] = fields[2]
+	}
+
+	return args
+}
+
+// isStage returns true if a flag value refers to a build stage by name, or
+// by its index among the numStages stages declared so far, as in
+// COPY --from=0.
+func isStage(value string, stages map[string]bool, numStages int) bool {
+	if stages[value] {
+		return true
+	}
+
+	index, err := strconv.Atoi(value)
+
+	return err == nil && index >= 0 && index < numStages
+}
+
+// parsePlatform returns the expanded value of the --platform flag
+// as in FROM --platform=linux/arm64 <image line>, or an empty string if
+// the flag is not set.
+func (d *DockerfileImageParser) parsePlatform(
+	flags []string,
+	globalArgs map[string]string,
+	buildArgs map[string]string,
+) string {
+	const platformFlag = "--platform="
+
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, platformFlag) {
+			return expandField(
+				strings.TrimPrefix(flag, platformFlag), globalArgs, buildArgs,
+			)
+		}
+	}
+
+	return ""
 }
 
 func (d *DockerfileImageParser) stripQuotes(s string) string {
@@ -200,7 +687,10 @@ func (d *DockerfileImageParser) stripQuotes(s string) string {
 	return s
 }
 
-func convertImageLineToImage(imageLine string) *Image {
+// convertImageLineToImage splits an image line into its name, tag, and
+// digest, returning an error if the digest is invalid. The digest keeps its
+// algorithm, as in sha256:9b1702....
+func convertImageLineToImage(imageLine string) (*Image, error) {
 	tagSeparator := -1
 	digestSeparator := -1
 
@@ -226,7 +716,7 @@ loop:
 		// ubuntu:18.04@sha256:9b1702...
 		name = imageLine[:tagSeparator]
 		tag = imageLine[tagSeparator+1 : digestSeparator]
-		digest = imageLine[digestSeparator+1+len("sha256:"):]
+		digest = imageLine[digestSeparator+1:]
 	case tagSeparator != -1 && digestSeparator == -1:
 		// ubuntu:18.04
 		name = imageLine[:tagSeparator]
@@ -234,16 +724,22 @@ loop:
 	case tagSeparator == -1 && digestSeparator != -1:
 		// ubuntu@sha256:9b1702...
 		name = imageLine[:digestSeparator]
-		digest = imageLine[digestSeparator+1+len("sha256:"):]
+		digest = imageLine[digestSeparator+1:]
 	default:
 		// ubuntu
 		name = imageLine
-		if name != "scratch" {
+		if reference.Familiar(name) != "scratch" {
 			tag = "latest"
 		}
 	}
 
-	return &Image{Name: name, Tag: tag, Digest: digest}
+	if digest != "" {
+		if err := reference.ValidateDigest(digest); err != nil {
+			return nil, fmt.Errorf("image '%s': %s", imageLine, err)
+		}
+	}
+
+	return &Image{Name: name, Tag: tag, Digest: digest}, nil
 }
 
 func expandField(
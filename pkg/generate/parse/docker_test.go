@@ -17,6 +17,9 @@ func TestDockerfileImageParser(t *testing.T) {
 		Name               string
 		DockerfilePaths    []string
 		DockerfileContents [][]byte
+		PlatformArgs       *parse.PlatformArgs
+		BuildArgs          []string
+		BuildArgsByPath    []*parse.DockerfileBuildArgs
 		Expected           []*parse.DockerfileImage
 		ShouldFail         bool
 	}{
@@ -69,14 +72,14 @@ FROM scratch
 			DockerfilePaths: []string{"Dockerfile"},
 			DockerfileContents: [][]byte{
 				[]byte(`
-FROM ubuntu@sha256:bae015c28bc7
+FROM ubuntu@` + ubuntuDigest + `
 `),
 			},
 			Expected: []*parse.DockerfileImage{
 				{
 					Image: &parse.Image{
 						Name:   "ubuntu",
-						Digest: "bae015c28bc7",
+						Digest: ubuntuDigest,
 					},
 					Position: 0,
 					Path:     "Dockerfile",
@@ -88,18 +91,74 @@ FROM ubuntu@sha256:bae015c28bc7
 			DockerfilePaths: []string{"Dockerfile"},
 			DockerfileContents: [][]byte{
 				[]byte(`
-FROM --platform=$BUILDPLATFORM ubuntu@sha256:bae015c28bc7
+FROM --platform=$BUILDPLATFORM ubuntu@` + ubuntuDigest + `
 `),
 			},
 			Expected: []*parse.DockerfileImage{
 				{
 					Image: &parse.Image{
 						Name:   "ubuntu",
-						Digest: "bae015c28bc7",
+						Digest: ubuntuDigest,
+					},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Platform Flag",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG PLATFORM=linux/arm64
+FROM --platform=${PLATFORM} ubuntu@` + ubuntuDigest + `
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image: &parse.Image{
+						Name:     "ubuntu",
+						Digest:   ubuntuDigest,
+						Platform: "linux/arm64",
+					},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Platform Args",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG TARGETPLATFORM
+FROM --platform=$BUILDPLATFORM golang:1.15 AS build
+FROM --platform=${TARGETPLATFORM} busybox:${TARGETARCH}
+`),
+			},
+			PlatformArgs: &parse.PlatformArgs{
+				BuildPlatform:  "linux/arm64/v8",
+				TargetPlatform: "linux/arm/v7",
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image: &parse.Image{
+						Name:     "golang",
+						Tag:      "1.15",
+						Platform: "linux/arm64/v8",
 					},
 					Position: 0,
 					Path:     "Dockerfile",
 				},
+				{
+					Image: &parse.Image{
+						Name:     "busybox",
+						Tag:      "arm",
+						Platform: "linux/arm/v7",
+					},
+					Position: 1,
+					Path:     "Dockerfile",
+				},
 			},
 		},
 		{
@@ -107,7 +166,7 @@ FROM --platform=$BUILDPLATFORM ubuntu@sha256:bae015c28bc7
 			DockerfilePaths: []string{"Dockerfile"},
 			DockerfileContents: [][]byte{
 				[]byte(`
-FROM ubuntu:bionic@sha256:bae015c28bc7
+FROM ubuntu:bionic@` + ubuntuDigest + `
 `),
 			},
 			Expected: []*parse.DockerfileImage{
@@ -115,7 +174,7 @@ FROM ubuntu:bionic@sha256:bae015c28bc7
 					Image: &parse.Image{
 						Name:   "ubuntu",
 						Tag:    "bionic",
-						Digest: "bae015c28bc7",
+						Digest: ubuntuDigest,
 					},
 					Position: 0,
 					Path:     "Dockerfile",
@@ -127,7 +186,7 @@ FROM ubuntu:bionic@sha256:bae015c28bc7
 			DockerfilePaths: []string{"Dockerfile"},
 			DockerfileContents: [][]byte{
 				[]byte(`
-FROM localhost:5000/ubuntu:bionic@sha256:bae015c28bc7
+FROM localhost:5000/ubuntu:bionic@` + ubuntuDigest + `
 `),
 			},
 			Expected: []*parse.DockerfileImage{
@@ -135,7 +194,26 @@ FROM localhost:5000/ubuntu:bionic@sha256:bae015c28bc7
 					Image: &parse.Image{
 						Name:   "localhost:5000/ubuntu",
 						Tag:    "bionic",
-						Digest: "bae015c28bc7",
+						Digest: ubuntuDigest,
+					},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "SHA512 Digest",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM redis@` + redisDigest + `
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image: &parse.Image{
+						Name:   "redis",
+						Digest: redisDigest,
 					},
 					Position: 0,
 					Path:     "Dockerfile",
@@ -156,14 +234,51 @@ FROM ${IMAGE}
 			Expected: []*parse.DockerfileImage{
 				{
 					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Arg:      "IMAGE",
 					Position: 0,
 					Path:     "Dockerfile",
 				},
 				{
 					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Arg:      "IMAGE",
+					Position: 1,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Arg Image",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG BASE_IMAGE=python:3.8
+ARG PY_VERSION=3.9
+FROM $BASE_IMAGE
+FROM python:${PY_VERSION}
+FROM ${TARGETARCH}/busybox
+`),
+			},
+			PlatformArgs: &parse.PlatformArgs{TargetPlatform: "linux/amd64"},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.8"},
+					Arg:      "BASE_IMAGE",
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.9"},
 					Position: 1,
 					Path:     "Dockerfile",
 				},
+				{
+					Image: &parse.Image{
+						Name: "amd64/busybox",
+						Tag:  "latest",
+					},
+					Position: 2,
+					Path:     "Dockerfile",
+				},
 			},
 		},
 		{
@@ -226,6 +341,318 @@ FROM busybox
 				},
 			},
 		},
+		{
+			Name:            "Copy From",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG GO_VERSION=1.15
+FROM busybox AS base
+COPY --from=golang:${GO_VERSION} /usr/local/go /usr/local/go
+COPY --from=base /bin /bin
+COPY --from=0 /etc /etc
+COPY --chown=root --from=ubuntu@` + ubuntuDigest + ` /lib /lib
+COPY . .
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:       &parse.Image{Name: "golang", Tag: "1.15"},
+					Instruction: parse.CopyInstruction,
+					Position:    1,
+					Path:        "Dockerfile",
+				},
+				{
+					Image: &parse.Image{
+						Name:   "ubuntu",
+						Digest: ubuntuDigest,
+					},
+					Instruction: parse.CopyInstruction,
+					Position:    2,
+					Path:        "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Run Mount From",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM busybox AS base
+RUN --mount=type=bind,from=alpine:3.12,target=/src \
+	--mount=type=cache,target=/root/.cache \
+	--mount=type=bind,from=base,target=/base ls /src
+RUN ["echo", "hello"]
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:       &parse.Image{Name: "alpine", Tag: "3.12"},
+					Instruction: parse.RunInstruction,
+					Position:    1,
+					Path:        "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Onbuild",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM busybox
+ONBUILD COPY --from=golang:1.15 /go/bin/app /app
+ONBUILD COPY --from=build /app /app
+ONBUILD RUN --mount=type=bind,from=ghcr.io/org/tools,target=/tools ls
+ONBUILD RUN echo hello
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:       &parse.Image{Name: "golang", Tag: "1.15"},
+					Instruction: parse.OnbuildInstruction,
+					Position:    1,
+					Path:        "Dockerfile",
+				},
+				{
+					Image: &parse.Image{
+						Name: "ghcr.io/org/tools",
+						Tag:  "latest",
+					},
+					Instruction: parse.OnbuildInstruction,
+					Position:    2,
+					Path:        "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Onbuild With Variables",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG GO_VERSION=1.15
+FROM busybox
+ONBUILD COPY --from=golang:${GO_VERSION} /go/bin/app /app
+ONBUILD COPY --from=golang:1.16 /go/bin/app /app
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:       &parse.Image{Name: "golang", Tag: "1.16"},
+					Instruction: parse.OnbuildInstruction,
+					Position:    1,
+					Path:        "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Syntax Directive",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`# syntax = docker/dockerfile:1.2 --ignored
+# escape=\
+FROM busybox
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image: &parse.Image{
+						Name: "docker/dockerfile",
+						Tag:  "1.2",
+					},
+					Instruction: parse.SyntaxInstruction,
+					Position:    0,
+					Path:        "Dockerfile",
+				},
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 1,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Empty Syntax Directive",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte("# syntax= \nFROM busybox\n"),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Syntax Comment",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`# not a directive
+# syntax=docker/dockerfile:1.2
+FROM busybox
+`),
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "busybox", Tag: "latest"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Build Args",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG PY_VERSION=3.8
+ARG DEBIAN_VERSION=buster
+FROM python:${PY_VERSION}-${DEBIAN_VERSION}
+`),
+			},
+			BuildArgs: []string{"PY_VERSION=3.9"},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.9-buster"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Build Arg Overrides Arg Image",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG BASE_IMAGE=python:3.8
+FROM ${BASE_IMAGE}
+`),
+			},
+			BuildArgs: []string{"BASE_IMAGE=python:3.9"},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.9"},
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Build Arg Variant Overrides Arg Image",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG BASE_IMAGE=python:3.8
+FROM ${BASE_IMAGE}
+`),
+			},
+			BuildArgsByPath: []*parse.DockerfileBuildArgs{
+				{
+					Path: "Dockerfile",
+					Variants: []*parse.BuildArgsVariant{
+						{
+							Name: "py3.9",
+							Args: []string{"BASE_IMAGE=python:3.9"},
+						},
+					},
+				},
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.9"},
+					Variant:  "py3.9",
+					Arg:      "BASE_IMAGE",
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Build Arg Variants",
+			DockerfilePaths: []string{"Dockerfile", "web/Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+ARG PY_VERSION
+ARG DEBIAN_VERSION
+FROM python:${PY_VERSION}-${DEBIAN_VERSION}
+`),
+				[]byte(`
+ARG DEBIAN_VERSION=stretch
+FROM debian:${DEBIAN_VERSION}
+`),
+			},
+			BuildArgs: []string{"DEBIAN_VERSION=buster"},
+			BuildArgsByPath: []*parse.DockerfileBuildArgs{
+				{
+					Path: "Dockerfile",
+					Args: []string{"PY_VERSION=3.8"},
+					Variants: []*parse.BuildArgsVariant{
+						{Name: "py3.8"},
+						{
+							Name: "py3.9-slim",
+							Args: []string{
+								"PY_VERSION=3.9",
+								"DEBIAN_VERSION=slim-buster",
+							},
+						},
+					},
+				},
+			},
+			Expected: []*parse.DockerfileImage{
+				{
+					Image:    &parse.Image{Name: "python", Tag: "3.8-buster"},
+					Variant:  "py3.8",
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image: &parse.Image{
+						Name: "python",
+						Tag:  "3.9-slim-buster",
+					},
+					Variant:  "py3.9-slim",
+					Position: 0,
+					Path:     "Dockerfile",
+				},
+				{
+					Image:    &parse.Image{Name: "debian", Tag: "buster"},
+					Position: 0,
+					Path:     "web/Dockerfile",
+				},
+			},
+		},
+		{
+			Name:            "Invalid Build Arg",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM busybox
+`),
+			},
+			BuildArgs:  []string{"=3.9"},
+			ShouldFail: true,
+		},
 		{
 			Name:            "Invalid Arg",
 			DockerfilePaths: []string{"Dockerfile"},
@@ -233,6 +660,16 @@ FROM busybox
 				[]byte(`
 ARG
 FROM busybox
+`),
+			},
+			ShouldFail: true,
+		},
+		{
+			Name:            "Invalid Digest",
+			DockerfilePaths: []string{"Dockerfile"},
+			DockerfileContents: [][]byte{
+				[]byte(`
+FROM ubuntu@sha256:bae015c28bc7
 `),
 			},
 			ShouldFail: true,
@@ -273,7 +710,17 @@ FROM
 
 			done := make(chan struct{})
 
-			dockerfileParser := &parse.DockerfileImageParser{}
+			for _, dockerfileBuildArgs := range test.BuildArgsByPath {
+				dockerfileBuildArgs.Path = filepath.Join(
+					tempDir, dockerfileBuildArgs.Path,
+				)
+			}
+
+			dockerfileParser := &parse.DockerfileImageParser{
+				PlatformArgs:        test.PlatformArgs,
+				BuildArgs:           test.BuildArgs,
+				DockerfileBuildArgs: test.BuildArgsByPath,
+			}
 			dockerfileImages := dockerfileParser.ParseFiles(
 				pathsToParseCh, done,
 			)
@@ -12,16 +12,26 @@ import (
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
 )
 
+const (
+	ubuntuDigest = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572"                                                                 // nolint: lll
+	redisDigest  = "sha512:2ca3fae13875efeeb9a05685f919dda84f4c60ab07662a86479f3dae6d3dfcc9e61008e785919924edc878f9d9147f1bd76b9026e67507f2dedf9bdd6d57e447" // nolint: lll
+)
+
 type DockerfileImageWithoutStructTags struct {
 	*parse.Image
-	Position int
-	Path     string
-	Err      error
+	Instruction string
+	Variant     string
+	Arg         string
+	Position    int
+	Path        string
+	Err         error
 }
 
 type ComposefileImageWithoutStructTags struct {
 	*parse.Image
 	DockerfilePath string
+	Instruction    string
+	Arg            string
 	Position       int
 	ServiceName    string
 	Path           string
@@ -188,10 +198,13 @@ func copyDockerfileImagesToDockerfileImagesWithoutStructTags(
 	for i, image := range dockerfileImages {
 		dockerfileImagesWithoutStructTags[i] =
 			&DockerfileImageWithoutStructTags{
-				Image:    image.Image,
-				Position: image.Position,
-				Path:     image.Path,
-				Err:      image.Err,
+				Image:       image.Image,
+				Instruction: image.Instruction,
+				Variant:     image.Variant,
+				Arg:         image.Arg,
+				Position:    image.Position,
+				Path:        image.Path,
+				Err:         image.Err,
 			}
 	}
 
@@ -213,6 +226,8 @@ func copyComposefileImagesToComposefileImagesWithoutStructTags(
 			&ComposefileImageWithoutStructTags{
 				Image:          image.Image,
 				DockerfilePath: image.DockerfilePath,
+				Instruction:    image.Instruction,
+				Arg:            image.Arg,
 				Position:       image.Position,
 				ServiceName:    image.ServiceName,
 				Path:           image.Path,
@@ -269,6 +284,8 @@ func sortDockerfileImageParserResults(
 		switch {
 		case results[i].Path != results[j].Path:
 			return results[i].Path < results[j].Path
+		case results[i].Variant != results[j].Variant:
+			return results[i].Variant < results[j].Variant
 		default:
 			return results[i].Position < results[j].Position
 		}
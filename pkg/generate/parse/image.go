@@ -1,10 +1,34 @@
 package parse
 
+import "github.com/safe-waters/docker-lock/pkg/generate/reference"
+
 // Image contains information extracted from image lines such as
 // busybox:latest@sha256:dd97a3f... which could be represented as:
-// Image{Name: busybox, Tag: latest, Digest: dd97a3f...}.
+// Image{Name: busybox, Tag: latest, Digest: sha256:dd97a3f...}.
+//
+// Platform is set if the image line requests a platform, as in
+// FROM --platform=linux/arm64 busybox. If Digest refers to a manifest list,
+// Platforms holds the digests of the platform specific manifests,
+// keyed by platform, as in {"linux/arm64": "sha256:c9249fd..."}. MediaType
+// is the media type of the manifest that Digest was computed from, such as
+// application/vnd.oci.image.index.v1+json. Source is where the digest was
+// found if not in a registry, such as "local" for the local image store.
 type Image struct {
-	Name   string `json:"name"`
-	Tag    string `json:"tag"`
-	Digest string `json:"digest"`
+	Name      string            `json:"name"`
+	Tag       string            `json:"tag"`
+	Digest    string            `json:"digest"`
+	MediaType string            `json:"mediaType,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Platform  string            `json:"platform,omitempty"`
+	Platforms map[string]string `json:"platforms,omitempty"`
+}
+
+// MigrateDigests adds the sha256 algorithm to the image's digests if they
+// were recorded without one, as by version 1 Lockfiles.
+func (i *Image) MigrateDigests() {
+	i.Digest = reference.MigrateDigest(i.Digest)
+
+	for platform, digest := range i.Platforms {
+		i.Platforms[platform] = reference.MigrateDigest(digest)
+	}
 }
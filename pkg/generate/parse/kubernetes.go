@@ -2,6 +2,7 @@ package parse
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"sync"
@@ -166,7 +167,17 @@ func (k *KubernetesfileImageParser) parseDocRecursive(
 		}
 
 		if name != "" && imageLine != "" {
-			image := convertImageLineToImage(imageLine)
+			image, err := convertImageLineToImage(imageLine)
+			if err != nil {
+				err = fmt.Errorf("in Kubernetesfile '%s': %s", path, err)
+
+				select {
+				case <-done:
+				case kubernetesfileImages <- &KubernetesfileImage{Err: err}:
+				}
+
+				return
+			}
 
 			select {
 			case <-done:
@@ -87,7 +87,7 @@ metadata:
 spec:
   containers:
   - name: redis
-    image: redis:1.0@sha256:123
+    image: redis:1.0@` + redisDigest + `
     ports:
     - containerPort: 80
   - name: bash
@@ -113,7 +113,7 @@ spec:
 					Image: &parse.Image{
 						Name:   "redis",
 						Tag:    "1.0",
-						Digest: "123",
+						Digest: redisDigest,
 					},
 					ImagePosition: 0,
 					DocPosition:   1,
@@ -0,0 +1,94 @@
+package reference
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Digest algorithms registered in the OCI image spec. Digests are of the
+// form algorithm:encoded, as in sha256:bae015c28bc7....
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// digestRegex matches the digest grammar of the OCI image spec.
+var digestRegex = regexp.MustCompile( // nolint: gochecknoglobals
+	`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`,
+)
+
+// hexRegex matches the encoding of the registered algorithms.
+var hexRegex = regexp.MustCompile(`^[a-f0-9]+$`) // nolint: gochecknoglobals
+
+// encodedLengths are the lengths of the hex encoded digests of the
+// registered algorithms.
+var encodedLengths = map[string]int{ // nolint: gochecknoglobals
+	SHA256: 64,
+	SHA512: 128,
+}
+
+// ValidateDigest returns an error if the digest does not match the OCI
+// digest grammar, or if its algorithm is registered but its encoded part
+// is not lowercase hex of the algorithm's length.
+func ValidateDigest(digest string) error {
+	if !digestRegex.MatchString(digest) {
+		return fmt.Errorf(
+			"invalid digest '%s', expected algorithm:encoded, as in "+
+				"sha256:bae015c28bc7...", digest,
+		)
+	}
+
+	algorithm, encoded := SplitDigest(digest)
+
+	length, ok := encodedLengths[algorithm]
+	if !ok {
+		return nil
+	}
+
+	if len(encoded) != length || !hexRegex.MatchString(encoded) {
+		return fmt.Errorf(
+			"invalid %s digest '%s', expected %d lowercase hex characters",
+			algorithm, digest, length,
+		)
+	}
+
+	return nil
+}
+
+// SplitDigest splits a digest into its algorithm and encoded parts. If the
+// digest has no algorithm, the algorithm is empty.
+func SplitDigest(digest string) (algorithm string, encoded string) {
+	colon := strings.IndexByte(digest, ':')
+	if colon == -1 {
+		return "", digest
+	}
+
+	return digest[:colon], digest[colon+1:]
+}
+
+// MigrateDigest returns the digest with the sha256 algorithm if it has no
+// algorithm, as digests were recorded by version 1 Lockfiles, and the
+// digest otherwise.
+func MigrateDigest(digest string) string {
+	if digest == "" || strings.ContainsRune(digest, ':') {
+		return digest
+	}
+
+	return SHA256 + ":" + digest
+}
+
+// ComputeDigest returns the digest of the content with the algorithm, which
+// must be registered.
+func ComputeDigest(algorithm string, content []byte) (string, error) {
+	switch algorithm {
+	case SHA256:
+		return fmt.Sprintf("%s:%x", algorithm, sha256.Sum256(content)), nil
+	case SHA512:
+		return fmt.Sprintf("%s:%x", algorithm, sha512.Sum512(content)), nil
+	}
+
+	return "", fmt.Errorf("unsupported digest algorithm '%s'", algorithm)
+}
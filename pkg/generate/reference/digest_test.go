@@ -0,0 +1,148 @@
+package reference_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+const busyboxLatestSHA = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+
+func TestDigest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name             string
+		Digest           string
+		ExpectedMigrated string
+		ShouldFail       bool
+	}{
+		{
+			Name:             "SHA256",
+			Digest:           busyboxLatestSHA,
+			ExpectedMigrated: busyboxLatestSHA,
+		},
+		{
+			Name:             "SHA512",
+			Digest:           "sha512:" + strings.Repeat("ab", 64),
+			ExpectedMigrated: "sha512:" + strings.Repeat("ab", 64),
+		},
+		{
+			Name:             "Unregistered Algorithm",
+			Digest:           "multihash+base58:QmRZxt2b1FVZPNqd8hsiykDL3TdBDeTSPX9Kv46HmX4Gx8", // nolint: lll
+			ExpectedMigrated: "multihash+base58:QmRZxt2b1FVZPNqd8hsiykDL3TdBDeTSPX9Kv46HmX4Gx8", // nolint: lll
+		},
+		{
+			Name:             "No Algorithm",
+			Digest:           busyboxLatestSHA[len("sha256:"):],
+			ExpectedMigrated: busyboxLatestSHA,
+			ShouldFail:       true,
+		},
+		{
+			Name:             "Short SHA256",
+			Digest:           "sha256:bae015c28bc7",
+			ExpectedMigrated: "sha256:bae015c28bc7",
+			ShouldFail:       true,
+		},
+		{
+			Name:             "Uppercase SHA256",
+			Digest:           "sha256:" + strings.Repeat("AB", 32),
+			ExpectedMigrated: "sha256:" + strings.Repeat("AB", 32),
+			ShouldFail:       true,
+		},
+		{
+			Name:             "SHA256 Length SHA512",
+			Digest:           "sha512:" + strings.Repeat("ab", 32),
+			ExpectedMigrated: "sha512:" + strings.Repeat("ab", 32),
+			ShouldFail:       true,
+		},
+		{
+			Name:             "Invalid Algorithm",
+			Digest:           "SHA256:" + strings.Repeat("ab", 32),
+			ExpectedMigrated: "SHA256:" + strings.Repeat("ab", 32),
+			ShouldFail:       true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := reference.MigrateDigest(
+				test.Digest,
+			); got != test.ExpectedMigrated {
+				t.Fatalf("expected %s, got %s", test.ExpectedMigrated, got)
+			}
+
+			err := reference.ValidateDigest(test.Digest)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestComputeDigest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		Algorithm  string
+		Expected   string
+		ShouldFail bool
+	}{
+		{
+			Name:      "SHA256",
+			Algorithm: reference.SHA256,
+			Expected:  "sha256:9d75f0d7c398df565d7ac04c6819b62d6d8f9560f5eb4672596ecd8f7e96ae91", // nolint: lll
+		},
+		{
+			Name:      "SHA512",
+			Algorithm: reference.SHA512,
+			Expected:  "sha512:d23f58147e09f857a3edecd70a3ac97a6d65555f09a6ab2939f44f4e7d2904be620d6600ecc0e6a181e15cb70ce9e3966e9e266e4f5525602de0a6540774d9b7", // nolint: lll
+		},
+		{
+			Name:       "Unsupported Algorithm",
+			Algorithm:  "md5",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := reference.ComputeDigest(
+				test.Algorithm, []byte("busybox"),
+			)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.Expected {
+				t.Fatalf("expected %s, got %s", test.Expected, got)
+			}
+		})
+	}
+}
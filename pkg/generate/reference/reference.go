@@ -0,0 +1,110 @@
+// Package reference provides functionality for normalizing image names, so
+// that busybox, library/busybox, and docker.io/library/busybox are treated
+// as the same image. It follows the semantics of
+// github.com/docker/distribution/reference.
+package reference
+
+import "strings"
+
+const (
+	// DefaultDomain is the domain of images without a registry host.
+	DefaultDomain = "docker.io"
+
+	// OfficialRepoPrefix is the namespace of official images on Docker Hub.
+	OfficialRepoPrefix = "library/"
+)
+
+// SplitDomain splits a name such as localhost:5000/busybox into its
+// registry domain and the remaining path, as in localhost:5000 and busybox.
+// Following docker's rules, the first path component is only a domain if it
+// contains a '.' or ':', or is localhost. Otherwise, the domain is
+// DefaultDomain. Docker Hub's other hosts are normalized to DefaultDomain,
+// and its official images are prefixed with OfficialRepoPrefix, as in
+// docker.io and library/busybox.
+func SplitDomain(name string) (domain string, path string) {
+	domain, path = splitHost(name)
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
+	domain = NormalizeDomain(domain)
+
+	if domain == DefaultDomain && !strings.ContainsRune(path, '/') {
+		path = OfficialRepoPrefix + path
+	}
+
+	return domain, path
+}
+
+// HasDomain returns true if the name starts with a registry domain, as in
+// ghcr.io/org/busybox, rather than DefaultDomain being implied.
+func HasDomain(name string) bool {
+	domain, _ := splitHost(name)
+
+	return domain != ""
+}
+
+// NormalizeDomain returns DefaultDomain for Docker Hub's other hosts,
+// index.docker.io and registry-1.docker.io, and the domain otherwise.
+func NormalizeDomain(domain string) string {
+	switch domain {
+	case "index.docker.io", "registry-1.docker.io":
+		return DefaultDomain
+	}
+
+	return domain
+}
+
+// Domain returns the normalized registry domain of the name.
+func Domain(name string) string {
+	domain, _ := SplitDomain(name)
+
+	return domain
+}
+
+// Path returns the normalized path of the name on its registry, as in
+// library/busybox for busybox.
+func Path(name string) string {
+	_, path := SplitDomain(name)
+
+	return path
+}
+
+// Normalize returns the fully qualified name, as in
+// docker.io/library/busybox for busybox.
+func Normalize(name string) string {
+	domain, path := SplitDomain(name)
+
+	return domain + "/" + path
+}
+
+// Familiar returns the shortest name that docker would resolve to the same
+// image, as in busybox for docker.io/library/busybox.
+func Familiar(name string) string {
+	domain, path := SplitDomain(name)
+	if domain != DefaultDomain {
+		return domain + "/" + path
+	}
+
+	if strings.Count(path, "/") == 1 {
+		return strings.TrimPrefix(path, OfficialRepoPrefix)
+	}
+
+	return path
+}
+
+// splitHost splits the name into its registry host, if it has one, and the
+// remaining path.
+func splitHost(name string) (host string, path string) {
+	slash := strings.IndexByte(name, '/')
+	if slash == -1 {
+		return "", name
+	}
+
+	first := name[:slash]
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "", name
+	}
+
+	return first, name[slash+1:]
+}
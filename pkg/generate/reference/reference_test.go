@@ -0,0 +1,125 @@
+package reference_test
+
+import (
+	"testing"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+func TestReference(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name               string
+		Image              string
+		ExpectedNormalized string
+		ExpectedFamiliar   string
+		ExpectedHasDomain  bool
+	}{
+		{
+			Name:               "Official Image",
+			Image:              "python",
+			ExpectedNormalized: "docker.io/library/python",
+			ExpectedFamiliar:   "python",
+		},
+		{
+			Name:               "Official Image With Namespace",
+			Image:              "library/python",
+			ExpectedNormalized: "docker.io/library/python",
+			ExpectedFamiliar:   "python",
+		},
+		{
+			Name:               "Official Image With Domain",
+			Image:              "docker.io/library/python",
+			ExpectedNormalized: "docker.io/library/python",
+			ExpectedFamiliar:   "python",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Official Image Without Namespace",
+			Image:              "docker.io/python",
+			ExpectedNormalized: "docker.io/library/python",
+			ExpectedFamiliar:   "python",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Legacy Domain",
+			Image:              "index.docker.io/org/app",
+			ExpectedNormalized: "docker.io/org/app",
+			ExpectedFamiliar:   "org/app",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Registry Domain",
+			Image:              "registry-1.docker.io/python",
+			ExpectedNormalized: "docker.io/library/python",
+			ExpectedFamiliar:   "python",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Namespace",
+			Image:              "org/app",
+			ExpectedNormalized: "docker.io/org/app",
+			ExpectedFamiliar:   "org/app",
+		},
+		{
+			Name:               "Localhost",
+			Image:              "localhost/app",
+			ExpectedNormalized: "localhost/app",
+			ExpectedFamiliar:   "localhost/app",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Port",
+			Image:              "localhost:5000/app",
+			ExpectedNormalized: "localhost:5000/app",
+			ExpectedFamiliar:   "localhost:5000/app",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Other Registry",
+			Image:              "ghcr.io/org/app",
+			ExpectedNormalized: "ghcr.io/org/app",
+			ExpectedFamiliar:   "ghcr.io/org/app",
+			ExpectedHasDomain:  true,
+		},
+		{
+			Name:               "Nested Library Path",
+			Image:              "docker.io/library/org/app",
+			ExpectedNormalized: "docker.io/library/org/app",
+			ExpectedFamiliar:   "library/org/app",
+			ExpectedHasDomain:  true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := reference.Normalize(test.Image); got !=
+				test.ExpectedNormalized {
+				t.Fatalf(
+					"expected normalized %s, got %s",
+					test.ExpectedNormalized, got,
+				)
+			}
+
+			if got := reference.Familiar(test.Image); got !=
+				test.ExpectedFamiliar {
+				t.Fatalf(
+					"expected familiar %s, got %s", test.ExpectedFamiliar, got,
+				)
+			}
+
+			if got := reference.HasDomain(test.Image); got !=
+				test.ExpectedHasDomain {
+				t.Fatalf(
+					"expected has domain %t, got %t",
+					test.ExpectedHasDomain, got,
+				)
+			}
+		})
+	}
+}
@@ -2,98 +2,240 @@ package registry
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	c "github.com/docker/docker-credential-helpers/client"
 )
 
+// dockerHubServerAddress is the key for Docker Hub in docker's config.json
+// and the server url that credential helpers expect for Docker Hub.
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+// identityTokenUsername is the username that credential helpers return
+// when the secret is an identity token rather than a password.
+const identityTokenUsername = "<token>"
+
 // AuthCredentials contains a username and password required to
-// auth with a container registry.
+// auth with a container registry. If the registry issued an identity
+// token, such as when logging in with 'az acr login', IdentityToken is set
+// instead of Password.
 type AuthCredentials struct {
-	Username string
-	Password string
+	Username      string
+	Password      string
+	IdentityToken string
 }
 
-// AuthStringExtractor allows different registry wrappers to control
-// how the auth information is extracted from docker's config file.
-type AuthStringExtractor interface {
-	// ExtractAuthStr reads the base64 encoded auth string and the creds store
-	// from the configuration file's bytes.
-	ExtractAuthStr(confByt []byte) (authStr string, credStore string, err error)
-	// ServerURL is the URL of the login server.
-	ServerURL() string
+// CredentialResolver resolves the credentials for any registry host from
+// docker's config.json, in the same order as the docker cli:
+// (1) the credential helper in credHelpers for the host.
+// (2) the auth string or identity token in auths for the host.
+// (3) the credential helper in credsStore.
+// If none have credentials, empty credentials are returned so that the
+// registry is queried anonymously. Credentials are cached by host.
+type CredentialResolver struct {
+	configPath string
+	config     *dockerConfig
+	cache      map[string]*AuthCredentials
+	mutex      sync.Mutex
+}
+
+// dockerConfig represents the sections of docker's config.json that
+// contain credentials.
+type dockerConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// dockerConfigAuth represents an entry in the auths section of docker's
+// config.json.
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// NewCredentialResolver returns a CredentialResolver for docker's
+// config.json at configPath. If configPath is empty, or the file does not
+// exist, all registries are queried anonymously.
+func NewCredentialResolver(configPath string) *CredentialResolver {
+	return &CredentialResolver{
+		configPath: configPath,
+		cache:      map[string]*AuthCredentials{},
+	}
 }
 
-// NewAuthCredentials returns AuthCredentials according to the following rules:
-// (1) If a username and password are not empty, use them.
-// (2) Else if a username and password are empty:
-//		a. If the configPath is empty, return empty creds.
-//		b. Else, attempt to extract the base64 creds from the config file.
-//		c. If creds are empty, but the config file specifies a creds store,
-//		   retrieve the creds from the creds store.
-// (3) Return empty creds.
-func NewAuthCredentials(
+// Resolve returns the credentials for the registry host, such as ghcr.io
+// or docker.io. If username and password are not empty, they are returned
+// instead, so that wrappers may accept credentials from the environment.
+func (r *CredentialResolver) Resolve(
+	host string,
 	username string,
 	password string,
-	configPath string,
-	extractor AuthStringExtractor,
 ) (*AuthCredentials, error) {
 	if username != "" && password != "" {
-		return &AuthCredentials{
-			Username: username,
-			Password: password,
-		}, nil
+		return &AuthCredentials{Username: username, Password: password}, nil
 	}
 
-	if configPath == "" {
-		return &AuthCredentials{}, nil
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if authCreds, ok := r.cache[host]; ok {
+		return authCreds, nil
 	}
 
-	confByt, err := ioutil.ReadFile(configPath)
+	if r.config == nil {
+		config, err := readDockerConfig(r.configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		r.config = config
+	}
+
+	authCreds, err := r.config.authCredentials(host)
 	if err != nil {
 		return nil, err
 	}
 
-	authBase64, credsStore, err := extractor.ExtractAuthStr(confByt)
+	r.cache[host] = authCreds
+
+	return authCreds, nil
+}
+
+// readDockerConfig reads docker's config.json. If configPath is empty or
+// the file does not exist, the config is empty.
+func readDockerConfig(configPath string) (*dockerConfig, error) {
+	config := &dockerConfig{}
+
+	if configPath == "" {
+		return config, nil
+	}
+
+	confByt, err := ioutil.ReadFile(configPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+
 		return nil, err
 	}
 
+	if err = json.Unmarshal(confByt, config); err != nil {
+		return nil, fmt.Errorf(
+			"invalid docker config '%s': %s", configPath, err,
+		)
+	}
+
+	return config, nil
+}
+
+// authCredentials resolves the credentials for the host from the config.
+func (d *dockerConfig) authCredentials(host string) (*AuthCredentials, error) {
+	serverAddress := credentialServerAddress(host)
+
+	for _, key := range []string{serverAddress, host} {
+		if helper, ok := d.CredHelpers[key]; ok && helper != "" {
+			if authCreds := authCredsFromStore(
+				helper, serverAddress,
+			); authCreds != nil {
+				return authCreds, nil
+			}
+
+			break
+		}
+	}
+
+	// keys such as gcr.io and https://gcr.io/v1/ refer to the same host, so
+	// an exact match is preferred and the rest are tried in sorted order
+	authKeys := make([]string, 0, len(d.Auths))
+	for key := range d.Auths {
+		authKeys = append(authKeys, key)
+	}
+
+	sort.Strings(authKeys)
+
+	for _, key := range append([]string{serverAddress, host}, authKeys...) {
+		auth, ok := d.Auths[key]
+		if !ok || credentialServerAddress(hostOf(key)) != serverAddress {
+			continue
+		}
+
+		if auth.IdentityToken != "" {
+			return &AuthCredentials{IdentityToken: auth.IdentityToken}, nil
+		}
+
+		if auth.Auth != "" {
+			return decodeAuth(auth.Auth)
+		}
+	}
+
+	if d.CredsStore != "" {
+		if authCreds := authCredsFromStore(
+			d.CredsStore, serverAddress,
+		); authCreds != nil {
+			return authCreds, nil
+		}
+	}
+
+	return &AuthCredentials{}, nil
+}
+
+// decodeAuth decodes the base64 encoded username:password in docker's
+// config.json.
+func decodeAuth(authBase64 string) (*AuthCredentials, error) {
 	authByt, err := base64.StdEncoding.DecodeString(authBase64)
 	if err != nil {
 		return nil, err
 	}
 
-	authStr := string(authByt)
+	auth := strings.SplitN(string(authByt), ":", 2)
+	if len(auth) != 2 {
+		return nil, fmt.Errorf("invalid auth string in docker config")
+	}
 
-	switch {
-	case authStr != "":
-		auth := strings.Split(authStr, ":")
-		return &AuthCredentials{Username: auth[0], Password: auth[1]}, nil
-	case credsStore != "":
-		authCreds, err := authCredsFromStore(credsStore, extractor.ServerURL())
-		if err != nil {
-			return &AuthCredentials{}, nil
-		}
+	return &AuthCredentials{Username: auth[0], Password: auth[1]}, nil
+}
 
-		return authCreds, nil
+// credentialServerAddress returns the server address that docker uses for
+// credentials for the host. Docker Hub's hosts share one address.
+func credentialServerAddress(host string) string {
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubServerAddress
 	}
 
-	return &AuthCredentials{}, nil
+	return host
+}
+
+// hostOf returns the host of a key in the auths section of docker's
+// config.json, which may be a url such as https://ghcr.io/v1/.
+func hostOf(key string) string {
+	if key == dockerHubServerAddress {
+		return "docker.io"
+	}
+
+	key = strings.TrimPrefix(key, "https://")
+	key = strings.TrimPrefix(key, "http://")
+
+	return strings.SplitN(key, "/", 2)[0]
 }
 
 // authCredsFromStore reads auth from a creds store such as
 // wincred, pass, or osxkeychain by shelling out to docker-credential-helper.
+// If the store does not have credentials for the server, nil is returned.
 func authCredsFromStore(
 	credsStore string,
 	serverURL string,
-) (authCreds *AuthCredentials, err error) {
+) (authCreds *AuthCredentials) {
 	defer func() {
 		if err := recover(); err != nil {
-			authCreds = &AuthCredentials{}
-			return
+			authCreds = nil
 		}
 	}()
 
@@ -102,11 +244,15 @@ func authCredsFromStore(
 
 	credRes, err := c.Get(p, serverURL)
 	if err != nil {
-		return authCreds, err
+		return nil
+	}
+
+	if credRes.Username == identityTokenUsername {
+		return &AuthCredentials{IdentityToken: credRes.Secret}
 	}
 
 	return &AuthCredentials{
 		Username: credRes.Username,
 		Password: credRes.Secret,
-	}, nil
+	}
 }
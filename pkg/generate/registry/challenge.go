@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Challenge contains the parameters of a WWW-Authenticate header returned
+// by a registry, as described in the Token Authentication Specification:
+// https://docs.docker.com/registry/spec/auth/token/
+type Challenge struct {
+	Scheme  string
+	Realm   string
+	Service string
+	Scope   string
+}
+
+// ParseChallenge parses the value of a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+func ParseChallenge(header string) (*Challenge, error) {
+	header = strings.TrimSpace(header)
+
+	schemeAndParams := strings.SplitN(header, " ", 2)
+	if schemeAndParams[0] == "" {
+		return nil, fmt.Errorf("invalid challenge '%s'", header)
+	}
+
+	challenge := &Challenge{Scheme: strings.ToLower(schemeAndParams[0])}
+
+	if len(schemeAndParams) == 1 {
+		return challenge, nil
+	}
+
+	params, err := parseChallengeParams(schemeAndParams[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge '%s': %s", header, err)
+	}
+
+	challenge.Realm = params["realm"]
+	challenge.Service = params["service"]
+	challenge.Scope = params["scope"]
+
+	if challenge.Scheme == "bearer" && challenge.Realm == "" {
+		return nil, fmt.Errorf("bearer challenge '%s' has no realm", header)
+	}
+
+	return challenge, nil
+}
+
+// parseChallengeParams parses comma separated key="value" pairs. Values
+// may be unquoted and quoted values may contain commas, as in
+// scope="repository:foo:pull,push".
+func parseChallengeParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("missing '=' in '%s'", s)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+
+		var val string
+
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+
+			var b strings.Builder
+
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' && end+1 < len(s) {
+					end++
+				}
+
+				b.WriteByte(s[end])
+			}
+
+			if end == len(s) {
+				return nil, fmt.Errorf("unterminated quote for '%s'", key)
+			}
+
+			val = b.String()
+			s = s[end+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end == -1 {
+				end = len(s)
+			}
+
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = val
+
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+		s = strings.TrimSpace(s)
+	}
+
+	return params, nil
+}
@@ -1,6 +1,10 @@
 package contrib
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
@@ -33,3 +37,96 @@ func TestAllWrappers(t *testing.T) {
 		t.Fatal("expected MCRWrapper")
 	}
 }
+
+// TestElasticWrapper ensures that ElasticWrapper answers the registry's
+// bearer challenge with an anonymous token.
+func TestElasticWrapper(t *testing.T) {
+	t.Parallel()
+
+	const digest = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+
+	tests := []struct {
+		Name       string
+		Repo       string
+		ShouldFail bool
+	}{
+		{
+			Name: "Anonymous",
+			Repo: "docker.elastic.co/elasticsearch/elasticsearch",
+		},
+		{
+			Name:       "Not Found",
+			Repo:       "docker.elastic.co/elasticsearch/missing",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockElasticServer(t, digest)
+			defer server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			wrapper := NewElasticWrapper(client, "")
+
+			gotDigest, err := wrapper.Digest(test.Repo, "latest")
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotDigest != digest {
+				t.Fatalf("expected %s, got %s", digest, gotDigest)
+			}
+		})
+	}
+}
+
+// mockElasticServer fakes a registry that challenges clients to request a
+// bearer token from /token, which is granted anonymously.
+func mockElasticServer(t *testing.T, digest string) *httptest.Server {
+	t.Helper()
+
+	const registryToken = "REGISTRY"
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			switch path := req.URL.Path; {
+			case path == "/token":
+				fmt.Fprintf(res, `{"token": "%s"}`, registryToken)
+			case req.Header.Get("Authorization") != "Bearer "+registryToken:
+				res.Header().Set(
+					"WWW-Authenticate", fmt.Sprintf(
+						`Bearer realm="%s/token",service="%s"`,
+						server.URL, "token-service",
+					),
+				)
+				res.WriteHeader(http.StatusUnauthorized)
+			case strings.HasSuffix(
+				path, "/elasticsearch/elasticsearch/manifests/latest",
+			):
+				res.Header().Set("Docker-Content-Digest", digest)
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+	return server
+}
@@ -2,7 +2,6 @@ package contrib
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
@@ -10,24 +9,25 @@ import (
 
 // ElasticWrapper is a registry wrapper for the Elasticsearch registry.
 type ElasticWrapper struct {
-	client *registry.HTTPClient
+	client      *registry.HTTPClient
+	credentials *registry.CredentialResolver
 }
 
-// NewElasticWrapper creates an ElasticWrapper.
-func NewElasticWrapper(client *registry.HTTPClient) *ElasticWrapper {
-	w := &ElasticWrapper{}
-
-	if client == nil {
-		w.client = &registry.HTTPClient{
-			Client:      &http.Client{},
-			RegistryURL: fmt.Sprintf("https://%sv2", w.Prefix()),
-			TokenURL: fmt.Sprintf(
-				"https://docker-auth.elastic.co/auth%s",
-				"?scope=repository:%s:pull&service=token-service",
-			),
-		}
+// NewElasticWrapper creates an ElasticWrapper. Credentials for
+// docker.elastic.co are read from docker's config.json at configPath, if it
+// has any.
+func NewElasticWrapper(
+	client *registry.HTTPClient,
+	configPath string,
+) *ElasticWrapper {
+	w := &ElasticWrapper{
+		credentials: registry.NewCredentialResolver(configPath),
 	}
 
+	w.client = registry.NewHTTPClient(
+		client, fmt.Sprintf("https://%sv2", w.Prefix()), "",
+	)
+
 	return w
 }
 
@@ -35,9 +35,9 @@ func NewElasticWrapper(client *registry.HTTPClient) *ElasticWrapper {
 func init() { // nolint: gochecknoinits
 	constructor := func(
 		client *registry.HTTPClient,
-		_ string,
+		configPath string,
 	) (registry.Wrapper, error) {
-		return NewElasticWrapper(client), nil
+		return NewElasticWrapper(client, configPath), nil
 	}
 
 	constructors = append(constructors, constructor)
@@ -45,21 +45,35 @@ func init() { // nolint: gochecknoinits
 
 // Digest queries the container registry for the digest given a repo and ref.
 func (e *ElasticWrapper) Digest(repo string, ref string) (string, error) {
-	repo = strings.Replace(repo, e.Prefix(), "", 1)
+	manifest, err := e.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
 
-	tokenURL := fmt.Sprintf(e.client.TokenURL, repo)
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref.
+func (e *ElasticWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
+	repo = strings.Replace(repo, e.Prefix(), "", 1)
 
 	r, err := registry.NewV2(e.client)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	token, err := r.Token(tokenURL, "", "", &registry.DefaultTokenExtractor{})
+	authCreds, err := e.credentials.Resolve(
+		strings.TrimSuffix(e.Prefix(), "/"), "", "",
+	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return r.Digest(repo, ref, token)
+	return r.ResolveManifestWithCredentials(repo, ref, authCreds)
 }
 
 // Prefix returns the registry prefix that identifies the Elasticsearch
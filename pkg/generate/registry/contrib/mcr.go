@@ -4,7 +4,6 @@ package contrib
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
@@ -12,20 +11,24 @@ import (
 
 // MCRWrapper is a registry wrapper for Microsoft Container Registry.
 type MCRWrapper struct {
-	client *registry.HTTPClient
+	client      *registry.HTTPClient
+	credentials *registry.CredentialResolver
 }
 
-// NewMCRWrapper creates an MCRWrapper.
-func NewMCRWrapper(client *registry.HTTPClient) *MCRWrapper {
-	w := &MCRWrapper{}
-
-	if client == nil {
-		w.client = &registry.HTTPClient{
-			Client:      &http.Client{},
-			RegistryURL: fmt.Sprintf("https://%sv2", w.Prefix()),
-		}
+// NewMCRWrapper creates an MCRWrapper. Credentials for mcr.microsoft.com are
+// read from docker's config.json at configPath, if it has any.
+func NewMCRWrapper(
+	client *registry.HTTPClient,
+	configPath string,
+) *MCRWrapper {
+	w := &MCRWrapper{
+		credentials: registry.NewCredentialResolver(configPath),
 	}
 
+	w.client = registry.NewHTTPClient(
+		client, fmt.Sprintf("https://%sv2", w.Prefix()), "",
+	)
+
 	return w
 }
 
@@ -33,9 +36,9 @@ func NewMCRWrapper(client *registry.HTTPClient) *MCRWrapper {
 func init() { // nolint: gochecknoinits
 	constructor := func(
 		client *registry.HTTPClient,
-		_ string,
+		configPath string,
 	) (registry.Wrapper, error) {
-		return NewMCRWrapper(client), nil
+		return NewMCRWrapper(client, configPath), nil
 	}
 
 	constructors = append(constructors, constructor)
@@ -43,14 +46,37 @@ func init() { // nolint: gochecknoinits
 
 // Digest queries the container registry for the digest given a repo and ref.
 func (m *MCRWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := m.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref.
+func (m *MCRWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
 	repo = strings.Replace(repo, m.Prefix(), "", 1)
 
 	r, err := registry.NewV2(m.client)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	authCreds, err := m.credentials.Resolve(
+		strings.TrimSuffix(m.Prefix(), "/"), "", "",
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	return r.Digest(repo, ref, "")
+	return r.ResolveManifestWithCredentials(
+		repo, ref, authCreds,
+	)
 }
 
 // Prefix returns the registry prefix that identifies MCR.
@@ -3,34 +3,20 @@
 package firstparty
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
-	"strings"
 
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
 )
 
 // DockerWrapper is a registry wrapper for Docker Hub. It supports public
 // and private repositories.
 type DockerWrapper struct {
-	client *registry.HTTPClient
-	*registry.AuthCredentials
-}
-
-// dockerAuthExtractor is used to extract the base64 encoded auth string from
-// docker's config.
-type dockerAuthExtractor struct{}
-
-// dockerConfig represents the section in docker's config.json for Docker Hub.
-type dockerConfig struct {
-	Auths struct {
-		Index struct {
-			Auth string `json:"auth"`
-		} `json:"https://index.docker.io/v1/"`
-	} `json:"auths"`
-	CredsStore string `json:"credsStore"`
+	client      *registry.HTTPClient
+	credentials *registry.CredentialResolver
+	username    string
+	password    string
 }
 
 // init registers DockerWrapper for use by docker-lock.
@@ -57,9 +43,9 @@ func init() { // nolint: gochecknoinits
 // if not possible.
 //
 // If username and password are defined, then they will be used for
-// authentication. Otherwise, the username and password will be obtained
-// from docker's config.json. For this to work, please login using
-// 'docker login'.
+// authentication. Otherwise, credentials will be obtained from docker's
+// config.json the first time they are needed. For this to work, please
+// login using 'docker login'.
 //
 // If using the cli, to set the username and password, ensure
 // DOCKER_USERNAME and DOCKER_PASSWORD are set. This can be achieved
@@ -71,71 +57,66 @@ func NewDockerWrapper(
 	username string,
 	password string,
 ) (*DockerWrapper, error) {
-	if client == nil {
-		client = &registry.HTTPClient{
-			Client:      &http.Client{},
-			RegistryURL: "https://registry-1.docker.io/v2",
-			TokenURL: fmt.Sprintf(
-				"https://auth.docker.io/token%s",
-				"?scope=repository:%s:pull&service=registry.docker.io",
-			),
-		}
-	}
-
-	w := &DockerWrapper{client: client}
-
-	ac, err := registry.NewAuthCredentials(
-		username, password, configPath, &dockerAuthExtractor{},
+	client = registry.NewHTTPClient(
+		client, "https://registry-1.docker.io/v2", "",
 	)
-	if err != nil {
-		return nil, err
-	}
 
-	w.AuthCredentials = ac
-
-	return w, nil
+	return &DockerWrapper{
+		client:      client,
+		credentials: registry.NewCredentialResolver(configPath),
+		username:    username,
+		password:    password,
+	}, nil
 }
 
 // Digest queries the container registry for the digest given a repo and ref.
 func (d *DockerWrapper) Digest(repo string, ref string) (string, error) {
-	// Docker-Content-Digest is the root of the hash chain
-	// https://github.com/docker/distribution/issues/1662
-	repo = strings.Replace(repo, "docker.io/", "", 1)
+	manifest, err := d.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
 
-	if repo == "scratch" {
+	// scratch has neither a manifest nor a digest
+	if len(manifest.Body) == 0 && manifest.Digest == "" {
 		return "", nil
 	}
 
-	var repos []string
+	return manifest.HeaderDigest(repo, ref)
+}
 
-	if strings.Contains(repo, "/") {
-		repos = []string{repo, "library/" + repo}
-	} else {
-		repos = []string{"library/" + repo, repo}
+// Manifest queries the container registry for the manifest given a repo
+// and ref. The repo is normalized, so busybox, library/busybox,
+// docker.io/library/busybox, and index.docker.io/busybox are the same.
+func (d *DockerWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
+	if reference.Familiar(repo) == "scratch" {
+		return &registry.Manifest{}, nil
 	}
 
-	for _, repo := range repos {
-		tokenURL := fmt.Sprintf(d.client.TokenURL, repo)
+	path := reference.Path(repo)
 
-		r, err := registry.NewV2(d.client)
-		if err != nil {
-			return "", err
-		}
+	r, err := registry.NewV2(d.client)
+	if err != nil {
+		return nil, err
+	}
 
-		token, err := r.Token(
-			tokenURL, d.Username, d.Password, &registry.DefaultTokenExtractor{},
-		)
-		if err != nil {
-			return "", err
-		}
+	authCreds, err := d.credentials.Resolve(
+		"docker.io", d.username, d.password,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		digest, _ := r.Digest(repo, ref, token)
-		if digest != "" {
-			return digest, nil
-		}
+	manifest, err := r.ResolveManifestWithCredentials(path, ref, authCreds)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"no manifest found for '%s:%s': %s", repo, ref, err,
+		)
 	}
 
-	return "", fmt.Errorf("no digest found for '%s:%s'", repo, ref)
+	return manifest, nil
 }
 
 // Prefix returns an empty string since images on Docker Hub do not use a
@@ -143,21 +124,3 @@ func (d *DockerWrapper) Digest(repo string, ref string) (string, error) {
 func (d *DockerWrapper) Prefix() string {
 	return ""
 }
-
-// ExtractAuthStr returns the base64 encoded auth string and creds store.
-func (d *dockerAuthExtractor) ExtractAuthStr(
-	confByt []byte,
-) (string, string, error) {
-	conf := dockerConfig{}
-	if err := json.Unmarshal(confByt, &conf); err != nil {
-		return "", "", err
-	}
-
-	return conf.Auths.Index.Auth, conf.CredsStore, nil
-}
-
-// ServerURL returns the login server for DockerHub to be used
-// by the creds store.
-func (d *dockerAuthExtractor) ServerURL() string {
-	return "https://index.docker.io/v1/"
-}
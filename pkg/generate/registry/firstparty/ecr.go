@@ -0,0 +1,338 @@
+package firstparty
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+)
+
+// ecrHostRegex matches the hosts of private ECR registries, as in
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com, capturing the account id,
+// the region, and the domain's suffix for regions such as China.
+var ecrHostRegex = regexp.MustCompile( // nolint: gochecknoglobals
+	`^([0-9]+)\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.(amazonaws\.com(?:\.cn)?)$`,
+)
+
+// ecrTokenExpiryMargin is how long before an authorization token expires
+// that a new one is requested.
+const ecrTokenExpiryMargin = 5 * time.Minute
+
+// ECRWrapper is a registry wrapper for Amazon Elastic Container Registry.
+// Unlike other wrappers, it is not selected by prefix, but for any line that
+// starts with an ECR registry host, as in
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com/busybox.
+type ECRWrapper struct {
+	client         *registry.HTTPClient
+	credentials    *registry.CredentialResolver
+	endpoint       string
+	awsCredentials func() (*awsCredentials, error)
+	clients        map[string]*registry.HTTPClient
+	tokens         map[string]*ecrToken
+	mutex          sync.Mutex
+}
+
+// ecrToken is the password from an ECR authorization token, along with
+// when it expires.
+type ecrToken struct {
+	password  string
+	expiresAt time.Time
+}
+
+// ecrHost is an ECR registry host split into its parts.
+type ecrHost struct {
+	name      string
+	accountID string
+	region    string
+	domain    string
+}
+
+// getAuthorizationTokenResponse represents the response from ECR's
+// GetAuthorizationToken API.
+type getAuthorizationTokenResponse struct {
+	AuthorizationData []struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		ExpiresAt          float64 `json:"expiresAt"`
+	} `json:"authorizationData"`
+}
+
+// init registers ECRWrapper for use by docker-lock. If AWS_ENDPOINT_URL_ECR
+// is set, authorization tokens are requested from that endpoint instead of
+// the endpoint for the registry's region.
+func init() { // nolint: gochecknoinits
+	constructor := func(
+		client *registry.HTTPClient,
+		configPath string,
+	) (registry.Wrapper, error) {
+		return NewECRWrapper(
+			client, configPath, os.Getenv("AWS_ENDPOINT_URL_ECR"),
+		)
+	}
+
+	constructors = append(constructors, constructor)
+}
+
+// NewECRWrapper creates an ECRWrapper.
+//
+// Credentials for a registry are first read from docker's config.json, so
+// that registries configured to use docker-credential-ecr-login in
+// credHelpers, or logged into with 'docker login', work as with the docker
+// cli. Otherwise, an authorization token is requested from ECR's
+// GetAuthorizationToken API with AWS credentials from the environment
+// variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN
+// or the profile in AWS_PROFILE from the shared credentials file.
+//
+// If endpoint is empty, the API endpoint for the registry's region is used.
+func NewECRWrapper(
+	client *registry.HTTPClient,
+	configPath string,
+	endpoint string,
+) (*ECRWrapper, error) {
+	if endpoint != "" {
+		if _, err := url.Parse(endpoint); err != nil {
+			return nil, fmt.Errorf(
+				"invalid ecr endpoint '%s': %s", endpoint, err,
+			)
+		}
+	}
+
+	return &ECRWrapper{
+		client:         client,
+		credentials:    registry.NewCredentialResolver(configPath),
+		endpoint:       endpoint,
+		awsCredentials: defaultAWSCredentials,
+		clients:        map[string]*registry.HTTPClient{},
+		tokens:         map[string]*ecrToken{},
+	}, nil
+}
+
+// Digest queries the container registry for the digest given a repo and ref.
+func (e *ECRWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := e.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref. The repo must start with an ECR registry host.
+func (e *ECRWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
+	host, path, err := splitECRRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	authCreds, err := e.authCredentials(host)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := registry.NewV2(e.hostClient(host.name))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ResolveManifestWithCredentials(path, ref, authCreds)
+}
+
+// Prefix returns an empty string since ECRWrapper is selected by Matches.
+func (e *ECRWrapper) Prefix() string {
+	return ""
+}
+
+// Matches returns true if the line starts with an ECR registry host.
+func (e *ECRWrapper) Matches(line string) bool {
+	_, _, err := splitECRRepo(line)
+	return err == nil
+}
+
+// splitECRRepo splits a repo into its ECR registry host and the remaining
+// path.
+func splitECRRepo(repo string) (*ecrHost, string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+
+	const numParts = 2
+
+	var matches []string
+	if len(parts) == numParts {
+		matches = ecrHostRegex.FindStringSubmatch(parts[0])
+	}
+
+	if matches == nil {
+		return nil, "", fmt.Errorf(
+			"'%s' does not start with an ecr registry host", repo,
+		)
+	}
+
+	return &ecrHost{
+		name:      parts[0],
+		accountID: matches[1],
+		region:    matches[2],
+		domain:    matches[3],
+	}, parts[1], nil
+}
+
+// hostClient returns the client for the registry host. Clients are reused
+// so that tokens are cached across queries to the same host.
+func (e *ECRWrapper) hostClient(host string) *registry.HTTPClient {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	client, ok := e.clients[host]
+	if !ok {
+		client = registry.NewHTTPClient(
+			e.client, fmt.Sprintf("https://%s/v2", host), "",
+		)
+		e.clients[host] = client
+	}
+
+	return client
+}
+
+// authCredentials returns the credentials for the registry host from
+// docker's config.json or, if there are none, from ECR's
+// GetAuthorizationToken API.
+func (e *ECRWrapper) authCredentials(
+	host *ecrHost,
+) (*registry.AuthCredentials, error) {
+	authCreds, err := e.credentials.Resolve(host.name, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if authCreds.Username != "" && authCreds.Password != "" {
+		return authCreds, nil
+	}
+
+	password, err := e.authorizationToken(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registry.AuthCredentials{Username: "AWS", Password: password}, nil
+}
+
+// authorizationToken returns the password from an authorization token for
+// the registry. Tokens are cached until shortly before they expire, which
+// is after 12 hours by default.
+func (e *ECRWrapper) authorizationToken(host *ecrHost) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if token, ok := e.tokens[host.name]; ok &&
+		time.Now().Add(ecrTokenExpiryMargin).Before(token.expiresAt) {
+		return token.password, nil
+	}
+
+	awsCreds, err := e.awsCredentials()
+	if err != nil {
+		return "", fmt.Errorf(
+			"no credentials for '%s' in docker's config or aws: %s",
+			host.name, err,
+		)
+	}
+
+	token, err := e.getAuthorizationToken(host, awsCreds)
+	if err != nil {
+		return "", err
+	}
+
+	e.tokens[host.name] = token
+
+	return token.password, nil
+}
+
+// getAuthorizationToken calls ECR's GetAuthorizationToken API for the
+// registry's account in the registry's region.
+func (e *ECRWrapper) getAuthorizationToken(
+	host *ecrHost,
+	awsCreds *awsCredentials,
+) (*ecrToken, error) {
+	endpoint := e.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(
+			"https://api.ecr.%s.%s/", host.region, host.domain,
+		)
+	}
+
+	body, err := json.Marshal(map[string][]string{
+		"registryIds": {host.accountID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost, endpoint, bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set(
+		"X-Amz-Target",
+		"AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken",
+	)
+
+	signRequest(req, body, awsCreds, host.region, "ecr", time.Now())
+
+	client := registry.NewHTTPClient(e.client, "", "")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"failed to get an ecr authorization token for '%s', "+
+				"got status %d",
+			host.name, resp.StatusCode,
+		)
+	}
+
+	var tokenResp getAuthorizationTokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, err
+	}
+
+	if len(tokenResp.AuthorizationData) == 0 {
+		return nil, fmt.Errorf(
+			"no ecr authorization token returned for '%s'", host.name,
+		)
+	}
+
+	data := tokenResp.AuthorizationData[0]
+
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := strings.SplitN(string(decoded), ":", 2)
+	if len(auth) != 2 {
+		return nil, errors.New("invalid ecr authorization token")
+	}
+
+	return &ecrToken{
+		password:  auth[1],
+		expiresAt: time.Unix(int64(data.ExpiresAt), 0),
+	}, nil
+}
@@ -1,7 +1,27 @@
 package firstparty
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
 )
@@ -22,3 +42,1001 @@ func TestDefaultWrapper(t *testing.T) {
 		t.Fatal("expected DockerWrapper")
 	}
 }
+
+func TestECRWrapper(t *testing.T) {
+	t.Parallel()
+
+	const (
+		host     = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+		password = "PASSWORD"
+		digest   = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+	)
+
+	awsAuth := base64.StdEncoding.EncodeToString([]byte("AWS:" + password))
+
+	tests := []struct {
+		Name             string
+		Repo             string
+		DockerConfig     string
+		AWSCredentials   *awsCredentials
+		ExpectedMatch    bool
+		ExpectedAPICalls int
+		ShouldFail       bool
+	}{
+		{
+			Name: "AWS Credentials",
+			Repo: host + "/busybox",
+			AWSCredentials: &awsCredentials{
+				AccessKeyID:     "AKID",
+				SecretAccessKey: "SECRET",
+			},
+			ExpectedMatch:    true,
+			ExpectedAPICalls: 1,
+		},
+		{
+			Name: "Docker Config Credentials",
+			Repo: host + "/busybox",
+			DockerConfig: fmt.Sprintf(
+				`{"auths": {"%s": {"auth": "%s"}}}`, host, awsAuth,
+			),
+			ExpectedMatch: true,
+		},
+		{
+			Name:          "No Credentials",
+			Repo:          host + "/busybox",
+			ExpectedMatch: true,
+			ShouldFail:    true,
+		},
+		{
+			Name: "Not ECR",
+			Repo: "ghcr.io/busybox",
+			AWSCredentials: &awsCredentials{
+				AccessKeyID:     "AKID",
+				SecretAccessKey: "SECRET",
+			},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				apiCalls int
+				mutex    sync.Mutex
+			)
+
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(res http.ResponseWriter, req *http.Request) {
+						username, pass, _ := req.BasicAuth()
+
+						switch {
+						case req.Method == http.MethodPost:
+							mutex.Lock()
+							apiCalls++
+							mutex.Unlock()
+
+							if !strings.HasPrefix(
+								req.Header.Get("Authorization"),
+								"AWS4-HMAC-SHA256 Credential=AKID/",
+							) {
+								res.WriteHeader(http.StatusForbidden)
+								return
+							}
+
+							fmt.Fprintf(
+								res,
+								`{"authorizationData": [{"authorizationToken": "%s", "expiresAt": %d}]}`, // nolint: lll
+								awsAuth, time.Now().Add(time.Hour).Unix(),
+							)
+						case username != "AWS" || pass != password:
+							res.Header().Set(
+								"WWW-Authenticate", `Basic realm="ecr"`,
+							)
+							res.WriteHeader(http.StatusUnauthorized)
+						case req.URL.Path == "/v2/busybox/manifests/latest":
+							res.Header().Set(
+								"Docker-Content-Digest", digest,
+							)
+						}
+					},
+				),
+			)
+			defer server.Close()
+
+			configPath := ""
+
+			if test.DockerConfig != "" {
+				tempDir, err := ioutil.TempDir("", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(tempDir)
+
+				configPath = filepath.Join(tempDir, "config.json")
+
+				if err = ioutil.WriteFile(
+					configPath, []byte(test.DockerConfig), 0600,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			wrapper, err := NewECRWrapper(client, configPath, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wrapper.awsCredentials = func() (*awsCredentials, error) {
+				if test.AWSCredentials == nil {
+					return nil, errors.New("no aws credentials")
+				}
+
+				return test.AWSCredentials, nil
+			}
+
+			manager := registry.NewWrapperManager(nil)
+			manager.Add(wrapper)
+
+			if match := manager.Wrapper(test.Repo) == wrapper; match !=
+				test.ExpectedMatch {
+				t.Fatalf(
+					"expected match %t for '%s', got %t",
+					test.ExpectedMatch, test.Repo, match,
+				)
+			}
+
+			var gotDigest string
+
+			for i := 0; i < 2; i++ {
+				gotDigest, err = wrapper.Digest(test.Repo, "latest")
+				if test.ShouldFail {
+					if err == nil {
+						t.Fatal("expected error but did not get one")
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if gotDigest != digest {
+				t.Fatalf("expected %s, got %s", digest, gotDigest)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if apiCalls != test.ExpectedAPICalls {
+				t.Fatalf(
+					"expected %d GetAuthorizationToken calls, got %d",
+					test.ExpectedAPICalls, apiCalls,
+				)
+			}
+		})
+	}
+}
+
+// TestSignRequest ensures that requests are signed as in the get-vanilla
+// example from the AWS Signature Version 4 test suite.
+func TestSignRequest(t *testing.T) {
+	t.Parallel()
+
+	req, err := http.NewRequest(
+		http.MethodGet, "https://example.amazonaws.com/", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signRequest(
+		req, nil, &awsCredentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		}, "us-east-1", "service",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC),
+	)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31" // nolint: lll
+
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestReadSharedCredentials(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "credentials")
+
+	if err = ioutil.WriteFile(path, []byte(`
+[default]
+aws_access_key_id = DEFAULT
+aws_secret_access_key = DEFAULTSECRET
+
+# production account
+[prod]
+aws_access_key_id=PROD
+aws_secret_access_key=PRODSECRET
+aws_session_token=PRODTOKEN
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name       string
+		Profile    string
+		Expected   *awsCredentials
+		ShouldFail bool
+	}{
+		{
+			Name:    "Default Profile",
+			Profile: "default",
+			Expected: &awsCredentials{
+				AccessKeyID:     "DEFAULT",
+				SecretAccessKey: "DEFAULTSECRET",
+			},
+		},
+		{
+			Name:    "Named Profile",
+			Profile: "prod",
+			Expected: &awsCredentials{
+				AccessKeyID:     "PROD",
+				SecretAccessKey: "PRODSECRET",
+				SessionToken:    "PRODTOKEN",
+			},
+		},
+		{
+			Name:       "Missing Profile",
+			Profile:    "dev",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := readSharedCredentials(path, test.Profile)
+		if test.ShouldFail {
+			if err == nil {
+				t.Fatalf("%s: expected error but did not get one", test.Name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(test.Expected, got) {
+			t.Fatalf("%s: expected %+v, got %+v", test.Name, test.Expected, got)
+		}
+	}
+}
+
+func TestGCRWrapper(t *testing.T) {
+	t.Parallel()
+
+	const (
+		accessToken = "ACCESS"
+		digest      = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+	)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+
+	tests := []struct {
+		Name          string
+		Repo          string
+		Credentials   map[string]string
+		DockerConfig  string
+		ExpectedMatch bool
+		ShouldFail    bool
+	}{
+		{
+			Name: "Service Account",
+			Repo: "us-docker.pkg.dev/project/repo/busybox",
+			Credentials: map[string]string{
+				"type":         "service_account",
+				"client_email": "sa@project.iam.gserviceaccount.com",
+				"private_key":  privateKey,
+			},
+			ExpectedMatch: true,
+		},
+		{
+			Name: "Authorized User",
+			Repo: "eu.gcr.io/project/busybox",
+			Credentials: map[string]string{
+				"type":          "authorized_user",
+				"client_id":     "id",
+				"client_secret": "secret",
+				"refresh_token": "REFRESH",
+			},
+			ExpectedMatch: true,
+		},
+		{
+			Name: "Docker Config Credentials",
+			Repo: "gcr.io/project/busybox",
+			DockerConfig: fmt.Sprintf(
+				`{"auths": {"gcr.io": {"auth": "%s"}}}`,
+				base64.StdEncoding.EncodeToString(
+					[]byte(gcrUsername+":"+accessToken),
+				),
+			),
+			ExpectedMatch: true,
+		},
+		{
+			Name:          "Anonymous",
+			Repo:          "gcr.io/distroless/busybox",
+			ExpectedMatch: true,
+		},
+		{
+			Name: "Invalid Refresh Token",
+			Repo: "gcr.io/project/busybox",
+			Credentials: map[string]string{
+				"type":          "authorized_user",
+				"refresh_token": "INVALID",
+			},
+			ExpectedMatch: true,
+			ShouldFail:    true,
+		},
+		{
+			Name: "Invalid Refresh Token Public Image",
+			Repo: "gcr.io/distroless/busybox",
+			Credentials: map[string]string{
+				"type":          "authorized_user",
+				"refresh_token": "INVALID",
+			},
+			ExpectedMatch: true,
+		},
+		{
+			Name:       "Not GCR",
+			Repo:       "ghcr.io/project/busybox",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockGCRServer(t, &key.PublicKey, accessToken, digest)
+			defer server.Close()
+
+			tempDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			configPath := filepath.Join(tempDir, "config.json")
+			credentialsFile := filepath.Join(tempDir, "credentials.json")
+
+			if test.DockerConfig != "" {
+				if err = ioutil.WriteFile(
+					configPath, []byte(test.DockerConfig), 0600,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.Credentials != nil {
+				test.Credentials["token_uri"] = server.URL + "/oauth2/token"
+
+				var credsByt []byte
+
+				if credsByt, err = json.Marshal(test.Credentials); err != nil {
+					t.Fatal(err)
+				}
+
+				if err = ioutil.WriteFile(
+					credentialsFile, credsByt, 0600,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			wrapper, err := NewGCRWrapper(client, configPath, credentialsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manager := registry.NewWrapperManager(nil)
+			manager.Add(wrapper)
+
+			if match := manager.Wrapper(test.Repo) == wrapper; match !=
+				test.ExpectedMatch {
+				t.Fatalf(
+					"expected match %t for '%s', got %t",
+					test.ExpectedMatch, test.Repo, match,
+				)
+			}
+
+			gotDigest, err := wrapper.Digest(test.Repo, "latest")
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotDigest != digest {
+				t.Fatalf("expected %s, got %s", digest, gotDigest)
+			}
+		})
+	}
+}
+
+// TestGoogleAccessTokenExpiry ensures that access tokens without an
+// expires_in are cached for googleTokenExpiry.
+func TestGoogleAccessTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name             string
+		Response         string
+		ExpectedLifetime time.Duration
+	}{
+		{
+			Name:             "Expires In",
+			Response:         `{"access_token": "ACCESS", "expires_in": 60}`,
+			ExpectedLifetime: time.Minute,
+		},
+		{
+			Name:             "Missing Expires In",
+			Response:         `{"access_token": "ACCESS"}`,
+			ExpectedLifetime: googleTokenExpiry,
+		},
+		{
+			Name:             "Zero Expires In",
+			Response:         `{"access_token": "ACCESS", "expires_in": 0}`,
+			ExpectedLifetime: googleTokenExpiry,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(
+				func(res http.ResponseWriter, req *http.Request) {
+					fmt.Fprint(res, test.Response)
+				},
+			))
+			defer server.Close()
+
+			creds := &googleCredentials{
+				Type:         "authorized_user",
+				RefreshToken: "REFRESH",
+				TokenURI:     server.URL,
+			}
+
+			before := time.Now()
+
+			token, err := creds.accessToken(
+				&registry.HTTPClient{Client: server.Client()},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lifetime := token.expiresAt.Sub(before)
+			if lifetime < test.ExpectedLifetime ||
+				lifetime > test.ExpectedLifetime+time.Minute {
+				t.Fatalf(
+					"expected a lifetime of %s, got %s",
+					test.ExpectedLifetime, lifetime,
+				)
+			}
+		})
+	}
+}
+
+// mockGCRServer fakes Google's OAuth2 token server at /oauth2/token and a
+// registry whose token server at /v2/token grants access to private repos
+// for the access token, and to distroless repos anonymously.
+func mockGCRServer(
+	t *testing.T,
+	publicKey *rsa.PublicKey,
+	accessToken string,
+	digest string,
+) *httptest.Server {
+	t.Helper()
+
+	const registryToken = "REGISTRY"
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			switch path := req.URL.Path; {
+			case path == "/oauth2/token":
+				if err := req.ParseForm(); err != nil ||
+					!validGoogleGrant(req.PostForm, publicKey) {
+					res.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				fmt.Fprintf(
+					res, `{"access_token": "%s", "expires_in": 3600}`,
+					accessToken,
+				)
+			case path == "/v2/token":
+				username, password, _ := req.BasicAuth()
+				if !strings.Contains(
+					req.URL.Query().Get("scope"), "distroless/",
+				) && (username != gcrUsername || password != accessToken) {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprintf(res, `{"token": "%s"}`, registryToken)
+			case req.Header.Get("Authorization") != "Bearer "+registryToken:
+				res.Header().Set(
+					"WWW-Authenticate", fmt.Sprintf(
+						`Bearer realm="%s/v2/token",service="gcr.io"`,
+						server.URL,
+					),
+				)
+				res.WriteHeader(http.StatusUnauthorized)
+			case strings.HasSuffix(path, "/busybox/manifests/latest"):
+				res.Header().Set("Docker-Content-Digest", digest)
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+	return server
+}
+
+// validGoogleGrant returns true for a JWT signed by the service account's
+// private key, or the user's refresh token.
+func validGoogleGrant(form url.Values, publicKey *rsa.PublicKey) bool {
+	switch form.Get("grant_type") {
+	case "refresh_token":
+		return form.Get("refresh_token") == "REFRESH"
+	case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+		parts := strings.Split(form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			return false
+		}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+		return rsa.VerifyPKCS1v15(
+			publicKey, crypto.SHA256, hash[:], signature,
+		) == nil
+	default:
+		return false
+	}
+}
+
+// TestACRWrapper ensures that ACRWrapper answers the registry's bearer
+// challenge with credentials from the environment or docker's config.json.
+func TestACRWrapper(t *testing.T) {
+	t.Parallel()
+
+	const (
+		username = "user"
+		password = "pass"
+		digest   = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+	)
+
+	tests := []struct {
+		Name         string
+		Username     string
+		Password     string
+		DockerConfig string
+		ShouldFail   bool
+	}{
+		{
+			Name:     "Environment Credentials",
+			Username: username,
+			Password: password,
+		},
+		{
+			Name: "Docker Config Credentials",
+			DockerConfig: fmt.Sprintf(
+				`{"auths": {"myregistry.azurecr.io": {"auth": "%s"}}}`,
+				base64.StdEncoding.EncodeToString(
+					[]byte(username+":"+password),
+				),
+			),
+		},
+		{
+			Name:       "Invalid Credentials",
+			Username:   username,
+			Password:   "invalid",
+			ShouldFail: true,
+		},
+		{
+			Name:       "No Credentials",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockACRServer(t, username, password, digest)
+			defer server.Close()
+
+			tempDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			configPath := filepath.Join(tempDir, "config.json")
+
+			if test.DockerConfig != "" {
+				if err = ioutil.WriteFile(
+					configPath, []byte(test.DockerConfig), 0600,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			wrapper, err := NewACRWrapper(
+				client, configPath, test.Username, test.Password,
+				"myregistry",
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotDigest, err := wrapper.Digest(
+				"myregistry.azurecr.io/busybox", "latest",
+			)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotDigest != digest {
+				t.Fatalf("expected %s, got %s", digest, gotDigest)
+			}
+		})
+	}
+}
+
+// mockACRServer fakes a registry that challenges clients to request a bearer
+// token from /oauth2/token, which is only granted for the username and
+// password.
+func mockACRServer(
+	t *testing.T,
+	username string,
+	password string,
+	digest string,
+) *httptest.Server {
+	t.Helper()
+
+	const registryToken = "REGISTRY"
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			switch path := req.URL.Path; {
+			case path == "/oauth2/token":
+				gotUsername, gotPassword, _ := req.BasicAuth()
+				if gotUsername != username || gotPassword != password {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprintf(res, `{"access_token": "%s"}`, registryToken)
+			case req.Header.Get("Authorization") != "Bearer "+registryToken:
+				res.Header().Set(
+					"WWW-Authenticate", fmt.Sprintf(
+						`Bearer realm="%s/oauth2/token",service="%s"`,
+						server.URL, "myregistry.azurecr.io",
+					),
+				)
+				res.WriteHeader(http.StatusUnauthorized)
+			case strings.HasSuffix(path, "/busybox/manifests/latest"):
+				res.Header().Set("Docker-Content-Digest", digest)
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+	return server
+}
+
+func TestInternalWrapperFromConfig(t *testing.T) {
+	t.Parallel()
+
+	const digest = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+
+	tests := []struct {
+		Name       string
+		Config     *RegistryConfig
+		Repo       string
+		Env        map[string]string
+		ShouldFail bool
+	}{
+		{
+			Name: "Challenge With Docker Config Credentials",
+			Config: &RegistryConfig{
+				Prefix:      "my-org/",
+				StripPrefix: true,
+			},
+			Repo: "my-org/busybox",
+		},
+		{
+			Name: "Token URL",
+			Config: &RegistryConfig{
+				Prefix:   "my-org/",
+				TokenURL: "/token?scope=repository:<REPO>:pull",
+			},
+			Repo: "my-org/busybox",
+		},
+		{
+			Name: "Challenge With Env Credentials",
+			Config: &RegistryConfig{
+				Prefix:      "my-org/",
+				Auth:        ChallengeAuthMethod,
+				Credentials: "env:INTERNAL_CONFIG_TEST",
+				StripPrefix: true,
+			},
+			Repo: "my-org/busybox",
+			Env: map[string]string{
+				"INTERNAL_CONFIG_TEST_USERNAME": "user",
+				"INTERNAL_CONFIG_TEST_PASSWORD": "pass",
+			},
+		},
+		{
+			Name: "No Credentials",
+			Config: &RegistryConfig{
+				Prefix:      "my-org/",
+				Credentials: NoCredentialSource,
+				StripPrefix: true,
+			},
+			Repo:       "my-org/busybox",
+			ShouldFail: true,
+		},
+		{
+			Name: "No Auth",
+			Config: &RegistryConfig{
+				Prefix:      "my-org/",
+				Auth:        NoneAuthMethod,
+				StripPrefix: true,
+			},
+			Repo: "my-org/public/busybox",
+		},
+		{
+			Name:       "Empty Prefix",
+			Config:     &RegistryConfig{},
+			ShouldFail: true,
+		},
+		{
+			Name: "Token Auth Without Token URL",
+			Config: &RegistryConfig{
+				Prefix: "my-org/",
+				Auth:   TokenAuthMethod,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Unknown Auth",
+			Config: &RegistryConfig{
+				Prefix: "my-org/",
+				Auth:   "oauth",
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Unknown Credentials",
+			Config: &RegistryConfig{
+				Prefix:      "my-org/",
+				Credentials: "env:",
+			},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockInternalServer(t, digest)
+			defer server.Close()
+
+			tempDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			configPath := filepath.Join(tempDir, "config.json")
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			dockerConfig := fmt.Sprintf(
+				`{"auths": {"%s": {"auth": "%s"}}}`, host,
+				base64.StdEncoding.EncodeToString([]byte("user:pass")),
+			)
+
+			if err = ioutil.WriteFile(
+				configPath, []byte(dockerConfig), 0600,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			for k, v := range test.Env {
+				os.Setenv(k, v)
+			}
+
+			config := *test.Config
+			if config.Prefix != "" {
+				config.URL = server.URL
+			}
+
+			if config.TokenURL != "" {
+				config.TokenURL = server.URL + config.TokenURL
+			}
+
+			client := &registry.HTTPClient{Client: server.Client()}
+
+			wrapper, err := NewInternalWrapperFromConfig(
+				client, configPath, &config,
+			)
+			if err == nil {
+				var gotDigest string
+
+				gotDigest, err = wrapper.Digest(test.Repo, "latest")
+				if err == nil && gotDigest != digest {
+					t.Fatalf("expected %s, got %s", digest, gotDigest)
+				}
+			}
+
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestInternalWrapperEmptyPrefix ensures that, as before registries could be
+// declared in the configuration file, an InternalWrapper from the
+// environment may have an empty prefix and is never selected, whereas a
+// declared registry must have a prefix.
+func TestInternalWrapperEmptyPrefix(t *testing.T) {
+	t.Parallel()
+
+	const registryURL = "http://localhost:5000"
+
+	wrapper, err := NewInternalWrapper(nil, "", "", false, registryURL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := registry.NewWrapperManager(nil)
+	manager.Add(wrapper)
+
+	if manager.Wrapper("localhost:5000/busybox") != nil {
+		t.Fatal("expected the wrapper with an empty prefix to not be selected")
+	}
+
+	if _, err = NewInternalWrapperFromConfig(
+		nil, "", &RegistryConfig{URL: registryURL},
+	); err == nil {
+		t.Fatal("expected error but did not get one")
+	}
+}
+
+// mockInternalServer is a registry whose token server grants access to
+// user:pass, and that serves public repos anonymously.
+func mockInternalServer(t *testing.T, digest string) *httptest.Server {
+	t.Helper()
+
+	const token = "TOKEN"
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			path := req.URL.Path
+
+			switch {
+			case path == "/token":
+				username, password, _ := req.BasicAuth()
+				if username != "user" || password != "pass" {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprintf(res, `{"token": "%s"}`, token)
+
+				return
+			case strings.HasPrefix(path, "/v2/public/"):
+			case req.Header.Get("Authorization") != "Bearer "+token:
+				res.Header().Set(
+					"WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token"`, server.URL),
+				)
+				res.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			switch path {
+			case "/v2/busybox/manifests/latest",
+				"/v2/my-org/busybox/manifests/latest",
+				"/v2/public/busybox/manifests/latest":
+				res.Header().Set("Docker-Content-Digest", digest)
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+	return server
+}
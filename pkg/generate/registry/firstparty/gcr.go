@@ -0,0 +1,240 @@
+package firstparty
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+)
+
+// gcrUsername is the username that Google registries expect along with an
+// access token as the password.
+const gcrUsername = "oauth2accesstoken"
+
+// gcrTokenExpiryMargin is how long before an access token expires that a
+// new one is requested.
+const gcrTokenExpiryMargin = time.Minute
+
+// GCRWrapper is a registry wrapper for Google Container Registry and
+// Artifact Registry. Like ECRWrapper, it is not selected by prefix, but for
+// any line that starts with gcr.io, a regional host such as eu.gcr.io, or an
+// Artifact Registry host such as us-docker.pkg.dev.
+type GCRWrapper struct {
+	client          *registry.HTTPClient
+	credentials     *registry.CredentialResolver
+	credentialsFile string
+	googleCreds     *googleCredentials
+	token           *googleToken
+	clients         map[string]*registry.HTTPClient
+	mutex           sync.Mutex
+}
+
+// init registers GCRWrapper for use by docker-lock. Credentials are read
+// from GOOGLE_APPLICATION_CREDENTIALS if it is set, otherwise from gcloud's
+// application default credentials.
+func init() { // nolint: gochecknoinits
+	constructor := func(
+		client *registry.HTTPClient,
+		configPath string,
+	) (registry.Wrapper, error) {
+		credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if credentialsFile == "" {
+			credentialsFile = defaultGoogleCredentialsFile()
+		}
+
+		return NewGCRWrapper(client, configPath, credentialsFile)
+	}
+
+	constructors = append(constructors, constructor)
+}
+
+// NewGCRWrapper creates a GCRWrapper.
+//
+// Credentials for a registry are first read from docker's config.json, so
+// that registries configured to use docker-credential-gcr or gcloud in
+// credHelpers work as with the docker cli. Otherwise, an access token is
+// requested with the service account's JSON key or the user's application
+// default credentials at credentialsFile. If credentialsFile is empty or
+// does not exist, registries are queried anonymously, as is possible for
+// public images such as gcr.io/distroless/static. If the credentials cannot
+// be exchanged for an access token, such as when they have expired, public
+// images are still queried anonymously.
+func NewGCRWrapper(
+	client *registry.HTTPClient,
+	configPath string,
+	credentialsFile string,
+) (*GCRWrapper, error) {
+	return &GCRWrapper{
+		client:          client,
+		credentials:     registry.NewCredentialResolver(configPath),
+		credentialsFile: credentialsFile,
+		clients:         map[string]*registry.HTTPClient{},
+	}, nil
+}
+
+// Digest queries the container registry for the digest given a repo and ref.
+func (g *GCRWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := g.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref. The repo must start with a Google registry host.
+func (g *GCRWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
+	host, path, err := splitGCRRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := registry.NewV2(g.hostClient(host))
+	if err != nil {
+		return nil, err
+	}
+
+	authCreds, err := g.authCredentials(host)
+	if err != nil {
+		// expired or revoked credentials should not prevent querying
+		// public images, so the registry is queried anonymously
+		manifest, anonymousErr := r.ResolveManifestWithCredentials(
+			path, ref, &registry.AuthCredentials{},
+		)
+		if anonymousErr != nil {
+			return nil, fmt.Errorf(
+				"%s, and querying anonymously failed: %s", err, anonymousErr,
+			)
+		}
+
+		return manifest, nil
+	}
+
+	return r.ResolveManifestWithCredentials(path, ref, authCreds)
+}
+
+// Prefix returns an empty string since GCRWrapper is selected by Matches.
+func (g *GCRWrapper) Prefix() string {
+	return ""
+}
+
+// Matches returns true if the line starts with a Google registry host.
+func (g *GCRWrapper) Matches(line string) bool {
+	_, _, err := splitGCRRepo(line)
+	return err == nil
+}
+
+// splitGCRRepo splits a repo into its Google registry host and the
+// remaining path.
+func splitGCRRepo(repo string) (string, string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+
+	const numParts = 2
+
+	if len(parts) != numParts || !isGCRHost(parts[0]) {
+		return "", "", fmt.Errorf(
+			"'%s' does not start with a google registry host", repo,
+		)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// isGCRHost returns true for gcr.io, regional hosts such as eu.gcr.io, and
+// Artifact Registry hosts such as europe-west1-docker.pkg.dev.
+func isGCRHost(host string) bool {
+	return host == "gcr.io" ||
+		strings.HasSuffix(host, ".gcr.io") ||
+		strings.HasSuffix(host, "-docker.pkg.dev")
+}
+
+// hostClient returns the client for the registry host. Clients are reused
+// so that tokens are cached across queries to the same host.
+func (g *GCRWrapper) hostClient(host string) *registry.HTTPClient {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	client, ok := g.clients[host]
+	if !ok {
+		client = registry.NewHTTPClient(
+			g.client, fmt.Sprintf("https://%s/v2", host), "",
+		)
+		g.clients[host] = client
+	}
+
+	return client
+}
+
+// authCredentials returns the credentials for the registry host from
+// docker's config.json or, if there are none, an access token from the
+// credentials file. If neither has credentials, the credentials are empty.
+func (g *GCRWrapper) authCredentials(
+	host string,
+) (*registry.AuthCredentials, error) {
+	authCreds, err := g.credentials.Resolve(host, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if authCreds.Username != "" && authCreds.Password != "" {
+		return authCreds, nil
+	}
+
+	accessToken, err := g.accessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if accessToken == "" {
+		return &registry.AuthCredentials{}, nil
+	}
+
+	return &registry.AuthCredentials{
+		Username: gcrUsername,
+		Password: accessToken,
+	}, nil
+}
+
+// accessToken returns an access token from the credentials file, or an
+// empty string if there is no credentials file. Tokens are cached until
+// shortly before they expire, which is after an hour by default.
+func (g *GCRWrapper) accessToken() (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.token != nil &&
+		time.Now().Add(gcrTokenExpiryMargin).Before(g.token.expiresAt) {
+		return g.token.accessToken, nil
+	}
+
+	if g.googleCreds == nil {
+		googleCreds, err := readGoogleCredentials(g.credentialsFile)
+		if err != nil {
+			return "", err
+		}
+
+		if googleCreds == nil {
+			return "", nil
+		}
+
+		g.googleCreds = googleCreds
+	}
+
+	token, err := g.googleCreds.accessToken(
+		registry.NewHTTPClient(g.client, "", ""),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	g.token = token
+
+	return token.accessToken, nil
+}
@@ -0,0 +1,259 @@
+package firstparty
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+)
+
+// Constants for requesting access tokens from Google's OAuth2 server.
+const (
+	googleTokenURL   = "https://oauth2.googleapis.com/token"
+	googleTokenScope = "https://www.googleapis.com/auth/cloud-platform"
+	googleJWTGrant   = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	googleJWTExpiry  = time.Hour
+
+	// googleTokenExpiry is how long an access token is assumed to be valid
+	// if the token server does not return expires_in.
+	googleTokenExpiry = time.Hour
+)
+
+// googleCredentials represents a credentials file, which is either a
+// service account's JSON key, or the application default credentials that
+// 'gcloud auth application-default login' writes for a user.
+type googleCredentials struct {
+	Type string `json:"type"`
+
+	// service_account fields
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user fields
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleToken is an access token along with when it expires.
+type googleToken struct {
+	accessToken string
+	expiresAt   time.Time
+}
+
+// defaultGoogleCredentialsFile returns the path of the application default
+// credentials written by gcloud.
+func defaultGoogleCredentialsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(
+			os.Getenv("APPDATA"), "gcloud",
+			"application_default_credentials.json",
+		)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(
+		homeDir, ".config", "gcloud", "application_default_credentials.json",
+	)
+}
+
+// readGoogleCredentials reads a credentials file. If the file does not
+// exist, nil is returned without an error.
+func readGoogleCredentials(path string) (*googleCredentials, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	credsByt, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var creds googleCredentials
+	if err = json.Unmarshal(credsByt, &creds); err != nil {
+		return nil, fmt.Errorf(
+			"invalid google credentials '%s': %s", path, err,
+		)
+	}
+
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+
+	return &creds, nil
+}
+
+// accessToken exchanges the credentials for an access token. Service
+// accounts sign a JWT with their private key, while users send their
+// refresh token.
+func (g *googleCredentials) accessToken(
+	client *registry.HTTPClient,
+) (*googleToken, error) {
+	form := url.Values{}
+
+	switch g.Type {
+	case "service_account":
+		assertion, err := g.signedJWT(time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		form.Set("grant_type", googleJWTGrant)
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", g.ClientID)
+		form.Set("client_secret", g.ClientSecret)
+		form.Set("refresh_token", g.RefreshToken)
+	default:
+		return nil, fmt.Errorf(
+			"unsupported google credentials type '%s'", g.Type,
+		)
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost, g.TokenURI, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"failed to get a google access token from '%s', got status %d",
+			g.TokenURI, resp.StatusCode,
+		)
+	}
+
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+
+	if t.AccessToken == "" {
+		return nil, fmt.Errorf(
+			"no google access token returned from '%s'", g.TokenURI,
+		)
+	}
+
+	expiresIn := time.Duration(t.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = googleTokenExpiry
+	}
+
+	return &googleToken{
+		accessToken: t.AccessToken,
+		expiresAt:   time.Now().Add(expiresIn),
+	}, nil
+}
+
+// signedJWT returns a JWT signed with the service account's private key
+// that asserts the service account's identity to the token server.
+func (g *googleCredentials) signedJWT(now time.Time) (string, error) {
+	key, err := parseRSAPrivateKey(g.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": g.PrivateKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   g.ClientEmail,
+		"scope": googleTokenScope,
+		"aud":   g.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(googleJWTExpiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := fmt.Sprintf(
+		"%s.%s",
+		base64.RawURLEncoding.EncodeToString(header),
+		base64.RawURLEncoding.EncodeToString(claims),
+	)
+
+	hash := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(
+		rand.Reader, key, crypto.SHA256, hash[:],
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"%s.%s", unsigned, base64.RawURLEncoding.EncodeToString(signature),
+	), nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #8 or PKCS #1 RSA private
+// key, as found in a service account's JSON key.
+func parseRSAPrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("invalid private key in google credentials")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key in google credentials is not rsa")
+	}
+
+	return rsaKey, nil
+}
@@ -1,8 +1,9 @@
 package firstparty
 
 import (
+	"errors"
 	"fmt"
-	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -10,9 +11,62 @@ import (
 	"github.com/safe-waters/docker-lock/pkg/generate/registry"
 )
 
+// Auth methods for internal registries. With ChallengeAuthMethod, the
+// registry is asked how to authenticate, as in its WWW-Authenticate
+// challenge. With TokenAuthMethod, a bearer token is requested from the
+// token url. With NoneAuthMethod, the registry is queried anonymously.
+const (
+	ChallengeAuthMethod = "challenge"
+	TokenAuthMethod     = "token"
+	NoneAuthMethod      = "none"
+)
+
+// Credential sources for internal registries. With
+// DockerConfigCredentialSource, credentials are read from docker's
+// config.json. With an env source such as env:HARBOR, the username and
+// password are read from HARBOR_USERNAME and HARBOR_PASSWORD. With
+// NoCredentialSource, no credentials are sent.
+const (
+	DockerConfigCredentialSource = "docker-config"
+	EnvCredentialSourcePrefix    = "env:"
+	NoCredentialSource           = "none"
+)
+
+// RegistryConfig declares an internal registry, as in an entry of the
+// registries section of .docker-lock.yml.
+type RegistryConfig struct {
+	// Prefix selects the registry for images that start with it, as in
+	// my-org/.
+	Prefix string `mapstructure:"prefix"`
+
+	// URL is the location of the registry, as in https://harbor.my-org.com.
+	URL string `mapstructure:"url"`
+
+	// TokenURL is where bearer tokens are requested from if Auth is
+	// TokenAuthMethod. <REPO> is replaced with the repo.
+	TokenURL string `mapstructure:"token-url"`
+
+	// Auth is the auth method, which defaults to TokenAuthMethod if TokenURL
+	// is set, otherwise ChallengeAuthMethod.
+	Auth string `mapstructure:"auth"`
+
+	// Credentials is the credential source, which defaults to
+	// DockerConfigCredentialSource.
+	Credentials string `mapstructure:"credentials"`
+
+	// StripPrefix is true if the prefix is not part of the repo name in
+	// API calls.
+	StripPrefix bool `mapstructure:"strip-prefix"`
+}
+
 // InternalWrapper is a registry wrapper for internal registries.
 type InternalWrapper struct {
 	client      *registry.HTTPClient
+	credentials *registry.CredentialResolver
+	username    string
+	password    string
+	auth        string
+	host        string
 	prefix      string
 	stripPrefix bool
 }
@@ -22,7 +76,7 @@ type InternalWrapper struct {
 func init() { // nolint: gochecknoinits
 	constructor := func(
 		client *registry.HTTPClient,
-		_ string,
+		configPath string,
 	) (registry.Wrapper, error) {
 		stripPrefix, err := strconv.ParseBool(
 			os.Getenv("INTERNAL_STRIP_PREFIX"),
@@ -33,7 +87,7 @@ func init() { // nolint: gochecknoinits
 		}
 
 		w, err := NewInternalWrapper(
-			client, os.Getenv("INTERNAL_PREFIX"), stripPrefix,
+			client, configPath, os.Getenv("INTERNAL_PREFIX"), stripPrefix,
 			os.Getenv("INTERNAL_REGISTRY_URL"), os.Getenv("INTERNAL_TOKEN_URL"),
 		)
 		if err != nil {
@@ -47,11 +101,18 @@ func init() { // nolint: gochecknoinits
 }
 
 // NewInternalWrapper creates an InternalWrapper. If tokenURL is blank,
-// the wrapper will not acquire a token before requesting the digest.
+// the wrapper will authenticate as advertised by the registry's
+// WWW-Authenticate challenge, if it sends one.
 // If stripPrefix is true, the prefix will not be considered part of
-// the repo name in API calls. registryURL must be set.
+// the repo name in API calls. registryURL must be set. Credentials for the
+// registry's host are read from docker's config.json at configPath.
+//
+// Unlike NewInternalWrapperFromConfig, prefix may be empty, as when
+// INTERNAL_REGISTRY_URL is set without INTERNAL_PREFIX. Such a wrapper is
+// never selected, since no image matches an empty prefix.
 func NewInternalWrapper(
 	client *registry.HTTPClient,
+	configPath string,
 	prefix string,
 	stripPrefix bool,
 	registryURL string,
@@ -61,49 +122,191 @@ func NewInternalWrapper(
 		return nil, fmt.Errorf("internal registry url is empty")
 	}
 
+	return newInternalWrapper(client, configPath, &RegistryConfig{
+		Prefix:      prefix,
+		URL:         registryURL,
+		TokenURL:    tokenURL,
+		StripPrefix: stripPrefix,
+	})
+}
+
+// NewInternalWrapperFromConfig creates an InternalWrapper for a declared
+// registry, after validating the config. Unless the config declares another
+// credential source, credentials are read from docker's config.json at
+// configPath.
+func NewInternalWrapperFromConfig(
+	client *registry.HTTPClient,
+	configPath string,
+	config *RegistryConfig,
+) (*InternalWrapper, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newInternalWrapper(client, configPath, config)
+}
+
+// newInternalWrapper creates an InternalWrapper from a config that has
+// already been validated.
+func newInternalWrapper(
+	client *registry.HTTPClient,
+	configPath string,
+	config *RegistryConfig,
+) (*InternalWrapper, error) {
+	parsedURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
 	w := &InternalWrapper{
-		prefix:      prefix,
-		stripPrefix: stripPrefix,
+		client: registry.NewHTTPClient(
+			client, fmt.Sprintf("%s/v2", config.URL), config.TokenURL,
+		),
+		auth:        config.authMethod(),
+		host:        parsedURL.Host,
+		prefix:      config.Prefix,
+		stripPrefix: config.StripPrefix,
 	}
 
-	if client == nil {
-		w.client = &registry.HTTPClient{
-			Client:      &http.Client{},
-			RegistryURL: fmt.Sprintf("%s/v2", registryURL),
-			TokenURL:    tokenURL,
-		}
+	switch source := config.Credentials; {
+	case source == NoCredentialSource:
+	case strings.HasPrefix(source, EnvCredentialSourcePrefix):
+		name := strings.TrimPrefix(source, EnvCredentialSourcePrefix)
+
+		w.username = os.Getenv(fmt.Sprintf("%s_USERNAME", name))
+		w.password = os.Getenv(fmt.Sprintf("%s_PASSWORD", name))
+	default:
+		w.credentials = registry.NewCredentialResolver(configPath)
 	}
 
 	return w, nil
 }
 
+// Validate returns an error if the config does not declare a prefix and url,
+// or declares an unknown auth method or credential source.
+func (c *RegistryConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("registry url is empty")
+	}
+
+	if _, err := url.Parse(c.URL); err != nil {
+		return fmt.Errorf("invalid registry url '%s': %s", c.URL, err)
+	}
+
+	if c.Prefix == "" {
+		return fmt.Errorf("registry '%s' has an empty prefix", c.URL)
+	}
+
+	switch c.authMethod() {
+	case ChallengeAuthMethod, NoneAuthMethod:
+	case TokenAuthMethod:
+		if c.TokenURL == "" {
+			return fmt.Errorf(
+				"registry '%s' uses auth '%s' without a token-url",
+				c.URL, TokenAuthMethod,
+			)
+		}
+	default:
+		return fmt.Errorf(
+			"registry '%s' has unknown auth '%s', expected %s, %s, or %s",
+			c.URL, c.Auth, ChallengeAuthMethod, TokenAuthMethod,
+			NoneAuthMethod,
+		)
+	}
+
+	switch source := c.Credentials; {
+	case source == "", source == DockerConfigCredentialSource,
+		source == NoCredentialSource:
+	case strings.HasPrefix(source, EnvCredentialSourcePrefix) &&
+		source != EnvCredentialSourcePrefix:
+	default:
+		return fmt.Errorf(
+			"registry '%s' has unknown credentials '%s', expected %s, %s, "+
+				"or %s<NAME>",
+			c.URL, source, DockerConfigCredentialSource, NoCredentialSource,
+			EnvCredentialSourcePrefix,
+		)
+	}
+
+	return nil
+}
+
+// authMethod returns the declared auth method or its default.
+func (c *RegistryConfig) authMethod() string {
+	switch {
+	case c.Auth != "":
+		return c.Auth
+	case c.TokenURL != "":
+		return TokenAuthMethod
+	default:
+		return ChallengeAuthMethod
+	}
+}
+
 // Digest queries the container registry for the digest given a repo and ref.
 func (i *InternalWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := i.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref.
+func (i *InternalWrapper) Manifest(
+	repo string,
+	ref string,
+) (*registry.Manifest, error) {
 	if i.stripPrefix {
 		repo = strings.Replace(repo, i.Prefix(), "", 1)
 	}
 
 	r, err := registry.NewV2(i.client)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	token := ""
-
-	if i.client.TokenURL != "" {
-		tokenURL := strings.ReplaceAll(i.client.TokenURL, "<REPO>", repo)
+	if i.auth == NoneAuthMethod {
+		return r.Manifest(repo, ref, "")
+	}
 
-		var err error
+	authCreds, err := i.authCredentials()
+	if err != nil {
+		return nil, err
+	}
 
-		token, err = r.Token(
-			tokenURL, "", "", &registry.DefaultTokenExtractor{},
+	if i.auth == ChallengeAuthMethod {
+		return r.ResolveManifestWithCredentials(
+			repo, ref, authCreds,
 		)
-		if err != nil {
-			return "", err
-		}
 	}
 
-	return r.Digest(repo, ref, token)
+	tokenURL := strings.ReplaceAll(i.client.TokenURL, "<REPO>", repo)
+
+	token, err := r.Token(
+		tokenURL, authCreds.Username, authCreds.Password,
+		&registry.DefaultTokenExtractor{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Manifest(repo, ref, token)
+}
+
+// authCredentials returns the credentials from the wrapper's credential
+// source.
+func (i *InternalWrapper) authCredentials() (*registry.AuthCredentials, error) {
+	if i.credentials == nil {
+		return &registry.AuthCredentials{
+			Username: i.username,
+			Password: i.password,
+		}, nil
+	}
+
+	return i.credentials.Resolve(i.host, i.username, i.password)
 }
 
 // Prefix returns the registry prefix that identifies the internal
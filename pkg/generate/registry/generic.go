@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// GenericWrapper is a registry wrapper for any registry that implements the
+// HTTP API V2 and Token Authentication specifications, such as GHCR, Quay,
+// Harbor, or GitLab. The registry is selected from the host at the start of
+// the repo, as in ghcr.io/org/image.
+type GenericWrapper struct {
+	client      *HTTPClient
+	credentials *CredentialResolver
+	clients     map[string]*HTTPClient
+	mutex       sync.Mutex
+}
+
+// NewGenericWrapper creates a GenericWrapper. Unless client overrides the
+// registry url, it is derived from the host of each repo. Credentials are
+// read from docker's config.json at configPath, the first time a host
+// is queried.
+func NewGenericWrapper(client *HTTPClient, configPath string) *GenericWrapper {
+	return &GenericWrapper{
+		client:      client,
+		credentials: NewCredentialResolver(configPath),
+		clients:     map[string]*HTTPClient{},
+	}
+}
+
+// Digest queries the container registry for the digest given a repo and ref.
+// The repo must start with a registry host, as in localhost:5000/busybox.
+func (g *GenericWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := g.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo
+// and ref. The repo must start with a registry host, as in
+// localhost:5000/busybox.
+func (g *GenericWrapper) Manifest(
+	repo string,
+	ref string,
+) (*Manifest, error) {
+	if !reference.HasDomain(repo) {
+		return nil, fmt.Errorf(
+			"'%s' does not start with a registry host", repo,
+		)
+	}
+
+	host, path := reference.SplitDomain(repo)
+
+	client := g.hostClient(host)
+
+	authCreds, err := g.credentials.Resolve(host, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewV2(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ResolveManifestWithCredentials(
+		path, ref, authCreds,
+	)
+}
+
+// hostClient returns the client for the registry host. Clients are reused
+// so that bearer tokens are cached across queries to the same host.
+func (g *GenericWrapper) hostClient(host string) *HTTPClient {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.clients == nil {
+		g.clients = map[string]*HTTPClient{}
+	}
+
+	client, ok := g.clients[host]
+	if !ok {
+		client = NewHTTPClient(g.client, fmt.Sprintf("https://%s/v2", host), "")
+		g.clients[host] = client
+	}
+
+	return client
+}
+
+// Prefix returns an empty string since GenericWrapper is not selected by
+// prefix, but by WrapperManager when no other wrapper matches.
+func (g *GenericWrapper) Prefix() string {
+	return ""
+}
+
+// Host returns the registry host at the start of a repo, such as ghcr.io
+// for ghcr.io/org/image, or docker.io for repos on Docker Hub, however
+// Docker Hub is spelled.
+func Host(repo string) string {
+	return reference.Domain(repo)
+}
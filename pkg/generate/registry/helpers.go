@@ -2,13 +2,51 @@ package registry
 
 import (
 	"net/http"
+	"sync"
 )
 
-// HTTPClient overrides base urls to get digests and auth tokens.
+// HTTPClient overrides base urls to get digests and auth tokens. If Retry
+// is not nil, requests that fail with transient errors are retried. Bearer
+// tokens are cached by the client until they expire.
 type HTTPClient struct {
 	*http.Client
 	RegistryURL string
 	TokenURL    string
+	Retry       *RetryPolicy
+
+	rateLimitWarning sync.Once
+	tokens           map[string]*bearerToken
+	refreshTokens    map[string]string
+	tokenMutex       sync.Mutex
+}
+
+// NewHTTPClient returns an HTTPClient for the registry at registryURL. If
+// base is not nil, its http.Client and RetryPolicy are used. If base already
+// overrides the registry url, as in tests, base is returned unchanged.
+func NewHTTPClient(
+	base *HTTPClient,
+	registryURL string,
+	tokenURL string,
+) *HTTPClient {
+	if base != nil && base.RegistryURL != "" {
+		return base
+	}
+
+	client := &HTTPClient{
+		Client:      &http.Client{},
+		RegistryURL: registryURL,
+		TokenURL:    tokenURL,
+	}
+
+	if base != nil {
+		if base.Client != nil {
+			client.Client = base.Client
+		}
+
+		client.Retry = base.Retry
+	}
+
+	return client
 }
 
 // WrapperConstructor is a type for a function that can create a wrapper.
@@ -1,11 +1,17 @@
 package registry
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
 
 // WrapperManager selects which registry wrapper to use at runtime.
 type WrapperManager struct {
 	defaultWrapper Wrapper
+	hostWrapper    Wrapper
 	wrappers       []Wrapper
+	mirrors        map[string][]*Mirror
 }
 
 // NewWrapperManager creates a WrapperManager with a default wrapper
@@ -20,17 +26,85 @@ func (m *WrapperManager) Add(wrappers ...Wrapper) {
 	m.wrappers = append(m.wrappers, wrappers...)
 }
 
-// Wrapper selects a registry wrapper if the line starts with
-// the wrapper's prefix. If no match is found, the default wrapper
-// is used. In this context, a line means the prefix+repo+tag, as in
-// dockerlocktestaccount.azurecr.io/helloworld:latest.
+// SetHostWrapper sets a wrapper, such as GenericWrapper, that is selected
+// instead of the default wrapper if no prefix matches, but the line starts
+// with a registry host, as in ghcr.io/org/image:latest.
+func (m *WrapperManager) SetHostWrapper(hostWrapper Wrapper) {
+	m.hostWrapper = hostWrapper
+}
+
+// AddMirrors adds mirrors that are queried instead of their upstream
+// registries. Mirrors of the same upstream are queried in the order that
+// they were added.
+func (m *WrapperManager) AddMirrors(mirrors ...*Mirror) {
+	if m.mirrors == nil {
+		m.mirrors = map[string][]*Mirror{}
+	}
+
+	for _, mirror := range mirrors {
+		m.mirrors[mirror.Upstream()] = append(
+			m.mirrors[mirror.Upstream()], mirror,
+		)
+	}
+}
+
+// Wrapper selects a registry wrapper for the line as in upstreamWrapper. If
+// the line's registry host has mirrors, the wrapper is wrapped in a
+// MirroredWrapper that queries the mirrors first. Lines without a host,
+// such as busybox, are only on Docker Hub if the default wrapper is
+// selected, rather than a wrapper for a prefix such as my-org/.
 func (m *WrapperManager) Wrapper(line string) Wrapper {
+	wrapper := m.upstreamWrapper(line)
+
+	if len(m.mirrors) == 0 {
+		return wrapper
+	}
+
+	if !reference.HasDomain(line) && wrapper != m.defaultWrapper {
+		return wrapper
+	}
+
+	if mirrors, ok := m.mirrors[reference.Domain(line)]; ok {
+		return NewMirroredWrapper(mirrors, wrapper)
+	}
+
+	return wrapper
+}
+
+// upstreamWrapper selects the registry wrapper with the longest prefix that
+// the line starts with, so that my-org/team/ is preferred to my-org/. If no
+// prefix matches, the first MatchingWrapper that matches the line is
+// selected. Otherwise, the host wrapper is used for lines that start with a
+// registry host, and the default wrapper for all other lines. In this
+// context, a line means the prefix+repo+tag, as in
+// dockerlocktestaccount.azurecr.io/helloworld:latest.
+func (m *WrapperManager) upstreamWrapper(line string) Wrapper {
+	var longest Wrapper
+
 	for _, wrapper := range m.wrappers {
 		p := wrapper.Prefix()
-		if p != "" && strings.HasPrefix(line, p) {
+		if p != "" && strings.HasPrefix(line, p) &&
+			(longest == nil || len(p) > len(longest.Prefix())) {
+			longest = wrapper
+		}
+	}
+
+	if longest != nil {
+		return longest
+	}
+
+	for _, wrapper := range m.wrappers {
+		if w, ok := wrapper.(MatchingWrapper); ok && w.Matches(line) {
 			return wrapper
 		}
 	}
 
+	// Docker Hub, however it is spelled, as in index.docker.io/busybox, is
+	// handled by the default wrapper
+	if m.hostWrapper != nil && reference.HasDomain(line) &&
+		reference.Domain(line) != reference.DefaultDomain {
+		return m.hostWrapper
+	}
+
 	return m.defaultWrapper
 }
@@ -0,0 +1,242 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// Manifest contains a manifest's body along with the digest and media type
+// with which the registry returned it. Digest is taken from the
+// Docker-Content-Digest header, including its algorithm, as in
+// sha256:bae015c28bc7..., and is empty if the registry did not send it.
+//
+// If the manifest was found with a HEAD request, Body is empty until it is
+// requested with Content.
+type Manifest struct {
+	Digest    string
+	MediaType string
+	Body      []byte
+	getBody   func() ([]byte, error)
+}
+
+// Platform identifies the os, architecture and optional variant that an
+// image was built for, as in linux/arm64/v8.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// manifestList represents the fields of a manifest list that are required
+// to select platform specific manifests.
+type manifestList struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string   `json:"digest"`
+		Platform Platform `json:"platform"`
+	} `json:"manifests"`
+}
+
+// Media types of the manifests that registries may return. Manifest lists
+// and OCI image indexes reference platform specific manifests.
+const (
+	manifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"      // nolint: lll
+	manifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json" // nolint: lll
+	ociManifestMediaType  = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType     = "application/vnd.oci.image.index.v1+json"
+)
+
+// ParsePlatform parses platforms such as linux/amd64 or linux/arm64/v8.
+func ParsePlatform(platform string) (*Platform, error) {
+	fields := strings.Split(platform, "/")
+
+	const minNumFields, maxNumFields = 2, 3
+
+	if len(fields) < minNumFields || len(fields) > maxNumFields {
+		return nil, fmt.Errorf(
+			"invalid platform '%s', expected os/arch[/variant]", platform,
+		)
+	}
+
+	for _, field := range fields {
+		if field == "" {
+			return nil, fmt.Errorf(
+				"invalid platform '%s', expected os/arch[/variant]", platform,
+			)
+		}
+	}
+
+	p := &Platform{OS: fields[0], Architecture: fields[1]}
+	if len(fields) == maxNumFields {
+		p.Variant = fields[2]
+	}
+
+	return p, nil
+}
+
+// String returns the platform in the form os/arch[/variant].
+func (p *Platform) String() string {
+	if p.Variant == "" {
+		return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+}
+
+// Matches returns true if other is the same platform. If p does not
+// specify a variant, any variant of other matches.
+func (p *Platform) Matches(other *Platform) bool {
+	return p.OS == other.OS &&
+		p.Architecture == other.Architecture &&
+		(p.Variant == "" || p.Variant == other.Variant)
+}
+
+// HeaderDigest returns the digest that the registry sent in the
+// Docker-Content-Digest header, or an error if it did not send one.
+// The repo and ref are only used in the error message.
+func (m *Manifest) HeaderDigest(repo string, ref string) (string, error) {
+	if m.Digest == "" {
+		return "", fmt.Errorf("no digest found for '%s:%s'", repo, ref)
+	}
+
+	return m.Digest, nil
+}
+
+// Content returns the manifest's body, requesting it from the registry if
+// it has not been requested yet.
+func (m *Manifest) Content() ([]byte, error) {
+	if m.Body == nil && m.getBody != nil {
+		body, err := m.getBody()
+		if err != nil {
+			return nil, err
+		}
+
+		m.Body = body
+	}
+
+	return m.Body, nil
+}
+
+// ComputeDigest returns the digest of the manifest's body, using the
+// algorithm of the digest that the registry sent, or sha256 if it did not
+// send one.
+func (m *Manifest) ComputeDigest() (string, error) {
+	body, err := m.Content()
+	if err != nil {
+		return "", err
+	}
+
+	algorithm, _ := reference.SplitDigest(m.Digest)
+	if algorithm == "" {
+		algorithm = reference.SHA256
+	}
+
+	return reference.ComputeDigest(algorithm, body)
+}
+
+// VerifyDigest computes the digest of the manifest's body and, if the
+// registry sent a digest, ensures that they match. Since the computed digest
+// is what the content actually hashes to, it is returned instead of the
+// digest that the registry claimed.
+func (m *Manifest) VerifyDigest() (string, error) {
+	digest, err := m.ComputeDigest()
+	if err != nil {
+		return "", err
+	}
+
+	if len(m.Body) == 0 {
+		return "", errors.New("cannot verify the digest of an empty manifest")
+	}
+
+	if m.Digest != "" && m.Digest != digest {
+		return "", fmt.Errorf(
+			"registry sent digest '%s', but the manifest hashes to '%s'",
+			m.Digest, digest,
+		)
+	}
+
+	return digest, nil
+}
+
+// IsList returns true if the manifest is a manifest list or an OCI image
+// index that references platform specific manifests.
+func (m *Manifest) IsList() bool {
+	return m.MediaType == manifestListMediaType ||
+		m.MediaType == ociIndexMediaType
+}
+
+// PlatformDigests selects the platform specific manifests from a manifest
+// list and returns their digests, keyed by the requested platforms. If the
+// manifest is not a list, it is already platform specific, so nil is
+// returned. If a requested platform is missing from the list, an error
+// is returned.
+func (m *Manifest) PlatformDigests(
+	platforms []string,
+) (map[string]string, error) {
+	if len(platforms) == 0 || !m.IsList() {
+		return nil, nil
+	}
+
+	body, err := m.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	var list manifestList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	platformDigests := map[string]string{}
+
+	for _, platform := range platforms {
+		requestedPlatform, err := ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, manifest := range list.Manifests {
+			manifest := manifest
+
+			if requestedPlatform.Matches(&manifest.Platform) {
+				platformDigests[platform] = manifest.Digest
+
+				break
+			}
+		}
+
+		if _, ok := platformDigests[platform]; !ok {
+			return nil, fmt.Errorf(
+				"no manifest for platform '%s' in manifest list '%s'",
+				platform, m.Digest,
+			)
+		}
+	}
+
+	return platformDigests, nil
+}
+
+// manifestMediaTypeOf returns the media type of a manifest from the
+// Content-Type header, without parameters such as charset. If the header
+// is missing, the media type is read from the body, since a manifest may
+// declare its own media type.
+func manifestMediaTypeOf(contentType string, body []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+	}
+
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return ""
+	}
+
+	return manifest.MediaType
+}
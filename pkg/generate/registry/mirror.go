@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// MirrorConfig declares a mirror of an upstream registry, such as a
+// pull-through cache of Docker Hub, as in an entry of the mirrors section
+// of .docker-lock.yml.
+type MirrorConfig struct {
+	// Upstream is the host of the mirrored registry, as in docker.io.
+	Upstream string `mapstructure:"upstream"`
+
+	// URL is the location of the mirror, as in https://mirror.my-org.com.
+	URL string `mapstructure:"url"`
+
+	// Fallback is true if the upstream registry should be queried when the
+	// mirror fails.
+	Fallback bool `mapstructure:"fallback"`
+}
+
+// Mirror queries a mirror instead of its upstream registry. As with
+// dockerd's registry-mirrors, the mirror serves the upstream's repos under
+// the same names, so images keep their upstream names.
+type Mirror struct {
+	upstream    string
+	host        string
+	fallback    bool
+	client      *HTTPClient
+	credentials *CredentialResolver
+}
+
+// MirroredWrapper queries the mirrors of the upstream registry in order,
+// falling back to the upstream's wrapper if every mirror fails and a mirror
+// allows it.
+type MirroredWrapper struct {
+	mirrors  []*Mirror
+	upstream Wrapper
+}
+
+// NewMirror creates a Mirror after validating the config. Credentials for
+// the mirror's host are read from docker's config.json at configPath. If
+// client overrides the registry url, as in tests, the mirror uses it.
+func NewMirror(
+	client *HTTPClient,
+	configPath string,
+	config *MirrorConfig,
+) (*Mirror, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	mirrorURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mirror{
+		upstream: reference.NormalizeDomain(config.Upstream),
+		host:     mirrorURL.Host,
+		fallback: config.Fallback,
+		client: NewHTTPClient(
+			client, fmt.Sprintf("%s/v2", strings.TrimSuffix(config.URL, "/")),
+			"",
+		),
+		credentials: NewCredentialResolver(configPath),
+	}, nil
+}
+
+// Validate returns an error if the config does not declare an upstream host
+// and an http or https url.
+func (c *MirrorConfig) Validate() error {
+	if c.Upstream == "" {
+		return errors.New("mirror upstream is empty")
+	}
+
+	if strings.Contains(c.Upstream, "/") {
+		return fmt.Errorf(
+			"mirror upstream '%s' must be a host, as in docker.io", c.Upstream,
+		)
+	}
+
+	mirrorURL, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid mirror url '%s': %s", c.URL, err)
+	}
+
+	if mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https" {
+		return fmt.Errorf(
+			"mirror url '%s' for '%s' must start with http:// or https://",
+			c.URL, c.Upstream,
+		)
+	}
+
+	return nil
+}
+
+// Upstream returns the host of the mirrored registry.
+func (m *Mirror) Upstream() string {
+	return m.upstream
+}
+
+// Manifest queries the mirror for the manifest given a repo, as it would
+// be referenced on the upstream registry, and ref.
+func (m *Mirror) Manifest(repo string, ref string) (*Manifest, error) {
+	authCreds, err := m.credentials.Resolve(m.host, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewV2(m.client)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ResolveManifestWithCredentials(
+		mirrorPath(repo), ref, authCreds,
+	)
+}
+
+// mirrorPath returns the path of the repo on a mirror of the upstream.
+// Mirrors of Docker Hub serve official images under library/, as does
+// Docker Hub.
+func mirrorPath(repo string) string {
+	return reference.Path(repo)
+}
+
+// NewMirroredWrapper creates a MirroredWrapper that queries mirrors before
+// the upstream wrapper.
+func NewMirroredWrapper(mirrors []*Mirror, upstream Wrapper) *MirroredWrapper {
+	return &MirroredWrapper{mirrors: mirrors, upstream: upstream}
+}
+
+// Digest queries the mirrors for the digest given a repo and ref.
+func (w *MirroredWrapper) Digest(repo string, ref string) (string, error) {
+	manifest, err := w.Manifest(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries each mirror for the manifest given a repo and ref. If
+// every mirror fails and any of them allows falling back, the upstream
+// wrapper is queried. Otherwise, the errors from the mirrors are returned.
+func (w *MirroredWrapper) Manifest(
+	repo string,
+	ref string,
+) (*Manifest, error) {
+	var (
+		errs     []string
+		fallback bool
+	)
+
+	for _, mirror := range w.mirrors {
+		manifest, err := mirror.Manifest(repo, ref)
+		if err == nil {
+			return manifest, nil
+		}
+
+		errs = append(
+			errs, fmt.Sprintf("mirror '%s': %s", mirror.host, err),
+		)
+		fallback = fallback || mirror.fallback
+	}
+
+	err := fmt.Errorf(
+		"no mirror of '%s' has '%s:%s': %s",
+		w.mirrors[0].upstream, repo, ref, strings.Join(errs, ", "),
+	)
+
+	if !fallback {
+		return nil, err
+	}
+
+	log.Printf("%s, falling back to the upstream registry", err)
+
+	if manifestWrapper, ok := w.upstream.(ManifestWrapper); ok {
+		return manifestWrapper.Manifest(repo, ref)
+	}
+
+	digest, err := w.upstream.Digest(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Digest: digest}, nil
+}
+
+// Prefix returns the prefix of the upstream wrapper.
+func (w *MirroredWrapper) Prefix() string {
+	return w.upstream.Prefix()
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// PluginPrefix is prepended to a plugin's name to find its executable on
+// PATH, as in docker-lock-registry-artifacts.
+const PluginPrefix = "docker-lock-registry-"
+
+// PluginConfig declares an exec plugin that looks up digests for images
+// with a prefix, as in an entry of the plugins section of .docker-lock.yml.
+type PluginConfig struct {
+	// Prefix selects the plugin for images that start with it, as in
+	// artifacts.my-org.com/.
+	Prefix string `mapstructure:"prefix"`
+
+	// Name selects the executable PluginPrefix<Name> on PATH if Command is
+	// not set.
+	Name string `mapstructure:"name"`
+
+	// Command is the path to the plugin's executable, and Args are passed
+	// to it.
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+}
+
+// PluginRequest is written as JSON to a plugin's stdin.
+type PluginRequest struct {
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"`
+}
+
+// PluginResponse is read as JSON from a plugin's stdout. A plugin that
+// cannot find the digest sets Error, or exits with a non-zero status.
+type PluginResponse struct {
+	Digest string `json:"digest"`
+	Error  string `json:"error"`
+}
+
+// PluginWrapper is a registry wrapper that runs an exec plugin for every
+// digest, so that registries with non-standard APIs can be supported
+// without compiling a Wrapper into docker-lock.
+type PluginWrapper struct {
+	prefix  string
+	command string
+	args    []string
+}
+
+// NewPluginWrapper creates a PluginWrapper after validating the config and
+// finding the plugin's executable.
+func NewPluginWrapper(config *PluginConfig) (*PluginWrapper, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	command := config.Command
+	if command == "" {
+		command = PluginPrefix + config.Name
+	}
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"cannot find plugin for '%s': %s", config.Prefix, err,
+		)
+	}
+
+	return &PluginWrapper{
+		prefix:  config.Prefix,
+		command: path,
+		args:    config.Args,
+	}, nil
+}
+
+// Validate returns an error if the config does not declare a prefix, or
+// declares neither or both of a name and command.
+func (c *PluginConfig) Validate() error {
+	if c.Prefix == "" {
+		return errors.New("plugin prefix is empty")
+	}
+
+	if (c.Name == "") == (c.Command == "") {
+		return fmt.Errorf(
+			"plugin for '%s' must declare one of name or command", c.Prefix,
+		)
+	}
+
+	return nil
+}
+
+// Digest runs the plugin for the digest given a repo and ref.
+func (p *PluginWrapper) Digest(repo string, ref string) (string, error) {
+	request, err := json.Marshal(&PluginRequest{Repo: repo, Ref: ref})
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(p.command, p.args...) // nolint: gosec
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf(
+			"plugin '%s' failed for '%s:%s': %s: %s",
+			p.command, repo, ref, err, strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	var response PluginResponse
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return "", fmt.Errorf(
+			"plugin '%s' returned invalid json for '%s:%s': %s",
+			p.command, repo, ref, err,
+		)
+	}
+
+	if response.Error != "" {
+		return "", fmt.Errorf(
+			"plugin '%s' failed for '%s:%s': %s",
+			p.command, repo, ref, response.Error,
+		)
+	}
+
+	if response.Digest == "" {
+		return "", fmt.Errorf(
+			"plugin '%s' returned no digest for '%s:%s'", p.command, repo, ref,
+		)
+	}
+
+	if err = reference.ValidateDigest(response.Digest); err != nil {
+		return "", fmt.Errorf(
+			"plugin '%s' returned an invalid digest for '%s:%s': %s",
+			p.command, repo, ref, err,
+		)
+	}
+
+	return response.Digest, nil
+}
+
+// Prefix returns the prefix of images that the plugin looks up.
+func (p *PluginWrapper) Prefix() string {
+	return p.prefix
+}
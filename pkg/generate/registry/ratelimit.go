@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rateLimitWarningRatio is the fraction of a registry's rate limit below
+// which a warning is logged, as in 10 of 100 requests remaining.
+const rateLimitWarningRatio = 0.1
+
+// RateLimit contains the values of the RateLimit-Limit and
+// RateLimit-Remaining headers that registries such as Docker Hub return, as
+// described in https://docs.docker.com/docker-hub/download-rate-limit/
+type RateLimit struct {
+	Limit     int
+	Remaining int
+}
+
+// ParseRateLimit parses the rate limit headers of a response. The headers
+// may contain a window, as in 100;w=21600, which is ignored. If the registry
+// does not send both headers, nil is returned.
+func ParseRateLimit(header http.Header) *RateLimit {
+	limit, err := parseRateLimitHeader(header.Get("RateLimit-Limit"))
+	if err != nil {
+		return nil
+	}
+
+	remaining, err := parseRateLimitHeader(header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return nil
+	}
+
+	return &RateLimit{Limit: limit, Remaining: remaining}
+}
+
+// IsLow returns true if few requests remain before the limit is reached.
+func (r *RateLimit) IsLow() bool {
+	return float64(r.Remaining) <= float64(r.Limit)*rateLimitWarningRatio
+}
+
+// checkRateLimit returns an error if the registry refused the request
+// because the rate limit was exceeded, and logs a warning the first time
+// the client notices that the limit will soon be exceeded.
+func (h *HTTPClient) checkRateLimit(resp *http.Response) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitError(resp)
+	}
+
+	rateLimit := ParseRateLimit(resp.Header)
+
+	if rateLimit != nil && rateLimit.IsLow() {
+		h.rateLimitWarning.Do(func() {
+			log.Printf(
+				"only %d of %d requests remaining before '%s' rate limits "+
+					"requests, login with 'docker login' for a higher limit",
+				rateLimit.Remaining, rateLimit.Limit, resp.Request.URL.Host,
+			)
+		})
+	}
+
+	return nil
+}
+
+// rateLimitError explains that the registry refused the request because
+// the rate limit was exceeded.
+func rateLimitError(resp *http.Response) error {
+	msg := fmt.Sprintf("rate limit exceeded for '%s'", resp.Request.URL.Host)
+
+	if rateLimit := ParseRateLimit(resp.Header); rateLimit != nil {
+		msg = fmt.Sprintf(
+			"%s, %d of %d requests remaining",
+			msg, rateLimit.Remaining, rateLimit.Limit,
+		)
+	}
+
+	return fmt.Errorf(
+		"%s, login with 'docker login' for a higher limit or try later", msg,
+	)
+}
+
+func parseRateLimitHeader(value string) (int, error) {
+	value = strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,1723 @@
+package registry_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry/firstparty"
+)
+
+const (
+	busyboxLatestSHA  = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+	ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+)
+
+func TestParseChallenge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		Header     string
+		Expected   *registry.Challenge
+		ShouldFail bool
+	}{
+		{
+			Name: "Bearer",
+			Header: `Bearer realm="https://auth.docker.io/token",` +
+				`service="registry.docker.io"`,
+			Expected: &registry.Challenge{
+				Scheme:  "bearer",
+				Realm:   "https://auth.docker.io/token",
+				Service: "registry.docker.io",
+			},
+		},
+		{
+			Name: "Scope With Commas",
+			Header: `Bearer realm="https://ghcr.io/token", ` +
+				`service="ghcr.io", scope="repository:org/image:pull,push"`,
+			Expected: &registry.Challenge{
+				Scheme:  "bearer",
+				Realm:   "https://ghcr.io/token",
+				Service: "ghcr.io",
+				Scope:   "repository:org/image:pull,push",
+			},
+		},
+		{
+			Name:   "Basic",
+			Header: `Basic realm="Registry Realm"`,
+			Expected: &registry.Challenge{
+				Scheme: "basic",
+				Realm:  "Registry Realm",
+			},
+		},
+		{
+			Name:       "Bearer Without Realm",
+			Header:     `Bearer service="registry.docker.io"`,
+			ShouldFail: true,
+		},
+		{
+			Name:       "Unterminated Quote",
+			Header:     `Bearer realm="https://auth.docker.io/token`,
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := registry.ParseChallenge(test.Header)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(test.Expected, got) {
+				t.Fatalf("expected %+v, got %+v", test.Expected, got)
+			}
+		})
+	}
+}
+
+func TestGenericWrapper(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		Repo       string
+		TokenKey   string
+		Challenge  bool
+		ShouldFail bool
+	}{
+		{
+			Name:      "Token Key",
+			Repo:      "ghcr.io/org/busybox",
+			TokenKey:  "token",
+			Challenge: true,
+		},
+		{
+			Name:      "Access Token Key",
+			Repo:      "ghcr.io/org/busybox",
+			TokenKey:  "access_token",
+			Challenge: true,
+		},
+		{
+			Name: "Anonymous",
+			Repo: "localhost:5000/org/busybox",
+		},
+		{
+			Name:       "No Host",
+			Repo:       "org/busybox",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockChallengeServer(t, test.TokenKey, test.Challenge)
+			defer server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			wrapper := registry.NewGenericWrapper(client, "")
+
+			manifest, err := wrapper.Manifest(test.Repo, "latest")
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if manifest.Digest != busyboxLatestSHA {
+				t.Fatalf(
+					"expected %s, got %s", busyboxLatestSHA, manifest.Digest,
+				)
+			}
+
+			if manifest.MediaType != ociIndexMediaType {
+				t.Fatalf(
+					"expected %s, got %s",
+					ociIndexMediaType, manifest.MediaType,
+				)
+			}
+		})
+	}
+}
+
+func TestWrapperManager(t *testing.T) {
+	t.Parallel()
+
+	defaultWrapper := &registry.GenericWrapper{}
+	hostWrapper := registry.NewGenericWrapper(nil, "")
+
+	orgWrapper, err := firstparty.NewInternalWrapperFromConfig(
+		nil, "", &firstparty.RegistryConfig{
+			Prefix: "my-org/", URL: "https://org.example.com",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamWrapper, err := firstparty.NewInternalWrapperFromConfig(
+		nil, "", &firstparty.RegistryConfig{
+			Prefix: "my-org/team/", URL: "https://team.example.com",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name     string
+		Line     string
+		Expected registry.Wrapper
+	}{
+		{
+			Name:     "Docker Hub",
+			Line:     "busybox",
+			Expected: defaultWrapper,
+		},
+		{
+			Name:     "Docker Hub Namespace",
+			Line:     "org/busybox",
+			Expected: defaultWrapper,
+		},
+		{
+			Name:     "Docker Hub Host",
+			Line:     "docker.io/library/busybox",
+			Expected: defaultWrapper,
+		},
+		{
+			Name:     "Legacy Docker Hub Host",
+			Line:     "index.docker.io/busybox",
+			Expected: defaultWrapper,
+		},
+		{
+			Name:     "Docker Hub Registry Host",
+			Line:     "registry-1.docker.io/org/busybox",
+			Expected: defaultWrapper,
+		},
+		{
+			Name:     "Registry Host",
+			Line:     "ghcr.io/org/busybox",
+			Expected: hostWrapper,
+		},
+		{
+			Name:     "Registry Host With Port",
+			Line:     "localhost:5000/busybox",
+			Expected: hostWrapper,
+		},
+		{
+			Name:     "Prefix",
+			Line:     "my-org/busybox",
+			Expected: orgWrapper,
+		},
+		{
+			Name:     "Longest Prefix",
+			Line:     "my-org/team/busybox",
+			Expected: teamWrapper,
+		},
+	}
+
+	wrapperManager := registry.NewWrapperManager(defaultWrapper)
+	wrapperManager.SetHostWrapper(hostWrapper)
+	wrapperManager.Add(orgWrapper, teamWrapper)
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := wrapperManager.Wrapper(test.Line); got != test.Expected {
+				t.Fatalf("unexpected wrapper for '%s'", test.Line)
+			}
+		})
+	}
+}
+
+func mockChallengeServer(
+	t *testing.T,
+	tokenKey string,
+	challenge bool,
+) *httptest.Server {
+	t.Helper()
+
+	const token = "TOKEN"
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			authorized := !challenge ||
+				req.Header.Get("Authorization") == "Bearer "+token
+
+			switch path := req.URL.Path; {
+			case path == "/token":
+				expectedScope := "repository:org/busybox:pull"
+				if req.URL.Query().Get("scope") != expectedScope ||
+					req.URL.Query().Get("service") != "mock" {
+					res.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				fmt.Fprintf(res, `{"%s": "%s"}`, tokenKey, token)
+			case !authorized:
+				res.Header().Set(
+					"WWW-Authenticate", fmt.Sprintf(
+						`Bearer realm="%s/token",service="mock"`, server.URL,
+					),
+				)
+				res.WriteHeader(http.StatusUnauthorized)
+			case path == "/v2/":
+			case strings.HasSuffix(path, "/org/busybox/manifests/latest"):
+				if !strings.Contains(
+					strings.Join(req.Header["Accept"], ","), ociIndexMediaType,
+				) {
+					res.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				res.Header().Set(
+					"Docker-Content-Digest", busyboxLatestSHA,
+				)
+				res.Header().Set(
+					"Content-Type", ociIndexMediaType+"; charset=utf-8",
+				)
+			default:
+				res.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+	return server
+}
+
+func TestManifestPlatformDigests(t *testing.T) {
+	t.Parallel()
+
+	const listMediaType = "application/vnd.docker.distribution.manifest.list.v2+json" // nolint: lll
+
+	list := []byte(`
+{
+	"manifests": [
+		{
+			"digest": "sha256:amd64",
+			"platform": {"os": "linux", "architecture": "amd64"}
+		},
+		{
+			"digest": "sha256:armv7",
+			"platform": {"os": "linux", "architecture": "arm", "variant": "v7"}
+		}
+	]
+}
+`)
+
+	tests := []struct {
+		Name       string
+		Manifest   *registry.Manifest
+		Platforms  []string
+		Expected   map[string]string
+		ShouldFail bool
+	}{
+		{
+			Name: "Manifest List",
+			Manifest: &registry.Manifest{
+				MediaType: listMediaType,
+				Body:      list,
+			},
+			Platforms: []string{"linux/amd64", "linux/arm/v7"},
+			Expected: map[string]string{
+				"linux/amd64":  "sha256:amd64",
+				"linux/arm/v7": "sha256:armv7",
+			},
+		},
+		{
+			Name: "Any Variant",
+			Manifest: &registry.Manifest{
+				MediaType: listMediaType,
+				Body:      list,
+			},
+			Platforms: []string{"linux/arm"},
+			Expected:  map[string]string{"linux/arm": "sha256:armv7"},
+		},
+		{
+			Name: "OCI Image Index",
+			Manifest: &registry.Manifest{
+				MediaType: ociIndexMediaType,
+				Body:      list,
+			},
+			Platforms: []string{"linux/amd64"},
+			Expected:  map[string]string{"linux/amd64": "sha256:amd64"},
+		},
+		{
+			Name: "Single Manifest",
+			Manifest: &registry.Manifest{
+				MediaType: "application/vnd.docker.distribution.manifest.v2+json", // nolint: lll
+			},
+			Platforms: []string{"linux/amd64"},
+		},
+		{
+			Name: "Missing Platform",
+			Manifest: &registry.Manifest{
+				MediaType: listMediaType,
+				Body:      list,
+			},
+			Platforms:  []string{"linux/arm64"},
+			ShouldFail: true,
+		},
+		{
+			Name: "Invalid Platform",
+			Manifest: &registry.Manifest{
+				MediaType: listMediaType,
+				Body:      list,
+			},
+			Platforms:  []string{"linux"},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := test.Manifest.PlatformDigests(test.Platforms)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(test.Expected, got) {
+				t.Fatalf("expected %+v, got %+v", test.Expected, got)
+			}
+		})
+	}
+}
+
+func TestManifestVerifyDigest(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"schemaVersion": 2}`)
+	bodyDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+
+	tests := []struct {
+		Name       string
+		Manifest   *registry.Manifest
+		ShouldFail bool
+	}{
+		{
+			Name:     "Matching Header",
+			Manifest: &registry.Manifest{Digest: bodyDigest, Body: body},
+		},
+		{
+			Name:     "Missing Header",
+			Manifest: &registry.Manifest{Body: body},
+		},
+		{
+			Name: "Rewritten Header",
+			Manifest: &registry.Manifest{
+				Digest: busyboxLatestSHA,
+				Body:   body,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name:       "Empty Body",
+			Manifest:   &registry.Manifest{Digest: bodyDigest},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := test.Manifest.VerifyDigest()
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != bodyDigest {
+				t.Fatalf("expected %s, got %s", bodyDigest, got)
+			}
+		})
+	}
+}
+
+func TestV2Manifest(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"schemaVersion": 2}`)
+	bodyDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+
+	tests := []struct {
+		Name             string
+		HeadDigest       bool
+		RateLimited      bool
+		RemainingHeader  string
+		VerifyDigest     bool
+		ExpectedGetPaths []string
+		ShouldFail       bool
+	}{
+		{
+			Name:       "Head",
+			HeadDigest: true,
+		},
+		{
+			Name:             "Get Fallback",
+			ExpectedGetPaths: []string{"/v2/busybox/manifests/latest"},
+		},
+		{
+			Name:         "Body By Digest",
+			HeadDigest:   true,
+			VerifyDigest: true,
+			ExpectedGetPaths: []string{
+				"/v2/busybox/manifests/" + bodyDigest,
+			},
+		},
+		{
+			Name:            "Low Rate Limit",
+			HeadDigest:      true,
+			RemainingHeader: "1;w=21600",
+		},
+		{
+			Name:        "Rate Limited",
+			RateLimited: true,
+			ShouldFail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var getPaths []string
+
+			var mutex sync.Mutex
+
+			handler := func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("RateLimit-Limit", "100;w=21600")
+
+				if test.RemainingHeader != "" {
+					res.Header().Set(
+						"RateLimit-Remaining", test.RemainingHeader,
+					)
+				}
+
+				if test.RateLimited {
+					res.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+
+				if req.Method == http.MethodHead {
+					if test.HeadDigest {
+						res.Header().Set(
+							"Docker-Content-Digest", bodyDigest,
+						)
+					}
+
+					return
+				}
+
+				mutex.Lock()
+				getPaths = append(getPaths, req.URL.Path)
+				mutex.Unlock()
+
+				res.Header().Set(
+					"Docker-Content-Digest", bodyDigest,
+				)
+
+				if _, err := res.Write(body); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			v2, err := registry.NewV2(&registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := v2.Manifest("busybox", "latest", "")
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if manifest.Digest != bodyDigest {
+				t.Fatalf("expected %s, got %s", bodyDigest, manifest.Digest)
+			}
+
+			if test.VerifyDigest {
+				if _, err := manifest.VerifyDigest(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(test.ExpectedGetPaths, getPaths) {
+				t.Fatalf(
+					"expected GET requests for %v, got %v",
+					test.ExpectedGetPaths, getPaths,
+				)
+			}
+		})
+	}
+}
+
+func TestHTTPClientRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name             string
+		Retries          int
+		StatusCodes      []int
+		RetryAfter       string
+		ExpectedAttempts int
+		ExpectedErr      string
+		ShouldFail       bool
+	}{
+		{
+			Name:             "No Retries Needed",
+			Retries:          3,
+			StatusCodes:      []int{http.StatusOK},
+			ExpectedAttempts: 1,
+		},
+		{
+			Name:    "Transient Errors",
+			Retries: 3,
+			StatusCodes: []int{
+				http.StatusServiceUnavailable,
+				http.StatusTooManyRequests,
+				http.StatusOK,
+			},
+			ExpectedAttempts: 3,
+		},
+		{
+			Name:             "Retry After",
+			Retries:          1,
+			StatusCodes:      []int{http.StatusTooManyRequests, http.StatusOK},
+			RetryAfter:       "0",
+			ExpectedAttempts: 2,
+		},
+		{
+			Name:             "Retry After Too Long",
+			Retries:          3,
+			StatusCodes:      []int{http.StatusServiceUnavailable},
+			RetryAfter:       "3600",
+			ExpectedAttempts: 1,
+			ExpectedErr:      "failed after 1 attempts, got 503",
+			ShouldFail:       true,
+		},
+		{
+			Name:    "Too Many Attempts",
+			Retries: 2,
+			StatusCodes: []int{
+				http.StatusBadGateway,
+				http.StatusServiceUnavailable,
+				http.StatusTooManyRequests,
+			},
+			ExpectedAttempts: 3,
+			ExpectedErr:      "failed after 3 attempts, got 502, 503, 429",
+			ShouldFail:       true,
+		},
+		{
+			Name:             "Not Transient",
+			Retries:          3,
+			StatusCodes:      []int{http.StatusNotFound},
+			ExpectedAttempts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var attempts int
+
+			var mutex sync.Mutex
+
+			handler := func(res http.ResponseWriter, _ *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				statusCode := test.StatusCodes[len(test.StatusCodes)-1]
+				if attempts < len(test.StatusCodes) {
+					statusCode = test.StatusCodes[attempts]
+				}
+
+				attempts++
+
+				if test.RetryAfter != "" {
+					res.Header().Set("Retry-After", test.RetryAfter)
+				}
+
+				res.WriteHeader(statusCode)
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL,
+				Retry: &registry.RetryPolicy{
+					Retries: test.Retries,
+					Wait:    time.Millisecond,
+					MaxWait: 10 * time.Millisecond,
+				},
+			}
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				if !strings.Contains(err.Error(), test.ExpectedErr) {
+					t.Fatalf(
+						"expected '%s' to contain '%s'", err, test.ExpectedErr,
+					)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if attempts != test.ExpectedAttempts {
+				t.Fatalf(
+					"expected %d attempts, got %d",
+					test.ExpectedAttempts, attempts,
+				)
+			}
+		})
+	}
+}
+
+func TestCredentialResolver(t *testing.T) {
+	t.Parallel()
+
+	encode := func(auth string) string {
+		return base64.StdEncoding.EncodeToString([]byte(auth))
+	}
+
+	config := fmt.Sprintf(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "%s"},
+			"ghcr.io": {"auth": "%s"},
+			"https://quay.io/v1/": {"identitytoken": "refresh"},
+			"helper.example.com": {"auth": "%s"},
+			"https://gcr.io/v1/": {"auth": "%s"},
+			"gcr.io": {"auth": "%s"},
+			"https://example.com/v1/": {"auth": "%s"},
+			"http://example.com": {"auth": "%s"}
+		},
+		"credHelpers": {
+			"helper.example.com": "docker-lock-does-not-exist"
+		}
+	}`, encode("hub:hubpass"), encode("gh:ghpass:with:colons"),
+		encode("fallback:fallbackpass"), encode("url:urlpass"),
+		encode("exact:exactpass"), encode("https:httpspass"),
+		encode("http:httppass"))
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	configPath := filepath.Join(tempDir, "config.json")
+	if err = ioutil.WriteFile(configPath, []byte(config), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name       string
+		Host       string
+		Username   string
+		Password   string
+		ConfigPath string
+		Expected   *registry.AuthCredentials
+	}{
+		{
+			Name:       "Docker Hub",
+			Host:       "docker.io",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "hub", Password: "hubpass",
+			},
+		},
+		{
+			Name:       "Host",
+			Host:       "ghcr.io",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "gh", Password: "ghpass:with:colons",
+			},
+		},
+		{
+			Name:       "Identity Token",
+			Host:       "quay.io",
+			ConfigPath: configPath,
+			Expected:   &registry.AuthCredentials{IdentityToken: "refresh"},
+		},
+		{
+			Name:       "Missing Credential Helper",
+			Host:       "helper.example.com",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "fallback", Password: "fallbackpass",
+			},
+		},
+		{
+			Name:       "Exact Key",
+			Host:       "gcr.io",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "exact", Password: "exactpass",
+			},
+		},
+		{
+			Name:       "Sorted Keys",
+			Host:       "example.com",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "http", Password: "httppass",
+			},
+		},
+		{
+			Name:       "Unknown Host",
+			Host:       "localhost:5000",
+			ConfigPath: configPath,
+			Expected:   &registry.AuthCredentials{},
+		},
+		{
+			Name:       "Username And Password",
+			Host:       "ghcr.io",
+			Username:   "user",
+			Password:   "pass",
+			ConfigPath: configPath,
+			Expected: &registry.AuthCredentials{
+				Username: "user", Password: "pass",
+			},
+		},
+		{
+			Name:       "Missing Config",
+			Host:       "ghcr.io",
+			ConfigPath: filepath.Join(tempDir, "does-not-exist.json"),
+			Expected:   &registry.AuthCredentials{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			resolver := registry.NewCredentialResolver(test.ConfigPath)
+
+			got, err := resolver.Resolve(
+				test.Host, test.Username, test.Password,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(test.Expected, got) {
+				t.Fatalf("expected %+v, got %+v", test.Expected, got)
+			}
+		})
+	}
+}
+
+func TestV2CredentialsToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name              string
+		AuthCreds         *registry.AuthCredentials
+		GetNotAllowed     bool
+		RotateRefresh     bool
+		ExpiresIn         int
+		Calls             int
+		ExpectedRequests  int
+		ExpectedGrantType string
+		ShouldFail        bool
+	}{
+		{
+			Name:              "Identity Token",
+			AuthCreds:         &registry.AuthCredentials{IdentityToken: "ID"},
+			ExpiresIn:         300,
+			Calls:             1,
+			ExpectedRequests:  1,
+			ExpectedGrantType: "refresh_token",
+		},
+		{
+			Name:              "Username And Password",
+			AuthCreds:         &registry.AuthCredentials{Username: "user", Password: "pass"}, // nolint: lll
+			ExpiresIn:         300,
+			Calls:             1,
+			ExpectedRequests:  1,
+			ExpectedGrantType: "",
+		},
+		{
+			Name:              "Password Grant If GET Not Allowed",
+			AuthCreds:         &registry.AuthCredentials{Username: "user", Password: "pass"}, // nolint: lll
+			GetNotAllowed:     true,
+			ExpiresIn:         300,
+			Calls:             1,
+			ExpectedRequests:  2,
+			ExpectedGrantType: "password",
+		},
+		{
+			Name:              "Cached Token",
+			AuthCreds:         &registry.AuthCredentials{IdentityToken: "ID"},
+			ExpiresIn:         300,
+			Calls:             3,
+			ExpectedRequests:  1,
+			ExpectedGrantType: "refresh_token",
+		},
+		{
+			Name:              "Expired Token With Rotated Refresh Token",
+			AuthCreds:         &registry.AuthCredentials{IdentityToken: "ID"},
+			RotateRefresh:     true,
+			ExpiresIn:         1,
+			Calls:             3,
+			ExpectedRequests:  3,
+			ExpectedGrantType: "refresh_token",
+		},
+		{
+			Name:       "Invalid Identity Token",
+			AuthCreds:  &registry.AuthCredentials{IdentityToken: "BAD"},
+			ExpiresIn:  300,
+			Calls:      1,
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tokenServer := mockTokenServer(
+				t, test.GetNotAllowed, test.RotateRefresh, test.ExpiresIn,
+			)
+			defer tokenServer.server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      tokenServer.server.Client(),
+				RegistryURL: tokenServer.server.URL + "/v2",
+			}
+
+			r, err := registry.NewV2(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge := &registry.Challenge{
+				Scheme:  "bearer",
+				Realm:   tokenServer.server.URL + "/token",
+				Service: "mock",
+			}
+
+			var tokens []string
+
+			for i := 0; i < test.Calls; i++ {
+				token, err := r.CredentialsToken(
+					challenge, "org/busybox", test.AuthCreds,
+				)
+				if test.ShouldFail {
+					if err == nil {
+						t.Fatal("expected error but did not get one")
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				tokens = append(tokens, token)
+			}
+
+			tokenServer.mutex.Lock()
+			defer tokenServer.mutex.Unlock()
+
+			if tokenServer.requests != test.ExpectedRequests {
+				t.Fatalf(
+					"expected %d token requests, got %d",
+					test.ExpectedRequests, tokenServer.requests,
+				)
+			}
+
+			if tokenServer.grantType != test.ExpectedGrantType {
+				t.Fatalf(
+					"expected grant_type '%s', got '%s'",
+					test.ExpectedGrantType, tokenServer.grantType,
+				)
+			}
+
+			if tokens[len(tokens)-1] != tokenServer.token {
+				t.Fatalf(
+					"expected token %s, got %s",
+					tokenServer.token, tokens[len(tokens)-1],
+				)
+			}
+		})
+	}
+}
+
+func TestV2Challenge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name              string
+		StatusCode        int
+		WWWAuthenticate   string
+		ExpectedChallenge *registry.Challenge
+		ShouldFail        bool
+	}{
+		{
+			Name:       "No Authentication",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:            "Bearer Challenge",
+			StatusCode:      http.StatusUnauthorized,
+			WWWAuthenticate: `Bearer realm="https://auth.example.com/token"`,
+			ExpectedChallenge: &registry.Challenge{
+				Scheme: "bearer",
+				Realm:  "https://auth.example.com/token",
+			},
+		},
+		{
+			Name:       "Unauthorized Without Challenge",
+			StatusCode: http.StatusUnauthorized,
+			ShouldFail: true,
+		},
+		{
+			Name:       "Server Error",
+			StatusCode: http.StatusInternalServerError,
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(res http.ResponseWriter, req *http.Request) {
+						if test.WWWAuthenticate != "" {
+							res.Header().Set(
+								"WWW-Authenticate", test.WWWAuthenticate,
+							)
+						}
+
+						res.WriteHeader(test.StatusCode)
+					},
+				),
+			)
+			defer server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			r, err := registry.NewV2(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Challenge()
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(test.ExpectedChallenge, got) {
+				t.Fatalf(
+					"expected %+v, got %+v", test.ExpectedChallenge, got,
+				)
+			}
+		})
+	}
+}
+
+// tokenServer is a mock token server that accepts the identity token "ID"
+// as a refresh token, and the username "user" with password "pass".
+type tokenServer struct {
+	server       *httptest.Server
+	requests     int
+	grantType    string
+	refreshToken string
+	token        string
+	mutex        sync.Mutex
+}
+
+func mockTokenServer(
+	t *testing.T,
+	getNotAllowed bool,
+	rotateRefresh bool,
+	expiresIn int,
+) *tokenServer {
+	t.Helper()
+
+	s := &tokenServer{refreshToken: "ID"}
+
+	s.server = httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			s.requests++
+
+			if req.URL.Path != "/token" {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			var (
+				service, scope string
+				authorized     bool
+			)
+
+			switch req.Method {
+			case http.MethodGet:
+				if getNotAllowed {
+					res.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+
+				username, password, _ := req.BasicAuth()
+				authorized = username == "user" && password == "pass"
+				service = req.URL.Query().Get("service")
+				scope = req.URL.Query().Get("scope")
+				s.grantType = ""
+			case http.MethodPost:
+				if err := req.ParseForm(); err != nil {
+					res.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				s.grantType = req.PostForm.Get("grant_type")
+
+				switch s.grantType {
+				case "refresh_token":
+					authorized = req.PostForm.Get("refresh_token") ==
+						s.refreshToken
+				case "password":
+					authorized = req.PostForm.Get("username") == "user" &&
+						req.PostForm.Get("password") == "pass"
+				}
+
+				authorized = authorized &&
+					req.PostForm.Get("client_id") == "docker-lock"
+				service = req.PostForm.Get("service")
+				scope = req.PostForm.Get("scope")
+			}
+
+			if !authorized {
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if service != "mock" || scope != "repository:org/busybox:pull" {
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			s.token = fmt.Sprintf("TOKEN%d", s.requests)
+
+			if rotateRefresh {
+				s.refreshToken = fmt.Sprintf("REFRESH%d", s.requests)
+			}
+
+			fmt.Fprintf(
+				res,
+				`{"access_token": "%s", "refresh_token": "%s", "expires_in": %d}`, // nolint: lll
+				s.token, s.refreshToken, expiresIn,
+			)
+		}))
+
+	return s
+}
+
+func TestMirroredWrapper(t *testing.T) {
+	t.Parallel()
+
+	const (
+		mirrorDigest   = busyboxLatestSHA
+		upstreamDigest = "upstream"
+		prefixDigest   = "prefix"
+	)
+
+	tests := []struct {
+		Name       string
+		Line       string
+		Fallback   bool
+		Expected   string
+		ShouldFail bool
+	}{
+		{
+			Name:     "Official Image",
+			Line:     "busybox",
+			Expected: mirrorDigest,
+		},
+		{
+			Name:     "Docker Hub Host",
+			Line:     "docker.io/library/busybox",
+			Expected: mirrorDigest,
+		},
+		{
+			Name:     "Docker Hub Namespace",
+			Line:     "org/busybox",
+			Expected: mirrorDigest,
+		},
+		{
+			Name:     "Fallback",
+			Line:     "missing",
+			Fallback: true,
+			Expected: upstreamDigest,
+		},
+		{
+			Name:       "No Fallback",
+			Line:       "missing",
+			ShouldFail: true,
+		},
+		{
+			Name:     "Other Registry",
+			Line:     "ghcr.io/org/busybox",
+			Expected: upstreamDigest,
+		},
+		{
+			Name:     "Prefix",
+			Line:     "my-org/busybox",
+			Expected: prefixDigest,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(res http.ResponseWriter, req *http.Request) {
+						switch req.URL.Path {
+						case "/v2/":
+						case "/v2/library/busybox/manifests/latest",
+							"/v2/org/busybox/manifests/latest":
+							res.Header().Set(
+								"Docker-Content-Digest", mirrorDigest,
+							)
+						default:
+							res.WriteHeader(http.StatusNotFound)
+						}
+					},
+				),
+			)
+			defer server.Close()
+
+			client := &registry.HTTPClient{
+				Client:      server.Client(),
+				RegistryURL: server.URL + "/v2",
+			}
+
+			mirror, err := registry.NewMirror(
+				client, "", &registry.MirrorConfig{
+					Upstream: "docker.io",
+					URL:      server.URL,
+					Fallback: test.Fallback,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			upstream := &mockWrapper{digest: upstreamDigest}
+
+			wrapperManager := registry.NewWrapperManager(upstream)
+			wrapperManager.SetHostWrapper(upstream)
+			wrapperManager.Add(
+				&mockWrapper{digest: prefixDigest, prefix: "my-org/"},
+			)
+			wrapperManager.AddMirrors(mirror)
+
+			digest, err := wrapperManager.Wrapper(test.Line).Digest(
+				test.Line, "latest",
+			)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if digest != test.Expected {
+				t.Fatalf("expected %s, got %s", test.Expected, digest)
+			}
+		})
+	}
+}
+
+// mockWrapper returns the same digest for every repo.
+type mockWrapper struct {
+	digest string
+	prefix string
+}
+
+func (m *mockWrapper) Digest(string, string) (string, error) {
+	return m.digest, nil
+}
+
+func (m *mockWrapper) Prefix() string {
+	return m.prefix
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		PlainHTTP  bool
+		MutualTLS  bool
+		CertsDir   bool
+		CAFile     bool
+		ClientCert bool
+		Insecure   bool
+		ShouldFail bool
+	}{
+		{
+			Name:   "CA File",
+			CAFile: true,
+		},
+		{
+			Name:     "Certs Dir",
+			CertsDir: true,
+		},
+		{
+			Name:       "Unknown CA",
+			ShouldFail: true,
+		},
+		{
+			Name:     "Insecure",
+			Insecure: true,
+		},
+		{
+			Name:      "Plain HTTP Localhost",
+			PlainHTTP: true,
+		},
+		{
+			Name:       "Client Certificate",
+			MutualTLS:  true,
+			CertsDir:   true,
+			ClientCert: true,
+		},
+		{
+			Name:       "Missing Client Certificate",
+			MutualTLS:  true,
+			CertsDir:   true,
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tempDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			clientCertPEM, clientKeyPEM := generateClientCertificate(t)
+
+			handler := http.HandlerFunc(
+				func(res http.ResponseWriter, req *http.Request) {},
+			)
+
+			var server *httptest.Server
+
+			switch {
+			case test.PlainHTTP:
+				server = httptest.NewServer(handler)
+			case test.MutualTLS:
+				clientCAs := x509.NewCertPool()
+				clientCAs.AppendCertsFromPEM(clientCertPEM)
+
+				server = httptest.NewUnstartedServer(handler)
+				server.TLS = &tls.Config{
+					ClientAuth: tls.RequireAndVerifyClientCert,
+					ClientCAs:  clientCAs,
+				}
+				server.StartTLS()
+			default:
+				server = httptest.NewTLSServer(handler)
+			}
+			defer server.Close()
+
+			serverURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			host := serverURL.Host
+			hostDir := filepath.Join(tempDir, host)
+
+			if err = os.MkdirAll(hostDir, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			var caPEM []byte
+
+			if server.Certificate() != nil {
+				caPEM = pem.EncodeToMemory(&pem.Block{
+					Type: "CERTIFICATE", Bytes: server.Certificate().Raw,
+				})
+			}
+
+			files := map[string][]byte{}
+
+			if test.CertsDir {
+				files[filepath.Join(hostDir, "ca.crt")] = caPEM
+			}
+
+			if test.ClientCert {
+				files[filepath.Join(hostDir, "client.cert")] = clientCertPEM
+				files[filepath.Join(hostDir, "client.key")] = clientKeyPEM
+			}
+
+			tlsConfig := &registry.TLSConfig{
+				Host:     host,
+				Insecure: test.Insecure,
+			}
+
+			if test.CAFile {
+				tlsConfig.CAFile = filepath.Join(tempDir, "ca.pem")
+				files[tlsConfig.CAFile] = caPEM
+			}
+
+			for path, contents := range files {
+				if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			transport, err := registry.NewTransport(
+				[]*registry.TLSConfig{tlsConfig}, []string{tempDir},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: transport}
+
+			resp, err := client.Get(fmt.Sprintf("https://%s/v2/", host))
+			if test.ShouldFail {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK,
+					resp.StatusCode,
+				)
+			}
+		})
+	}
+}
+
+// generateClientCertificate returns a PEM encoded, self-signed client
+// certificate and its key.
+func generateClientCertificate(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "docker-lock"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature |
+			x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: certDER},
+	)
+	keyPEM := pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER},
+	)
+
+	return certPEM, keyPEM
+}
+
+func TestPluginWrapper(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name       string
+		Config     *registry.PluginConfig
+		Line       string
+		Expected   string
+		ShouldFail bool
+	}{
+		{
+			Name: "Digest",
+			Config: &registry.PluginConfig{
+				Prefix:  "artifacts/",
+				Command: os.Args[0],
+				Args:    pluginHelperArgs,
+			},
+			Line:     "artifacts/busybox",
+			Expected: busyboxLatestSHA,
+		},
+		{
+			Name: "Error Response",
+			Config: &registry.PluginConfig{
+				Prefix:  "artifacts/",
+				Command: os.Args[0],
+				Args:    pluginHelperArgs,
+			},
+			Line:       "artifacts/error",
+			ShouldFail: true,
+		},
+		{
+			Name: "Exit Status",
+			Config: &registry.PluginConfig{
+				Prefix:  "artifacts/",
+				Command: os.Args[0],
+				Args:    pluginHelperArgs,
+			},
+			Line:       "artifacts/exit",
+			ShouldFail: true,
+		},
+		{
+			Name: "Missing Plugin",
+			Config: &registry.PluginConfig{
+				Prefix: "artifacts/",
+				Name:   "does-not-exist",
+			},
+			Line:       "artifacts/busybox",
+			ShouldFail: true,
+		},
+		{
+			Name: "Name And Command",
+			Config: &registry.PluginConfig{
+				Prefix:  "artifacts/",
+				Name:    "artifacts",
+				Command: os.Args[0],
+			},
+			Line:       "artifacts/busybox",
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			plugin, err := registry.NewPluginWrapper(test.Config)
+			if err == nil {
+				wrapperManager := registry.NewWrapperManager(
+					&mockWrapper{digest: "default"},
+				)
+				wrapperManager.Add(plugin)
+
+				var digest string
+
+				digest, err = wrapperManager.Wrapper(test.Line).Digest(
+					test.Line, "latest",
+				)
+				if err == nil && digest != test.Expected {
+					t.Fatalf("expected %s, got %s", test.Expected, digest)
+				}
+			}
+
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// pluginHelperArgs run TestPluginHelperProcess when the test binary is used
+// as a plugin.
+var pluginHelperArgs = []string{ // nolint: gochecknoglobals
+	"-test.run=TestPluginHelperProcess", "--", "plugin-helper",
+}
+
+// TestPluginHelperProcess is not a real test. It is run as a plugin by
+// TestPluginWrapper.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Args[len(os.Args)-1] != "plugin-helper" {
+		return
+	}
+
+	var request registry.PluginRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var response registry.PluginResponse
+
+	switch request.Repo {
+	case "artifacts/busybox":
+		response.Digest = busyboxLatestSHA
+	case "artifacts/exit":
+		fmt.Fprint(os.Stderr, "artifact store is unavailable")
+		os.Exit(1)
+	default:
+		response.Error = fmt.Sprintf("'%s' does not exist", request.Repo)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(&response); err != nil {
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
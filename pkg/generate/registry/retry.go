@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for RetryPolicy, as used by the cli.
+const (
+	DefaultRetries      = 3
+	DefaultRetryWait    = time.Second
+	DefaultRetryMaxWait = 30 * time.Second
+)
+
+// RetryPolicy configures how HTTPClient retries requests that fail with
+// connection errors or transient status codes, such as 429 or 503.
+//
+// Retries wait for exponentially longer periods starting at Wait, with
+// jitter so that concurrent requests do not retry at the same time, up to
+// MaxWait. If the registry sends a Retry-After header, that period is
+// waited instead. If it is longer than MaxWait, no more retries are made.
+type RetryPolicy struct {
+	Retries int
+	Wait    time.Duration
+	MaxWait time.Duration
+}
+
+// Do sends the request, retrying according to the client's RetryPolicy.
+// If every attempt fails, the error contains the number of attempts and
+// the status codes that the registry returned. Without a RetryPolicy, the
+// request is sent once.
+func (h *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	var (
+		attempts []string
+		resp     *http.Response
+		err      error
+	)
+
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = h.Client.Do(req)
+
+		if !h.shouldRetry(attempt, resp, err) {
+			break
+		}
+
+		wait, ok := h.Retry.wait(attempt, resp)
+
+		if err != nil {
+			attempts = append(attempts, err.Error())
+		} else {
+			attempts = append(attempts, strconv.Itoa(resp.StatusCode))
+
+			// drain the body so that the connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if !ok {
+			return nil, fmt.Errorf(
+				"%s, not retrying since the registry asked to wait %s",
+				retryError(req, attempts, resp), wait,
+			)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if len(attempts) == 0 {
+		return resp, err
+	}
+
+	if err != nil {
+		attempts = append(attempts, err.Error())
+		return nil, retryError(req, attempts, nil)
+	}
+
+	if isTransient(resp.StatusCode) {
+		attempts = append(attempts, strconv.Itoa(resp.StatusCode))
+		resp.Body.Close()
+
+		return nil, retryError(req, attempts, resp)
+	}
+
+	return resp, nil
+}
+
+// Get sends a GET request to the url, retrying as in Do.
+func (h *HTTPClient) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Do(req)
+}
+
+// shouldRetry returns true if the attempt failed with a connection error
+// or transient status code and the RetryPolicy allows another attempt.
+func (h *HTTPClient) shouldRetry(
+	attempt int,
+	resp *http.Response,
+	err error,
+) bool {
+	if h.Retry == nil || attempt >= h.Retry.Retries {
+		return false
+	}
+
+	return err != nil || isTransient(resp.StatusCode)
+}
+
+// wait returns how long to wait before the next attempt. If the registry
+// asked to wait longer than MaxWait, false is returned.
+func (r *RetryPolicy) wait(
+	attempt int,
+	resp *http.Response,
+) (time.Duration, bool) {
+	maxWait := r.MaxWait
+	if maxWait <= 0 {
+		maxWait = DefaultRetryMaxWait
+	}
+
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(
+			resp.Header.Get("Retry-After"),
+		); ok {
+			return retryAfter, retryAfter <= maxWait
+		}
+	}
+
+	wait := r.Wait
+	for i := 0; i < attempt && wait < maxWait; i++ {
+		wait *= 2
+	}
+
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	// wait for a random period between half and all of the backoff
+	jitter := time.Duration(rand.Int63n(int64(wait)/2 + 1)) // nolint: gosec
+
+	return wait - jitter, true
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := time.Until(date)
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait, true
+}
+
+// isTransient returns true for status codes that may succeed if the
+// request is retried.
+func isTransient(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusRequestTimeout ||
+		(statusCode >= http.StatusInternalServerError &&
+			statusCode != http.StatusNotImplemented &&
+			statusCode != http.StatusHTTPVersionNotSupported)
+}
+
+// retryError describes every failed attempt. If the last attempt exceeded
+// the registry's rate limit, the error explains how to raise the limit.
+func retryError(
+	req *http.Request,
+	attempts []string,
+	resp *http.Response,
+) error {
+	err := fmt.Errorf(
+		"%s '%s' failed after %d attempts, got %s",
+		req.Method, req.URL, len(attempts),
+		strings.Join(attempts, ", "),
+	)
+
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		err = fmt.Errorf("%s: %s", err, rateLimitError(resp))
+	}
+
+	return err
+}
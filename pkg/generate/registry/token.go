@@ -0,0 +1,298 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenClientID identifies docker-lock to token servers, as required by
+// the OAuth2 flow of the Token Authentication Specification.
+const tokenClientID = "docker-lock"
+
+// Token lifetimes from the Token Authentication Specification. If the token
+// server does not send expires_in, the token expires after a minute. Tokens
+// are refreshed slightly before they expire, so that they do not expire
+// while a request is in flight.
+const (
+	defaultTokenExpiresIn = 60 * time.Second
+	tokenExpiryMargin     = 10 * time.Second
+)
+
+// bearerToken is an access token along with when it expires.
+type bearerToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// oauth2TokenResponse is the response from a token server as described in
+// https://docs.docker.com/registry/spec/auth/token/ and
+// https://docs.docker.com/registry/spec/auth/oauth/
+type oauth2TokenResponse struct {
+	Token        string    `json:"token"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int       `json:"expires_in"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+// CredentialsToken returns a token that grants pull access to the repo from
+// the realm of a bearer challenge. If the credentials contain an identity
+// token, as written to docker's config.json by 'docker login' for
+// registries such as ACR or Harbor with OIDC, it is exchanged for an access
+// token with the OAuth2 refresh_token grant. Otherwise, the username and
+// password are sent with basic auth. If the token server does not accept
+// GET requests, the OAuth2 password grant is used instead.
+//
+// Tokens are cached by the client until shortly before they expire, so
+// that long runs transparently request new tokens.
+func (v *V2) CredentialsToken(
+	challenge *Challenge,
+	repo string,
+	authCreds *AuthCredentials,
+) (string, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+	key := strings.Join(
+		[]string{challenge.Realm, challenge.Service, scope}, " ",
+	)
+
+	if token, ok := v.Client.cachedToken(key); ok {
+		return token, nil
+	}
+
+	t, err := v.requestToken(challenge, repo, scope, authCreds)
+	if err != nil {
+		return "", err
+	}
+
+	value := t.AccessToken
+	if value == "" {
+		value = t.Token
+	}
+
+	if value == "" {
+		return "", fmt.Errorf(
+			"token server '%s' did not return a token for '%s'",
+			challenge.Realm, repo,
+		)
+	}
+
+	if t.RefreshToken != "" {
+		v.Client.setRefreshToken(
+			challenge.Realm, challenge.Service, t.RefreshToken,
+		)
+	}
+
+	v.Client.cacheToken(key, &bearerToken{
+		value:     value,
+		expiresAt: t.expiresAt(),
+	})
+
+	return value, nil
+}
+
+// requestToken requests a token with the OAuth2 flow if there is an identity
+// token, or with a GET request otherwise.
+func (v *V2) requestToken(
+	challenge *Challenge,
+	repo string,
+	scope string,
+	authCreds *AuthCredentials,
+) (*oauth2TokenResponse, error) {
+	if refreshToken := v.Client.refreshToken(
+		challenge.Realm, challenge.Service, authCreds.IdentityToken,
+	); refreshToken != "" {
+		return v.oauth2Token(challenge, scope, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+	}
+
+	tokenURL, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return nil, err
+	}
+
+	query := tokenURL.Query()
+	if challenge.Service != "" {
+		query.Set("service", challenge.Service)
+	}
+
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if authCreds.Username != "" && authCreds.Password != "" {
+		req.SetBasicAuth(authCreds.Username, authCreds.Password)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return decodeTokenResponse(resp)
+	case (resp.StatusCode == http.StatusNotFound ||
+		resp.StatusCode == http.StatusMethodNotAllowed) &&
+		authCreds.Username != "" && authCreds.Password != "":
+		return v.oauth2Token(challenge, scope, url.Values{
+			"grant_type": {"password"},
+			"username":   {authCreds.Username},
+			"password":   {authCreds.Password},
+		})
+	default:
+		return nil, fmt.Errorf(
+			"failed to get a token for '%s' from '%s', got status %d",
+			repo, challenge.Realm, resp.StatusCode,
+		)
+	}
+}
+
+// oauth2Token requests a token for the scope from the realm of a bearer
+// challenge with the OAuth2 flow of the Token Authentication Specification.
+// grant contains the grant_type and its parameters, such as a
+// refresh_token, or a username and password.
+func (v *V2) oauth2Token(
+	challenge *Challenge,
+	scope string,
+	grant url.Values,
+) (*oauth2TokenResponse, error) {
+	form := url.Values{}
+	for k, vals := range grant {
+		form[k] = vals
+	}
+
+	form.Set("client_id", tokenClientID)
+	form.Set("scope", scope)
+
+	if challenge.Service != "" {
+		form.Set("service", challenge.Service)
+	}
+
+	body := form.Encode()
+
+	req, err := http.NewRequest(
+		http.MethodPost, challenge.Realm, strings.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"failed to get a token with grant_type '%s' from '%s', "+
+				"got status %d",
+			grant.Get("grant_type"), challenge.Realm, resp.StatusCode,
+		)
+	}
+
+	return decodeTokenResponse(resp)
+}
+
+// decodeTokenResponse decodes the json body of a token server's response.
+func decodeTokenResponse(resp *http.Response) (*oauth2TokenResponse, error) {
+	t := &oauth2TokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// expiresAt returns when the token expires. If the token server did not
+// send when the token was issued, it is assumed to have been issued now.
+func (t *oauth2TokenResponse) expiresAt() time.Time {
+	issuedAt := t.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+
+	expiresIn := time.Duration(t.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenExpiresIn
+	}
+
+	return issuedAt.Add(expiresIn)
+}
+
+// cachedToken returns the cached token for the key if it does not expire
+// soon.
+func (h *HTTPClient) cachedToken(key string) (string, bool) {
+	h.tokenMutex.Lock()
+	defer h.tokenMutex.Unlock()
+
+	token, ok := h.tokens[key]
+	if !ok || time.Now().Add(tokenExpiryMargin).After(token.expiresAt) {
+		return "", false
+	}
+
+	return token.value, true
+}
+
+// cacheToken caches the token for the key.
+func (h *HTTPClient) cacheToken(key string, token *bearerToken) {
+	h.tokenMutex.Lock()
+	defer h.tokenMutex.Unlock()
+
+	if h.tokens == nil {
+		h.tokens = map[string]*bearerToken{}
+	}
+
+	h.tokens[key] = token
+}
+
+// refreshToken returns the latest refresh token for the realm and service.
+// Token servers may rotate refresh tokens, in which case the token that
+// they returned replaces the identity token from docker's config.json.
+func (h *HTTPClient) refreshToken(
+	realm string,
+	service string,
+	identityToken string,
+) string {
+	if identityToken == "" {
+		return ""
+	}
+
+	h.tokenMutex.Lock()
+	defer h.tokenMutex.Unlock()
+
+	if refreshToken, ok := h.refreshTokens[realm+" "+service]; ok {
+		return refreshToken
+	}
+
+	return identityToken
+}
+
+// setRefreshToken stores a refresh token returned by a token server.
+func (h *HTTPClient) setRefreshToken(
+	realm string,
+	service string,
+	refreshToken string,
+) {
+	h.tokenMutex.Lock()
+	defer h.tokenMutex.Unlock()
+
+	if h.refreshTokens == nil {
+		h.refreshTokens = map[string]string{}
+	}
+
+	h.refreshTokens[realm+" "+service] = refreshToken
+}
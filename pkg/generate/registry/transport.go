@@ -0,0 +1,310 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TLSConfig configures TLS for a registry host, as in an entry of the tls
+// section of .docker-lock.yml.
+type TLSConfig struct {
+	// Host is the registry host, as in harbor.my-org.com or localhost:5000.
+	Host string `mapstructure:"host"`
+
+	// CAFile is a PEM encoded bundle of certificate authorities that are
+	// trusted in addition to the system's.
+	CAFile string `mapstructure:"ca-file"`
+
+	// CertFile and KeyFile are a PEM encoded client certificate and key
+	// for registries that require mutual TLS.
+	CertFile string `mapstructure:"cert-file"`
+	KeyFile  string `mapstructure:"key-file"`
+
+	// Insecure is true if the registry's certificate should not be verified
+	// and the registry may be queried over plain HTTP.
+	Insecure bool `mapstructure:"insecure"`
+}
+
+// Transport is an http.RoundTripper that configures TLS per registry host.
+// As with dockerd, certificates are also read from the certs.d directory
+// layout, in which <certs dir>/<host>/ contains CA certificates ending in
+// .crt and client certificate and key pairs ending in .cert and .key.
+//
+// Hosts that are Insecure, as well as localhost, may be queried over plain
+// HTTP. If such a host responds to HTTPS with HTTP, the request is sent
+// again with HTTP, as are later requests to the same host.
+//
+// Proxies are read from HTTPS_PROXY, HTTP_PROXY, and NO_PROXY.
+type Transport struct {
+	configs    map[string]*TLSConfig
+	certsDirs  []string
+	transports map[string]http.RoundTripper
+	plainHTTP  map[string]bool
+	mutex      sync.Mutex
+}
+
+// DefaultCertsDirs returns the certs.d directories of dockerd and rootless
+// dockerd.
+func DefaultCertsDirs() []string {
+	certsDirs := []string{"/etc/docker/certs.d"}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		certsDirs = append(
+			certsDirs, filepath.Join(homeDir, ".config", "docker", "certs.d"),
+		)
+	}
+
+	return certsDirs
+}
+
+// NewTransport creates a Transport after validating the configs. certsDirs
+// are searched for certs.d directories in order.
+func NewTransport(
+	configs []*TLSConfig,
+	certsDirs []string,
+) (*Transport, error) {
+	t := &Transport{
+		configs:    map[string]*TLSConfig{},
+		certsDirs:  certsDirs,
+		transports: map[string]http.RoundTripper{},
+		plainHTTP:  map[string]bool{},
+	}
+
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+
+		t.configs[config.Host] = config
+	}
+
+	return t, nil
+}
+
+// Validate returns an error if the config does not declare a host, or
+// declares only one of a client certificate and key.
+func (c *TLSConfig) Validate() error {
+	if c.Host == "" {
+		return errors.New("tls host is empty")
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf(
+			"tls for '%s' must declare both cert-file and key-file", c.Host,
+		)
+	}
+
+	return nil
+}
+
+// RoundTrip sends the request with the TLS configuration for its host.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	transport, err := t.transport(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme != "https" || !t.allowsPlainHTTP(host) {
+		return transport.RoundTrip(req)
+	}
+
+	t.mutex.Lock()
+	plainHTTP := t.plainHTTP[host]
+	t.mutex.Unlock()
+
+	if !plainHTTP {
+		var resp *http.Response
+
+		resp, err = transport.RoundTrip(req)
+
+		// as in net/http, a server that responds to HTTPS with HTTP is
+		// detected by the first bytes of its response
+		var headerErr tls.RecordHeaderError
+		if !errors.As(err, &headerErr) ||
+			!strings.HasPrefix(string(headerErr.RecordHeader[:]), "HTTP/") {
+			return resp, err
+		}
+
+		t.mutex.Lock()
+		t.plainHTTP[host] = true
+		t.mutex.Unlock()
+	}
+
+	httpReq := req.Clone(req.Context())
+	httpReq.URL.Scheme = "http"
+
+	if req.GetBody != nil {
+		if httpReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return transport.RoundTrip(httpReq)
+}
+
+// allowsPlainHTTP returns true if the host is Insecure or is localhost.
+func (t *Transport) allowsPlainHTTP(host string) bool {
+	if config, ok := t.configs[host]; ok && config.Insecure {
+		return true
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	if hostname == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(hostname)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// transport returns the http.Transport for the host, creating it the first
+// time the host is queried.
+func (t *Transport) transport(host string) (http.RoundTripper, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if transport, ok := t.transports[host]; ok {
+		return transport, nil
+	}
+
+	tlsConfig, err := t.tlsConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+
+	t.transports[host] = transport
+
+	return transport, nil
+}
+
+// tlsConfig returns the tls.Config for the host from its certs.d
+// directories and its TLSConfig.
+func (t *Transport) tlsConfig(host string) (*tls.Config, error) {
+	var (
+		caFiles   []string
+		certPairs [][2]string
+	)
+
+	for _, certsDir := range t.certsDirs {
+		hostCAFiles, hostCertPairs, err := readCertsDir(
+			filepath.Join(certsDir, host),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		caFiles = append(caFiles, hostCAFiles...)
+		certPairs = append(certPairs, hostCertPairs...)
+	}
+
+	tlsConfig := &tls.Config{} // nolint: gosec
+
+	if config, ok := t.configs[host]; ok {
+		if config.CAFile != "" {
+			caFiles = append(caFiles, config.CAFile)
+		}
+
+		if config.CertFile != "" {
+			certPairs = append(
+				certPairs, [2]string{config.CertFile, config.KeyFile},
+			)
+		}
+
+		tlsConfig.InsecureSkipVerify = config.Insecure
+	}
+
+	if len(caFiles) != 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		for _, caFile := range caFiles {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+
+			if !rootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf(
+					"no certificates found in '%s' for '%s'", caFile, host,
+				)
+			}
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	for _, pair := range certPairs {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid client certificate for '%s': %s", host, err,
+			)
+		}
+
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+
+	return tlsConfig, nil
+}
+
+// readCertsDir returns the CA certificates and client certificate and key
+// pairs in a certs.d directory for a host. If the directory does not
+// exist, nothing is returned.
+func readCertsDir(dir string) ([]string, [][2]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+
+		return nil, nil, err
+	}
+
+	var (
+		caFiles   []string
+		certPairs [][2]string
+	)
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+
+		switch filepath.Ext(f.Name()) {
+		case ".crt":
+			caFiles = append(caFiles, path)
+		case ".cert":
+			keyPath := strings.TrimSuffix(path, ".cert") + ".key"
+			if _, err := os.Stat(keyPath); err != nil {
+				return nil, nil, fmt.Errorf(
+					"missing key '%s' for client certificate '%s'",
+					keyPath, path,
+				)
+			}
+
+			certPairs = append(certPairs, [2]string{path, keyPath})
+		}
+	}
+
+	return caFiles, certPairs, nil
+}
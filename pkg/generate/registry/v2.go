@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
-	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
 )
 
 // V2 provides methods to get digests and tokens according to the
@@ -27,13 +29,15 @@ type TokenExtractor interface {
 }
 
 // DefaultTokenExtractor provides a concrete implementation for registries
-// whose json response returns the token with the key "token".
+// whose json response returns the token with the key "token" or, as in
+// the OAuth2 spec, "access_token".
 type DefaultTokenExtractor struct{}
 
 // defaultTokenResponse contains the bearer token required to query some
 // container registries.
 type defaultTokenResponse struct {
-	Token string `json:"token"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
 }
 
 // NewV2 returns a *V2 with a client initialized or an error if the client is
@@ -49,37 +53,300 @@ func NewV2(client *HTTPClient) (*V2, error) {
 // Digest queries the container registry for the digest given a repo, ref, and
 // token. If a token is not required, leave it empty.
 func (v *V2) Digest(repo, ref, token string) (string, error) {
-	url := fmt.Sprintf("%s/%s/manifests/%s", v.Client.RegistryURL, repo, ref)
-
-	req, err := http.NewRequest("GET", url, nil)
+	manifest, err := v.Manifest(repo, ref, token)
 	if err != nil {
 		return "", err
 	}
 
-	if token != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// Manifest queries the container registry for the manifest given a repo, ref,
+// and token. If a token is not required, leave it empty. Unlike Digest, no
+// error is returned if the registry does not send the Docker-Content-Digest
+// header, so that the digest can be computed from the manifest's body.
+func (v *V2) Manifest(repo, ref, token string) (*Manifest, error) {
+	return v.manifest(repo, ref, func(req *http.Request) {
+		if token != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+	})
+}
+
+// ResolveDigest queries the container registry for the digest given a repo
+// and ref, authenticating however the registry asks to in its
+// WWW-Authenticate challenge. Bearer tokens are requested from the
+// advertised realm, so registries that implement the Token Authentication
+// Specification work without a hard-coded token url. If username and
+// password are empty, anonymous access is attempted.
+func (v *V2) ResolveDigest(
+	repo string,
+	ref string,
+	username string,
+	password string,
+) (string, error) {
+	manifest, err := v.ResolveManifest(repo, ref, username, password)
+	if err != nil {
+		return "", err
 	}
 
-	req.Header.Add(
-		"Accept", "application/vnd.docker.distribution.manifest.v2+json",
+	return manifest.HeaderDigest(repo, ref)
+}
+
+// ResolveManifest is the same as ResolveDigest, except that it returns the
+// whole manifest. As in Manifest, the Docker-Content-Digest header is not
+// required.
+func (v *V2) ResolveManifest(
+	repo string,
+	ref string,
+	username string,
+	password string,
+) (*Manifest, error) {
+	return v.ResolveManifestWithCredentials(
+		repo, ref, &AuthCredentials{Username: username, Password: password},
 	)
-	req.Header.Add(
-		"Accept", "application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// ResolveManifestWithCredentials is the same as ResolveManifest, except that
+// the credentials may contain an identity token, which is exchanged for
+// bearer tokens as described in CredentialsToken. Since bearer tokens are
+// requested again once they expire, the manifest's body may be requested
+// long after the manifest was resolved.
+func (v *V2) ResolveManifestWithCredentials(
+	repo string,
+	ref string,
+	authCreds *AuthCredentials,
+) (*Manifest, error) {
+	challenge, err := v.Challenge()
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge == nil {
+		return v.Manifest(repo, ref, "")
+	}
+
+	switch challenge.Scheme {
+	case "bearer":
+		token, err := v.CredentialsToken(challenge, repo, authCreds)
+		if err != nil {
+			return nil, err
+		}
+
+		return v.manifest(repo, ref, func(req *http.Request) {
+			// the cached token is returned unless it has expired, in which
+			// case a new one is requested. If that fails, the expired token
+			// is sent so that the registry's error is reported.
+			if refreshed, refreshErr := v.CredentialsToken(
+				challenge, repo, authCreds,
+			); refreshErr == nil {
+				token = refreshed
+			}
+
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		})
+	case "basic":
+		return v.manifest(repo, ref, func(req *http.Request) {
+			if authCreds.Username != "" && authCreds.Password != "" {
+				req.SetBasicAuth(authCreds.Username, authCreds.Password)
+			}
+		})
+	default:
+		return nil, fmt.Errorf(
+			"unsupported auth scheme '%s' for '%s'", challenge.Scheme, repo,
+		)
+	}
+}
+
+// Challenge probes the registry's base endpoint and returns the parsed
+// WWW-Authenticate challenge. If the registry responds successfully without
+// one, it does not require authentication and the challenge is nil.
+func (v *V2) Challenge() (*Challenge, error) {
+	resp, err := v.Client.Get(fmt.Sprintf("%s/", v.Client.RegistryURL))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusOK &&
+		resp.StatusCode < http.StatusMultipleChoices {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf(
+			"unable to check if '%s' requires authentication, got status %d",
+			v.Client.RegistryURL, resp.StatusCode,
+		)
+	}
+
+	header := resp.Header.Get("WWW-Authenticate")
+	if header == "" {
+		return nil, fmt.Errorf(
+			"'%s' requires authentication but did not send a challenge",
+			v.Client.RegistryURL,
+		)
+	}
+
+	return ParseChallenge(header)
+}
+
+// ChallengeToken queries the realm from a bearer challenge for a token
+// that grants pull access to the repo. It is the same as CredentialsToken
+// with only a username and password.
+func (v *V2) ChallengeToken(
+	challenge *Challenge,
+	repo string,
+	username string,
+	password string,
+) (string, error) {
+	return v.CredentialsToken(
+		challenge, repo,
+		&AuthCredentials{Username: username, Password: password},
 	)
+}
 
-	resp, err := v.Client.Do(req)
+// manifest sends a HEAD request for the manifest, since registries such as
+// Docker Hub do not count HEAD requests against their rate limits. If the
+// registry does not send the digest in response to the HEAD request, the
+// manifest is requested with GET. Otherwise, the body is only requested,
+// by digest, if it is needed.
+func (v *V2) manifest(
+	repo string,
+	ref string,
+	authorize func(req *http.Request),
+) (*Manifest, error) {
+	resp, err := v.manifestRequest(http.MethodHead, repo, ref, authorize)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf(
+			"no manifest found for '%s:%s', got status %d",
+			repo, ref, resp.StatusCode,
+		)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+
+	if resp.StatusCode != http.StatusOK || digest == "" {
+		return v.getManifest(repo, ref, authorize)
+	}
+
+	if err = reference.ValidateDigest(digest); err != nil {
+		return nil, fmt.Errorf(
+			"registry sent an invalid digest for '%s:%s': %s", repo, ref, err,
+		)
+	}
+
+	return &Manifest{
+		Digest:    digest,
+		MediaType: manifestMediaTypeOf(resp.Header.Get("Content-Type"), nil),
+		getBody:   v.manifestBodyGetter(repo, digest, authorize),
+	}, nil
+}
+
+// manifestBodyGetter returns a function that requests the body of the
+// manifest by its digest, so that the body matches the digest even if the
+// tag has since been moved.
+func (v *V2) manifestBodyGetter(
+	repo string,
+	digest string,
+	authorize func(req *http.Request),
+) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		manifest, err := v.getManifest(repo, digest, authorize)
+		if err != nil {
+			return nil, err
+		}
+
+		return manifest.Body, nil
+	}
+}
+
+func (v *V2) getManifest(
+	repo string,
+	ref string,
+	authorize func(req *http.Request),
+) (*Manifest, error) {
+	resp, err := v.manifestRequest(http.MethodGet, repo, ref, authorize)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"no manifest found for '%s:%s', got status %d",
+			repo, ref, resp.StatusCode,
+		)
+	}
+
 	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest != "" {
+		if err = reference.ValidateDigest(digest); err != nil {
+			return nil, fmt.Errorf(
+				"registry sent an invalid digest for '%s:%s': %s",
+				repo, ref, err,
+			)
+		}
+	}
 
-	if digest == "" {
-		return "", fmt.Errorf("no digest found for '%s:%s'", repo, ref)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	return strings.TrimPrefix(digest, "sha256:"), nil
+	return &Manifest{
+		Digest:    digest,
+		MediaType: manifestMediaTypeOf(resp.Header.Get("Content-Type"), body),
+		Body:      body,
+	}, nil
+}
+
+// manifestRequest requests the manifest with the given method, accepting
+// all supported media types. An error is returned if the registry's rate
+// limit has been exceeded.
+func (v *V2) manifestRequest(
+	method string,
+	repo string,
+	ref string,
+	authorize func(req *http.Request),
+) (*http.Response, error) {
+	manifestURL := fmt.Sprintf(
+		"%s/%s/manifests/%s", v.Client.RegistryURL, repo, ref,
+	)
+
+	req, err := http.NewRequest(method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	authorize(req)
+
+	for _, mediaType := range []string{
+		manifestMediaType,
+		manifestListMediaType,
+		ociManifestMediaType,
+		ociIndexMediaType,
+	} {
+		req.Header.Add("Accept", mediaType)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = v.Client.checkRateLimit(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
 }
 
 // Token queries the container registry for a bearer token that is later
@@ -122,5 +389,9 @@ func (*DefaultTokenExtractor) FromBody(body io.ReadCloser) (string, error) {
 		return "", err
 	}
 
+	if t.Token == "" {
+		return t.AccessToken, nil
+	}
+
 	return t.Token, nil
 }
@@ -14,3 +14,26 @@ type Wrapper interface {
 	// 'dockerlocktestaccount.azurecr.io/'.
 	Prefix() string
 }
+
+// ManifestWrapper defines an interface that registry wrappers implement if
+// they can return the whole manifest for a repo and ref, which is required
+// to select platform specific digests from a manifest list.
+type ManifestWrapper interface {
+	Wrapper
+
+	// Manifest returns the manifest from a repo and ref. The repo and ref
+	// are the same as in Digest.
+	Manifest(repo string, ref string) (*Manifest, error)
+}
+
+// MatchingWrapper defines an interface that registry wrappers implement if
+// they serve many registries whose hosts follow a pattern rather than share
+// a prefix, such as ECR's <account>.dkr.ecr.<region>.amazonaws.com/. Such
+// wrappers may return an empty Prefix.
+type MatchingWrapper interface {
+	Wrapper
+
+	// Matches returns true if the wrapper should be used for the line, as in
+	// 123456789012.dkr.ecr.us-east-1.amazonaws.com/busybox:latest.
+	Matches(line string) bool
+}
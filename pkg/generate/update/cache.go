@@ -0,0 +1,203 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// cacheDirPerm is the permission of the directory that contains the cache.
+const cacheDirPerm = 0755
+
+// DigestCache stores the digests that ImageDigestUpdater found in a json
+// file, so that repeated runs do not have to query registries for images
+// whose digests were found less than TTL ago. If Refresh is true, cached
+// digests are ignored, but newly found digests are still stored.
+type DigestCache struct {
+	Path    string
+	TTL     time.Duration
+	Refresh bool
+	entries map[string]*digestCacheEntry
+	mutex   sync.Mutex
+}
+
+// digestCacheEntry is an image with its digest, along with when the digest
+// was found.
+type digestCacheEntry struct {
+	Image   *parse.Image `json:"image"`
+	Created time.Time    `json:"created"`
+}
+
+// NewDigestCache returns a DigestCache after reading the existing cache at
+// path, if there is one. A cache that cannot be read, for instance because
+// it was written by an incompatible version, is treated as empty.
+func NewDigestCache(
+	path string,
+	ttl time.Duration,
+	refresh bool,
+) (*DigestCache, error) {
+	if path == "" {
+		return nil, errors.New("path cannot be empty")
+	}
+
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+
+	entries := map[string]*digestCacheEntry{}
+
+	byt, err := ioutil.ReadFile(path)
+
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(byt, &entries); err != nil {
+			entries = map[string]*digestCacheEntry{}
+		}
+
+		for _, entry := range entries {
+			if entry.Image != nil {
+				entry.Image.MigrateDigests()
+			}
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	return &DigestCache{
+		Path:    path,
+		TTL:     ttl,
+		Refresh: refresh,
+		entries: entries,
+	}, nil
+}
+
+// Get returns a copy of the cached image for the key if it has not expired.
+func (d *DigestCache) Get(key string) (*parse.Image, bool) {
+	if d.Refresh {
+		return nil, false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entry, ok := d.entries[key]
+	if !ok || entry.Image == nil || time.Since(entry.Created) > d.TTL {
+		return nil, false
+	}
+
+	image := *entry.Image
+
+	return &image, true
+}
+
+// Set caches a copy of the image for the key.
+func (d *DigestCache) Set(key string, image *parse.Image) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	imageCopy := *image
+
+	d.entries[key] = &digestCacheEntry{Image: &imageCopy, Created: time.Now()}
+}
+
+// Save writes the unexpired entries to the cache's path, creating its
+// directory if necessary. The cache is written to a temporary file first,
+// so that concurrent runs do not read partially written caches.
+func (d *DigestCache) Save() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for key, entry := range d.entries {
+		if time.Since(entry.Created) > d.TTL {
+			delete(d.entries, key)
+		}
+	}
+
+	byt, err := json.MarshalIndent(d.entries, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(d.Path)
+
+	if err = os.MkdirAll(dir, cacheDirPerm); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(d.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(byt); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), d.Path)
+}
+
+// digestCacheKey identifies the registry, repo, and tag of an image, along
+// with the settings that affect which digests are found for it.
+func digestCacheKey(
+	image *parse.Image,
+	platforms []string,
+	strictDigests bool,
+) string {
+	key := fmt.Sprintf("%s:%s", reference.Familiar(image.Name), image.Tag)
+
+	if len(platforms) != 0 {
+		sortedPlatforms := make([]string, len(platforms))
+		copy(sortedPlatforms, platforms)
+		sort.Strings(sortedPlatforms)
+
+		key = fmt.Sprintf("%s|%s", key, strings.Join(sortedPlatforms, ","))
+	}
+
+	if strictDigests {
+		key = fmt.Sprintf("%s|strict", key)
+	}
+
+	return key
+}
+
+// cachedImage returns a copy of the image with the digests of the cached
+// image. Images with different platforms may share cache entries, so only
+// the digests are taken from the cached image.
+func cachedImage(image *parse.Image, cached *parse.Image) *parse.Image {
+	return &parse.Image{
+		Name:      image.Name,
+		Tag:       image.Tag,
+		Digest:    cached.Digest,
+		MediaType: cached.MediaType,
+		Source:    cached.Source,
+		Platform:  image.Platform,
+		Platforms: cached.Platforms,
+	}
+}
+
+// imagePlatforms returns the platforms whose digests should be found for
+// the image. Images that request their own platform only need that
+// platform.
+func imagePlatforms(image *parse.Image, platforms []string) []string {
+	if image.Platform != "" {
+		return []string{image.Platform}
+	}
+
+	return platforms
+}
@@ -3,17 +3,32 @@ package update_test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync/atomic"
 	"testing"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/update"
 )
 
-const busyboxLatestSHA = "bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+const busyboxLatestSHA = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+
+var busyboxLatestHex = strings.TrimPrefix( // nolint: gochecknoglobals
+	busyboxLatestSHA, "sha256:",
+)
+
+const appSHA512 = "sha512:f43f799324a27fbdf95f67fae0bc55b3358e7595a0497518abae0b3998a6261aeffce29af846a62741b1e17e04666d681d31fc43ca39383ae4450e59969e541e" // nolint: lll
+
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+)
 
 func assertImagesEqual(
 	t *testing.T,
@@ -42,16 +57,31 @@ func assertNumNetworkCallsEqual(t *testing.T, expected uint64, got uint64) {
 func mockServer(t *testing.T, numNetworkCalls *uint64) *httptest.Server {
 	t.Helper()
 
-	server := httptest.NewServer(
+	var server *httptest.Server
+
+	// like Docker Hub, the registry challenges clients to request a bearer
+	// token from its realm
+	server = httptest.NewServer(
 		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 			switch url := req.URL.String(); {
+			case url == "/":
+				res.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="registry.docker.io"`,
+					server.URL,
+				))
+				res.WriteHeader(http.StatusUnauthorized)
 			case strings.Contains(url, "scope"):
-				byt := []byte(`{"token": "NOT_USED"}`)
+				byt := []byte(`{"token": "token"}`)
 				_, err := res.Write(byt)
 				if err != nil {
 					t.Fatal(err)
 				}
 			case strings.Contains(url, "manifests"):
+				if req.Header.Get("Authorization") != "Bearer token" {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
 				atomic.AddUint64(numNetworkCalls, 1)
 
 				urlParts := strings.Split(url, "/")
@@ -75,6 +105,133 @@ func mockServer(t *testing.T, numNetworkCalls *uint64) *httptest.Server {
 	return server
 }
 
+func updateDigest(
+	updater update.IImageDigestUpdater,
+	image *parse.Image,
+) (*parse.Image, error) {
+	images := make(chan *parse.Image, 1)
+	images <- image
+	close(images)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	updatedImage := <-updater.UpdateDigests(images, done)
+
+	return updatedImage.Image, updatedImage.Err
+}
+
+// mockDockerServer mocks the Docker Engine API's image inspect endpoint.
+func mockDockerServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			var repoDigests []string
+
+			switch req.URL.Path {
+			case "/images/busybox:latest/json":
+				repoDigests = []string{
+					"busybox@" + busyboxLatestSHA,
+				}
+			case "/images/ghcr.io/org/app:latest/json":
+				repoDigests = []string{
+					"ghcr.io/org/other@sha256:other",
+					"ghcr.io/org/app@" + appSHA512,
+				}
+			case "/images/built:latest/json":
+				repoDigests = []string{}
+			default:
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			byt, err := json.Marshal(
+				map[string][]string{"RepoDigests": repoDigests},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = res.Write(byt); err != nil {
+				t.Fatal(err)
+			}
+		}))
+
+	return server
+}
+
+// makeOCILayout writes an OCI image layout to a temporary directory with
+// busybox:latest as an image index and app:v1 as an image manifest.
+// traversal:latest has a digest that points outside of the blobs directory.
+func makeOCILayout(t *testing.T) string {
+	t.Helper()
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobsDir := filepath.Join(tempDir, "blobs", "sha256")
+	if err = os.MkdirAll(blobsDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	ociLayout := `{"imageLayoutVersion": "1.0.0"}`
+
+	imageIndex := fmt.Sprintf(`{
+		"mediaType": "%s",
+		"manifests": [
+			{
+				"digest": "sha256:amd64",
+				"platform": {"os": "linux", "architecture": "amd64"}
+			}
+		]
+	}`, ociIndexMediaType)
+
+	layoutIndex := fmt.Sprintf(`{
+		"manifests": [
+			{
+				"mediaType": "%s",
+				"digest": "%s",
+				"annotations": {
+					"io.containerd.image.name":
+						"docker.io/library/busybox:latest"
+				}
+			},
+			{
+				"mediaType": "%s",
+				"digest": "sha256:../sha256/%s",
+				"annotations": {
+					"io.containerd.image.name":
+						"docker.io/library/traversal:latest"
+				}
+			},
+			{
+				"mediaType": "%s",
+				"digest": "sha256:app",
+				"annotations": {"org.opencontainers.image.ref.name": "v1"}
+			}
+		]
+	}`, ociIndexMediaType, busyboxLatestSHA, ociIndexMediaType,
+		busyboxLatestHex, ociManifestMediaType,
+	)
+
+	layoutFiles := map[string]string{
+		filepath.Join(tempDir, "oci-layout"):      ociLayout,
+		filepath.Join(tempDir, "index.json"):      layoutIndex,
+		filepath.Join(blobsDir, busyboxLatestHex): imageIndex,
+	}
+
+	for path, contents := range layoutFiles {
+		if err = ioutil.WriteFile(path, []byte(contents), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return tempDir
+}
+
 func jsonPrettyPrint(t *testing.T, i interface{}) string {
 	t.Helper()
 
@@ -0,0 +1,87 @@
+package update
+
+import (
+	"fmt"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// RequestLimiter bounds the number of images whose digests are queried from
+// registries at the same time, both in total and for each registry host,
+// such as docker.io or ghcr.io. A limit of 0 means unlimited.
+type RequestLimiter struct {
+	requests         chan struct{}
+	registryRequests map[string]chan struct{}
+}
+
+// NewRequestLimiter returns a RequestLimiter after validating the limits.
+// Hosts are normalized as in image names, so index.docker.io is docker.io.
+func NewRequestLimiter(
+	maxConcurrentRequests int,
+	registryMaxConcurrentRequests map[string]int,
+) (*RequestLimiter, error) {
+	if maxConcurrentRequests < 0 {
+		return nil, fmt.Errorf(
+			"max concurrent requests cannot be negative, got %d",
+			maxConcurrentRequests,
+		)
+	}
+
+	limiter := &RequestLimiter{
+		registryRequests: map[string]chan struct{}{},
+	}
+
+	if maxConcurrentRequests != 0 {
+		limiter.requests = make(chan struct{}, maxConcurrentRequests)
+	}
+
+	for host, maxRequests := range registryMaxConcurrentRequests {
+		if maxRequests < 0 {
+			return nil, fmt.Errorf(
+				"max concurrent requests for '%s' cannot be negative, got %d",
+				host, maxRequests,
+			)
+		}
+
+		normalizedHost := reference.NormalizeDomain(host)
+
+		if _, ok := limiter.registryRequests[normalizedHost]; ok {
+			return nil, fmt.Errorf(
+				"max concurrent requests for '%s' is set more than once",
+				normalizedHost,
+			)
+		}
+
+		if maxRequests != 0 {
+			limiter.registryRequests[normalizedHost] = make(
+				chan struct{}, maxRequests,
+			)
+		}
+	}
+
+	return limiter, nil
+}
+
+// Acquire blocks until a request to the registry host is allowed.
+func (r *RequestLimiter) Acquire(host string) {
+	// the registry's limit is acquired first so that requests waiting on a
+	// busy registry do not hold up requests to other registries
+	if registryRequests, ok := r.registryRequests[host]; ok {
+		registryRequests <- struct{}{}
+	}
+
+	if r.requests != nil {
+		r.requests <- struct{}{}
+	}
+}
+
+// Release allows another request to the registry host.
+func (r *RequestLimiter) Release(host string) {
+	if r.requests != nil {
+		<-r.requests
+	}
+
+	if registryRequests, ok := r.registryRequests[host]; ok {
+		<-registryRequests
+	}
+}
@@ -0,0 +1,157 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// LocalDigestSource is the Source of images whose digests were found in the
+// local image store.
+const LocalDigestSource = "local"
+
+// DefaultDockerHost is the address of the Docker Engine API if DOCKER_HOST
+// is not set.
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// LocalImageDigestUpdater updates Images with the digests of images in the
+// local image store, such as images that were pulled or loaded from
+// tarballs, by querying the Docker Engine API. Only images that have a
+// repo digest, meaning they came from a registry, can be updated.
+type LocalImageDigestUpdater struct {
+	Client  *http.Client
+	BaseURL string
+}
+
+// imageInspectResponse contains the fields of the Docker Engine API's
+// response to inspecting an image that are required to find its digest.
+type imageInspectResponse struct {
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// NewLocalImageDigestUpdater returns a LocalImageDigestUpdater that queries
+// the Docker Engine API at host, such as unix:///var/run/docker.sock or
+// tcp://localhost:2375.
+func NewLocalImageDigestUpdater(
+	host string,
+) (*LocalImageDigestUpdater, error) {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hostURL.Scheme {
+	case "unix":
+		socketPath := hostURL.Path
+
+		return &LocalImageDigestUpdater{
+			Client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(
+						ctx context.Context,
+						_ string,
+						_ string,
+					) (net.Conn, error) {
+						var dialer net.Dialer
+						return dialer.DialContext(ctx, "unix", socketPath)
+					},
+				},
+			},
+			BaseURL: "http://docker",
+		}, nil
+	case "tcp", "http":
+		return &LocalImageDigestUpdater{
+			Client:  &http.Client{},
+			BaseURL: fmt.Sprintf("http://%s", hostURL.Host),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host '%s'", host)
+	}
+}
+
+// UpdateDigests updates images that do not already specify their digests
+// with the digests from the local image store.
+func (l *LocalImageDigestUpdater) UpdateDigests(
+	images <-chan *parse.Image,
+	done <-chan struct{},
+) <-chan *UpdatedImage {
+	return updateDigests(images, done, l.updatedImage, nil)
+}
+
+func (l *LocalImageDigestUpdater) updatedImage(
+	image *parse.Image,
+) (*parse.Image, error) {
+	if reference.Familiar(image.Name) == "scratch" {
+		return image, nil
+	}
+
+	if image.Platform != "" {
+		return nil, fmt.Errorf(
+			"cannot select platform '%s' for '%s:%s' from the local image "+
+				"store", image.Platform, image.Name, image.Tag,
+		)
+	}
+
+	resp, err := l.Client.Get(
+		fmt.Sprintf("%s/images/%s:%s/json", l.BaseURL, image.Name, image.Tag),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"'%s:%s' not found in the local image store, got status %d",
+			image.Name, image.Tag, resp.StatusCode,
+		)
+	}
+
+	var inspect imageInspectResponse
+	if err = json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return nil, err
+	}
+
+	digest, err := repoDigest(image.Name, inspect.RepoDigests)
+	if err != nil {
+		return nil, fmt.Errorf("'%s:%s': %s", image.Name, image.Tag, err)
+	}
+
+	return &parse.Image{
+		Name:   image.Name,
+		Tag:    image.Tag,
+		Digest: digest,
+		Source: LocalDigestSource,
+	}, nil
+}
+
+// repoDigest selects the digest for the repo from repo digests such as
+// busybox@sha256:bae015c28bc7... Docker Hub repos match with or without
+// the docker.io and library prefixes.
+func repoDigest(name string, repoDigests []string) (string, error) {
+	for _, repoDigest := range repoDigests {
+		at := strings.LastIndex(repoDigest, "@")
+		if at == -1 {
+			continue
+		}
+
+		if reference.Normalize(repoDigest[:at]) == reference.Normalize(name) {
+			digest := repoDigest[at+1:]
+
+			return digest, reference.ValidateDigest(digest)
+		}
+	}
+
+	return "", errors.New(
+		"no repo digest in the local image store, the image must be " +
+			"pulled from or pushed to a registry",
+	)
+}
@@ -0,0 +1,240 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+	"github.com/safe-waters/docker-lock/pkg/generate/registry"
+)
+
+// OCILayoutDigestSource is the Source of images whose digests were found in
+// an OCI image layout directory.
+const OCILayoutDigestSource = "oci-layout"
+
+// OCILayoutImageDigestUpdater updates Images with the digests of the images
+// in an OCI image layout directory, as written by buildkit's OCI exporter or
+// skopeo, as described in
+// https://github.com/opencontainers/image-spec/blob/master/image-layout.md
+//
+// Images are found by the org.opencontainers.image.ref.name annotation,
+// which may be a tag or a full reference, or by containerd's
+// io.containerd.image.name annotation.
+type OCILayoutImageDigestUpdater struct {
+	Dir       string
+	Platforms []string
+	index     *ociIndex
+}
+
+// ociIndex represents the index.json of an OCI image layout.
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociDescriptor represents a descriptor in an OCI image layout's index.json.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// NewOCILayoutImageDigestUpdater returns an OCILayoutImageDigestUpdater
+// after reading the index of the OCI image layout in dir.
+func NewOCILayoutImageDigestUpdater(
+	dir string,
+	platforms []string,
+) (*OCILayoutImageDigestUpdater, error) {
+	if dir == "" {
+		return nil, errors.New("dir cannot be empty")
+	}
+
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "oci-layout")); err != nil {
+		return nil, fmt.Errorf("'%s' is not an OCI image layout: %s", dir, err)
+	}
+
+	indexByt, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ociIndex
+	if err = json.Unmarshal(indexByt, &index); err != nil {
+		return nil, err
+	}
+
+	return &OCILayoutImageDigestUpdater{
+		Dir:       dir,
+		Platforms: platforms,
+		index:     &index,
+	}, nil
+}
+
+// UpdateDigests updates images that do not already specify their digests
+// with the digests from the OCI image layout.
+//
+// All images are read before any are updated, so that a descriptor whose
+// ref.name annotation is only a tag is not assigned to several repos that
+// share the tag.
+func (o *OCILayoutImageDigestUpdater) UpdateDigests(
+	images <-chan *parse.Image,
+	done <-chan struct{},
+) <-chan *UpdatedImage {
+	if images == nil {
+		return nil
+	}
+
+	bufferedImages := make(chan *parse.Image)
+	reposByTag := map[string]map[string]struct{}{}
+
+	go func() {
+		defer close(bufferedImages)
+
+		var allImages []*parse.Image
+
+		for image := range images {
+			allImages = append(allImages, image)
+
+			if _, ok := reposByTag[image.Tag]; !ok {
+				reposByTag[image.Tag] = map[string]struct{}{}
+			}
+
+			reposByTag[image.Tag][reference.Normalize(image.Name)] = struct{}{}
+		}
+
+		for _, image := range allImages {
+			select {
+			case <-done:
+				return
+			case bufferedImages <- image:
+			}
+		}
+	}()
+
+	return updateDigests(
+		bufferedImages, done, func(image *parse.Image) (*parse.Image, error) {
+			return o.updatedImage(image, len(reposByTag[image.Tag]))
+		}, nil,
+	)
+}
+
+// updatedImage updates the image with its digest from the OCI image layout.
+// numRepos is the number of repos being updated that share the image's tag.
+func (o *OCILayoutImageDigestUpdater) updatedImage(
+	image *parse.Image,
+	numRepos int,
+) (*parse.Image, error) {
+	if reference.Familiar(image.Name) == "scratch" {
+		return image, nil
+	}
+
+	descriptor, err := o.descriptor(image, numRepos)
+	if err != nil {
+		return nil, err
+	}
+
+	if descriptor == nil {
+		return nil, fmt.Errorf(
+			"'%s:%s' not found in the OCI image layout '%s'",
+			image.Name, image.Tag, o.Dir,
+		)
+	}
+
+	manifest := &registry.Manifest{
+		Digest:    descriptor.Digest,
+		MediaType: descriptor.MediaType,
+	}
+
+	updatedImage := &parse.Image{
+		Name:      image.Name,
+		Tag:       image.Tag,
+		Digest:    manifest.Digest,
+		MediaType: manifest.MediaType,
+		Platform:  image.Platform,
+		Source:    OCILayoutDigestSource,
+	}
+
+	platforms := imagePlatforms(image, o.Platforms)
+	if len(platforms) == 0 || !manifest.IsList() {
+		return updatedImage, nil
+	}
+
+	body, err := o.blob(descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Body = body
+
+	updatedImage.Platforms, err = manifest.PlatformDigests(platforms)
+	if err != nil {
+		return nil, fmt.Errorf("'%s:%s': %s", image.Name, image.Tag, err)
+	}
+
+	return updatedImage, nil
+}
+
+// descriptor returns the descriptor in the index whose annotations match
+// the image, or nil if there is none.
+//
+// The spec allows the ref.name annotation to only be a tag, which does not
+// identify a repo. Such a descriptor is only used if it is the only one with
+// the tag and the image is the only repo with the tag being updated.
+func (o *OCILayoutImageDigestUpdater) descriptor(
+	image *parse.Image,
+	numRepos int,
+) (*ociDescriptor, error) {
+	ref := fmt.Sprintf("%s:%s", reference.Normalize(image.Name), image.Tag)
+
+	var tagDescriptors []*ociDescriptor
+
+	for i := range o.index.Manifests {
+		descriptor := &o.index.Manifests[i]
+
+		for _, name := range []string{
+			descriptor.Annotations["io.containerd.image.name"],
+			descriptor.Annotations["org.opencontainers.image.ref.name"],
+		} {
+			if name != "" &&
+				reference.Normalize(name) == ref {
+				return descriptor, nil
+			}
+		}
+
+		if descriptor.Annotations["io.containerd.image.name"] == "" &&
+			descriptor.Annotations["org.opencontainers.image.ref.name"] ==
+				image.Tag {
+			tagDescriptors = append(tagDescriptors, descriptor)
+		}
+	}
+
+	switch {
+	case len(tagDescriptors) == 0:
+		return nil, nil
+	case len(tagDescriptors) > 1 || numRepos > 1:
+		return nil, fmt.Errorf(
+			"cannot choose a digest for '%s:%s' since the tag is ambiguous "+
+				"in the OCI image layout '%s', please annotate the image "+
+				"with its full reference",
+			image.Name, image.Tag, o.Dir,
+		)
+	default:
+		return tagDescriptors[0], nil
+	}
+}
+
+// blob reads the blob with the digest, such as sha256:bae015c28bc7...
+func (o *OCILayoutImageDigestUpdater) blob(digest string) ([]byte, error) {
+	// digests come from the layout's index, so they are validated before
+	// becoming paths that could otherwise escape the blobs directory
+	if err := reference.ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+
+	algorithm, encoded := reference.SplitDigest(digest)
+
+	return ioutil.ReadFile(filepath.Join(o.Dir, "blobs", algorithm, encoded))
+}
@@ -0,0 +1,201 @@
+package update
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// OfflineImageDigestUpdater updates Images with digests that were found
+// before, from the images in an existing Lockfile or from a DigestCache,
+// without querying registries. Platforms and StrictDigests select cached
+// digests in the same way as in ImageDigestUpdater.
+type OfflineImageDigestUpdater struct {
+	Cache         *DigestCache
+	Platforms     []string
+	StrictDigests bool
+	knownImages   map[offlineImageKey]*parse.Image
+}
+
+// offlineImageKey identifies known images.
+type offlineImageKey struct {
+	name     string
+	tag      string
+	platform string
+}
+
+// NewOfflineImageDigestUpdater returns an OfflineImageDigestUpdater that
+// knows the digests of knownImages, such as the images from an existing
+// Lockfile. Images without digests are ignored. cache may be nil.
+func NewOfflineImageDigestUpdater(
+	knownImages []*parse.Image,
+	cache *DigestCache,
+	platforms []string,
+	strictDigests bool,
+) *OfflineImageDigestUpdater {
+	knownImagesByKey := map[offlineImageKey]*parse.Image{}
+
+	for _, image := range knownImages {
+		if image == nil || image.Digest == "" {
+			continue
+		}
+
+		key := newOfflineImageKey(image)
+
+		if _, ok := knownImagesByKey[key]; !ok {
+			knownImagesByKey[key] = image
+		}
+	}
+
+	return &OfflineImageDigestUpdater{
+		Cache:         cache,
+		Platforms:     platforms,
+		StrictDigests: strictDigests,
+		knownImages:   knownImagesByKey,
+	}
+}
+
+// UpdateDigests updates images that do not already specify their digests
+// with known digests. Images whose digests are not known are sent once
+// all images have been read, each with an error that lists all of them.
+func (o *OfflineImageDigestUpdater) UpdateDigests(
+	images <-chan *parse.Image,
+	done <-chan struct{},
+) <-chan *UpdatedImage {
+	if images == nil {
+		return nil
+	}
+
+	updatedImages := make(chan *UpdatedImage)
+
+	var waitGroup sync.WaitGroup
+
+	waitGroup.Add(1)
+
+	go func() {
+		defer waitGroup.Done()
+
+		var unresolvedImages []*parse.Image
+
+		for image := range images {
+			updatedImage := image
+
+			if image.Digest == "" {
+				var ok bool
+
+				if updatedImage, ok = o.updatedImage(image); !ok {
+					unresolvedImages = append(unresolvedImages, image)
+					continue
+				}
+			}
+
+			select {
+			case <-done:
+				return
+			case updatedImages <- &UpdatedImage{Image: updatedImage}:
+			}
+		}
+
+		if len(unresolvedImages) == 0 {
+			return
+		}
+
+		err := unresolvedImagesError(unresolvedImages)
+
+		for _, image := range unresolvedImages {
+			select {
+			case <-done:
+				return
+			case updatedImages <- &UpdatedImage{Image: image, Err: err}:
+			}
+		}
+	}()
+
+	go func() {
+		waitGroup.Wait()
+		close(updatedImages)
+	}()
+
+	return updatedImages
+}
+
+// updatedImage returns a copy of the image with its known digest, looking
+// in the cache first, since it may be more recent.
+func (o *OfflineImageDigestUpdater) updatedImage(
+	image *parse.Image,
+) (*parse.Image, bool) {
+	platforms := imagePlatforms(image, o.Platforms)
+
+	if o.Cache != nil {
+		key := digestCacheKey(image, platforms, o.StrictDigests)
+
+		if cached, ok := o.Cache.Get(key); ok {
+			return cachedImage(image, cached), true
+		}
+	}
+
+	knownImage, ok := o.knownImages[newOfflineImageKey(image)]
+	if !ok {
+		return nil, false
+	}
+
+	updatedImage := &parse.Image{
+		Name:      image.Name,
+		Tag:       image.Tag,
+		Digest:    knownImage.Digest,
+		MediaType: knownImage.MediaType,
+		Source:    knownImage.Source,
+		Platform:  image.Platform,
+	}
+
+	// known images that are manifest lists must have the digests of all
+	// platforms, whereas other images do not have platform specific digests
+	if knownImage.Platforms == nil || len(platforms) == 0 {
+		return updatedImage, true
+	}
+
+	updatedImage.Platforms = map[string]string{}
+
+	for _, platform := range platforms {
+		digest, found := knownImage.Platforms[platform]
+		if !found {
+			return nil, false
+		}
+
+		updatedImage.Platforms[platform] = digest
+	}
+
+	return updatedImage, true
+}
+
+func newOfflineImageKey(image *parse.Image) offlineImageKey {
+	return offlineImageKey{
+		name:     reference.Normalize(image.Name),
+		tag:      image.Tag,
+		platform: image.Platform,
+	}
+}
+
+func unresolvedImagesError(images []*parse.Image) error {
+	imageLines := make([]string, 0, len(images))
+
+	for _, image := range images {
+		imageLine := fmt.Sprintf("%s:%s", image.Name, image.Tag)
+		if image.Platform != "" {
+			imageLine = fmt.Sprintf("%s (%s)", imageLine, image.Platform)
+		}
+
+		imageLines = append(imageLines, imageLine)
+	}
+
+	sort.Strings(imageLines)
+
+	return fmt.Errorf(
+		"unable to resolve digests offline, since they are not in the "+
+			"Lockfile or digest cache: %s", strings.Join(imageLines, ", "),
+	)
+}
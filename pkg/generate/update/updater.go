@@ -3,6 +3,8 @@ package update
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"sync"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
@@ -10,9 +12,24 @@ import (
 )
 
 // ImageDigestUpdater uses a WrapperManager to update Images with their most
-// recent digests from their registries.
+// recent digests, and the media types of their manifests, from their
+// registries. If Platforms are set, the digests of the platform specific
+// manifests in manifest lists are recorded as well. Images that request
+// their own platform only record that platform.
+//
+// If StrictDigests is true, digests are computed by hashing the manifests
+// instead of trusting the registries' Docker-Content-Digest headers, which
+// are only used to ensure that the registries agree.
+//
+// If Cache is not nil, digests are read from and stored in the cache
+// instead of querying registries on every run. If Limiter is not nil, it
+// bounds how many registries are queried at the same time.
 type ImageDigestUpdater struct {
 	WrapperManager *registry.WrapperManager
+	Platforms      []string
+	StrictDigests  bool
+	Cache          *DigestCache
+	Limiter        *RequestLimiter
 }
 
 // IImageDigestUpdater provides an interface for ImageDigestUpdater's
@@ -34,12 +51,28 @@ type UpdatedImage struct {
 // fields.
 func NewImageDigestUpdater(
 	wrapperManager *registry.WrapperManager,
+	platforms []string,
+	strictDigests bool,
+	cache *DigestCache,
+	limiter *RequestLimiter,
 ) (*ImageDigestUpdater, error) {
 	if wrapperManager == nil {
 		return nil, errors.New("wrapperManager cannot be nil")
 	}
 
-	return &ImageDigestUpdater{WrapperManager: wrapperManager}, nil
+	for _, platform := range platforms {
+		if _, err := registry.ParsePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ImageDigestUpdater{
+		WrapperManager: wrapperManager,
+		Platforms:      platforms,
+		StrictDigests:  strictDigests,
+		Cache:          cache,
+		Limiter:        limiter,
+	}, nil
 }
 
 // UpdateDigests queries registries for digests of images that do not
@@ -48,6 +81,152 @@ func NewImageDigestUpdater(
 func (i *ImageDigestUpdater) UpdateDigests(
 	images <-chan *parse.Image,
 	done <-chan struct{},
+) <-chan *UpdatedImage {
+	return updateDigests(images, done, i.cachedUpdatedImage, func() {
+		if i.Cache != nil {
+			if err := i.Cache.Save(); err != nil {
+				log.Printf("unable to save digest cache: %s", err)
+			}
+		}
+	})
+}
+
+// cachedUpdatedImage returns the updated image from the cache, if it is
+// there. Otherwise, the updated image is found with updatedImage and cached.
+func (i *ImageDigestUpdater) cachedUpdatedImage(
+	image *parse.Image,
+) (*parse.Image, error) {
+	if i.Cache == nil {
+		return i.limitedUpdatedImage(image)
+	}
+
+	key := digestCacheKey(image, i.platforms(image), i.StrictDigests)
+
+	if cached, ok := i.Cache.Get(key); ok {
+		return cachedImage(image, cached), nil
+	}
+
+	updatedImage, err := i.limitedUpdatedImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	i.Cache.Set(key, updatedImage)
+
+	return updatedImage, nil
+}
+
+// limitedUpdatedImage waits for the Limiter, if there is one, before
+// querying the image's registry with updatedImage.
+func (i *ImageDigestUpdater) limitedUpdatedImage(
+	image *parse.Image,
+) (*parse.Image, error) {
+	if i.Limiter != nil {
+		host := registry.Host(image.Name)
+
+		i.Limiter.Acquire(host)
+		defer i.Limiter.Release(host)
+	}
+
+	return i.updatedImage(image)
+}
+
+// platforms returns the platforms whose digests should be found for the
+// image.
+func (i *ImageDigestUpdater) platforms(image *parse.Image) []string {
+	return imagePlatforms(image, i.Platforms)
+}
+
+// updatedImage returns a copy of the image with the digest and media type
+// of its manifest. If the wrapper cannot return manifests, only the digest
+// is set.
+func (i *ImageDigestUpdater) updatedImage(
+	image *parse.Image,
+) (*parse.Image, error) {
+	wrapper := i.WrapperManager.Wrapper(image.Name)
+	platforms := i.platforms(image)
+
+	updatedImage := &parse.Image{
+		Name:     image.Name,
+		Tag:      image.Tag,
+		Platform: image.Platform,
+	}
+
+	manifestWrapper, ok := wrapper.(registry.ManifestWrapper)
+	if !ok {
+		if len(platforms) != 0 || i.StrictDigests {
+			return nil, fmt.Errorf(
+				"cannot select platforms or verify digests for '%s:%s', "+
+					"its registry wrapper does not support manifests",
+				image.Name, image.Tag,
+			)
+		}
+
+		digest, err := wrapper.Digest(image.Name, image.Tag)
+		if err != nil {
+			return nil, err
+		}
+
+		updatedImage.Digest = digest
+
+		return updatedImage, nil
+	}
+
+	manifest, err := manifestWrapper.Manifest(image.Name, image.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := i.manifestDigest(image, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	platformDigests, err := manifest.PlatformDigests(platforms)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"'%s:%s': %s", image.Name, image.Tag, err,
+		)
+	}
+
+	updatedImage.Digest = digest
+	updatedImage.MediaType = manifest.MediaType
+	updatedImage.Platforms = platformDigests
+
+	return updatedImage, nil
+}
+
+// manifestDigest returns the digest of the manifest, either as sent by the
+// registry or, if StrictDigests is true, as computed from its body.
+func (i *ImageDigestUpdater) manifestDigest(
+	image *parse.Image,
+	manifest *registry.Manifest,
+) (string, error) {
+	// scratch has neither a manifest nor a digest
+	if len(manifest.Body) == 0 && manifest.Digest == "" {
+		return "", nil
+	}
+
+	if !i.StrictDigests {
+		return manifest.HeaderDigest(image.Name, image.Tag)
+	}
+
+	digest, err := manifest.VerifyDigest()
+	if err != nil {
+		return "", fmt.Errorf("'%s:%s': %s", image.Name, image.Tag, err)
+	}
+
+	return digest, nil
+}
+
+// updateDigests concurrently updates images that do not already specify
+// their digests with updateImage. Once all images have been updated, finish
+// is called, if it is not nil, before the returned channel is closed.
+func updateDigests(
+	images <-chan *parse.Image,
+	done <-chan struct{},
+	updateImage func(image *parse.Image) (*parse.Image, error),
+	finish func(),
 ) <-chan *UpdatedImage {
 	if images == nil {
 		return nil
@@ -79,9 +258,7 @@ func (i *ImageDigestUpdater) UpdateDigests(
 					return
 				}
 
-				wrapper := i.WrapperManager.Wrapper(image.Name)
-
-				digest, err := wrapper.Digest(image.Name, image.Tag)
+				updatedImage, err := updateImage(image)
 				if err != nil {
 					select {
 					case <-done:
@@ -93,14 +270,7 @@ func (i *ImageDigestUpdater) UpdateDigests(
 
 				select {
 				case <-done:
-					return
-				case updatedImages <- &UpdatedImage{
-					Image: &parse.Image{
-						Name:   image.Name,
-						Tag:    image.Tag,
-						Digest: digest,
-					},
-				}:
+				case updatedImages <- &UpdatedImage{Image: updatedImage}:
 				}
 			}()
 		}
@@ -108,6 +278,11 @@ func (i *ImageDigestUpdater) UpdateDigests(
 
 	go func() {
 		waitGroup.Wait()
+
+		if finish != nil {
+			finish()
+		}
+
 		close(updatedImages)
 	}()
 
@@ -1,7 +1,16 @@
 package update_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	cmd_generate "github.com/safe-waters/docker-lock/cmd/generate"
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
@@ -15,6 +24,8 @@ func TestImageDigestUpdater(t *testing.T) {
 	tests := []struct {
 		Name                    string
 		Images                  []*parse.Image
+		CachedImages            []*parse.Image
+		RefreshCache            bool
 		ExpectedNumNetworkCalls uint64
 		ExpectedImages          []*parse.Image
 	}{
@@ -53,6 +64,80 @@ func TestImageDigestUpdater(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "Cached Image",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			CachedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: "sha256:cached",
+				},
+			},
+			ExpectedNumNetworkCalls: 0,
+			ExpectedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: "sha256:cached",
+				},
+			},
+		},
+		{
+			Name: "Cached Image Other Platform",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			CachedImages: []*parse.Image{
+				{
+					Name:     "busybox",
+					Tag:      "latest",
+					Digest:   "sha256:cached",
+					Platform: "linux/arm64",
+				},
+			},
+			ExpectedNumNetworkCalls: 0,
+			ExpectedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: "sha256:cached",
+				},
+			},
+		},
+		{
+			Name: "Refresh Cache",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			CachedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: "sha256:cached",
+				},
+			},
+			RefreshCache:            true,
+			ExpectedNumNetworkCalls: 1,
+			ExpectedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: busyboxLatestSHA,
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -69,17 +154,51 @@ func TestImageDigestUpdater(t *testing.T) {
 			client := &registry.HTTPClient{
 				Client:      server.Client(),
 				RegistryURL: server.URL,
-				TokenURL:    server.URL + "?scope=repository%s",
 			}
 
 			wrapperManager, err := cmd_generate.DefaultWrapperManager(
-				client, cmd_generate.DefaultConfigPath(),
+				client, cmd_generate.DefaultConfigPath(), nil, nil, nil,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var cache *update.DigestCache
+
+			if test.CachedImages != nil {
+				var tempDir string
+
+				tempDir, err = ioutil.TempDir("", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(tempDir)
+
+				cache, err = update.NewDigestCache(
+					filepath.Join(tempDir, "digests.json"), time.Hour,
+					test.RefreshCache,
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, image := range test.CachedImages {
+					cache.Set(
+						fmt.Sprintf("%s:%s", image.Name, image.Tag), image,
+					)
+				}
+			}
+
+			limiter, err := update.NewRequestLimiter(
+				1, map[string]int{"docker.io": 1},
 			)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			updater, err := update.NewImageDigestUpdater(wrapperManager)
+			updater, err := update.NewImageDigestUpdater(
+				wrapperManager, nil, false, cache, limiter,
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -114,3 +233,547 @@ func TestImageDigestUpdater(t *testing.T) {
 		})
 	}
 }
+
+func TestOfflineImageDigestUpdater(t *testing.T) {
+	t.Parallel()
+
+	knownImages := []*parse.Image{
+		{
+			Name:   "busybox",
+			Tag:    "latest",
+			Digest: busyboxLatestSHA,
+			Platforms: map[string]string{
+				"linux/amd64": "sha256:amd64",
+			},
+		},
+	}
+
+	tests := []struct {
+		Name                   string
+		Images                 []*parse.Image
+		Platforms              []string
+		ExpectedImages         []*parse.Image
+		ExpectedUnresolvedTags []string
+	}{
+		{
+			Name: "Known Image",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			ExpectedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: busyboxLatestSHA,
+				},
+			},
+		},
+		{
+			Name: "Known Platform",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			Platforms: []string{"linux/amd64"},
+			ExpectedImages: []*parse.Image{
+				{
+					Name:      "busybox",
+					Tag:       "latest",
+					Digest:    busyboxLatestSHA,
+					Platforms: map[string]string{"linux/amd64": "sha256:amd64"},
+				},
+			},
+		},
+		{
+			Name: "Unknown Platform",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+			},
+			Platforms:              []string{"linux/arm64"},
+			ExpectedUnresolvedTags: []string{"busybox:latest"},
+		},
+		{
+			Name: "Unknown Images",
+			Images: []*parse.Image{
+				{
+					Name: "busybox",
+					Tag:  "latest",
+				},
+				{
+					Name: "ubuntu",
+					Tag:  "bionic",
+				},
+				{
+					Name: "golang",
+					Tag:  "latest",
+				},
+			},
+			ExpectedImages: []*parse.Image{
+				{
+					Name:   "busybox",
+					Tag:    "latest",
+					Digest: busyboxLatestSHA,
+				},
+			},
+			ExpectedUnresolvedTags: []string{"golang:latest", "ubuntu:bionic"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			updater := update.NewOfflineImageDigestUpdater(
+				knownImages, nil, test.Platforms, false,
+			)
+
+			done := make(chan struct{})
+
+			images := make(chan *parse.Image, len(test.Images))
+
+			for _, image := range test.Images {
+				images <- image
+			}
+			close(images)
+
+			updatedImages := updater.UpdateDigests(images, done)
+
+			var gotImages []*parse.Image
+
+			var gotErrs []error
+
+			for updatedImage := range updatedImages {
+				if updatedImage.Err != nil {
+					gotErrs = append(gotErrs, updatedImage.Err)
+					continue
+				}
+
+				gotImages = append(gotImages, updatedImage.Image)
+			}
+
+			assertImagesEqual(t, test.ExpectedImages, gotImages)
+
+			if len(gotErrs) != len(test.ExpectedUnresolvedTags) {
+				t.Fatalf(
+					"expected %d unresolved images, got %d",
+					len(test.ExpectedUnresolvedTags), len(gotErrs),
+				)
+			}
+
+			for _, err := range gotErrs {
+				expected := strings.Join(test.ExpectedUnresolvedTags, ", ")
+				if !strings.HasSuffix(err.Error(), expected) {
+					t.Fatalf("expected '%s' to list %s", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalImageDigestUpdater(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Image         *parse.Image
+		ExpectedImage *parse.Image
+		ShouldFail    bool
+	}{
+		{
+			Name:  "Docker Hub Image",
+			Image: &parse.Image{Name: "busybox", Tag: "latest"},
+			ExpectedImage: &parse.Image{
+				Name:   "busybox",
+				Tag:    "latest",
+				Digest: busyboxLatestSHA,
+				Source: update.LocalDigestSource,
+			},
+		},
+		{
+			Name:  "Multiple Repo Digests",
+			Image: &parse.Image{Name: "ghcr.io/org/app", Tag: "latest"},
+			ExpectedImage: &parse.Image{
+				Name:   "ghcr.io/org/app",
+				Tag:    "latest",
+				Digest: appSHA512,
+				Source: update.LocalDigestSource,
+			},
+		},
+		{
+			Name:       "No Repo Digests",
+			Image:      &parse.Image{Name: "built", Tag: "latest"},
+			ShouldFail: true,
+		},
+		{
+			Name:       "Missing Image",
+			Image:      &parse.Image{Name: "ubuntu", Tag: "bionic"},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			server := mockDockerServer(t)
+			defer server.Close()
+
+			updater, err := update.NewLocalImageDigestUpdater(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotImage, err := updateDigest(updater, test.Image)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertImagesEqual(
+				t, []*parse.Image{test.ExpectedImage},
+				[]*parse.Image{gotImage},
+			)
+		})
+	}
+}
+
+func TestOCILayoutImageDigestUpdater(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Image         *parse.Image
+		Platforms     []string
+		ExpectedImage *parse.Image
+		ShouldFail    bool
+	}{
+		{
+			Name:  "Image Name Annotation",
+			Image: &parse.Image{Name: "busybox", Tag: "latest"},
+			ExpectedImage: &parse.Image{
+				Name:      "busybox",
+				Tag:       "latest",
+				Digest:    busyboxLatestSHA,
+				MediaType: ociIndexMediaType,
+				Source:    update.OCILayoutDigestSource,
+			},
+		},
+		{
+			Name:      "Platforms",
+			Image:     &parse.Image{Name: "busybox", Tag: "latest"},
+			Platforms: []string{"linux/amd64"},
+			ExpectedImage: &parse.Image{
+				Name:      "busybox",
+				Tag:       "latest",
+				Digest:    busyboxLatestSHA,
+				MediaType: ociIndexMediaType,
+				Platforms: map[string]string{"linux/amd64": "sha256:amd64"},
+				Source:    update.OCILayoutDigestSource,
+			},
+		},
+		{
+			Name:  "Ref Name Annotation",
+			Image: &parse.Image{Name: "app", Tag: "v1"},
+			ExpectedImage: &parse.Image{
+				Name:      "app",
+				Tag:       "v1",
+				Digest:    "sha256:app",
+				MediaType: ociManifestMediaType,
+				Source:    update.OCILayoutDigestSource,
+			},
+		},
+		{
+			Name:       "Missing Platform",
+			Image:      &parse.Image{Name: "busybox", Tag: "latest"},
+			Platforms:  []string{"linux/arm64"},
+			ShouldFail: true,
+		},
+		{
+			Name:       "Missing Image",
+			Image:      &parse.Image{Name: "ubuntu", Tag: "bionic"},
+			ShouldFail: true,
+		},
+		{
+			Name:       "Blob Outside Layout",
+			Image:      &parse.Image{Name: "traversal", Tag: "latest"},
+			Platforms:  []string{"linux/amd64"},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := makeOCILayout(t)
+			defer os.RemoveAll(tempDir)
+
+			updater, err := update.NewOCILayoutImageDigestUpdater(
+				tempDir, test.Platforms,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotImage, err := updateDigest(updater, test.Image)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertImagesEqual(
+				t, []*parse.Image{test.ExpectedImage},
+				[]*parse.Image{gotImage},
+			)
+		})
+	}
+}
+
+func TestOCILayoutImageDigestUpdaterSharedTag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name           string
+		Images         []*parse.Image
+		ExpectedImages []*parse.Image
+		ShouldFail     bool
+	}{
+		{
+			Name: "Different Tags",
+			Images: []*parse.Image{
+				{Name: "app", Tag: "v1"},
+				{Name: "busybox", Tag: "latest"},
+			},
+			ExpectedImages: []*parse.Image{
+				{
+					Name:      "app",
+					Tag:       "v1",
+					Digest:    "sha256:app",
+					MediaType: ociManifestMediaType,
+					Source:    update.OCILayoutDigestSource,
+				},
+				{
+					Name:      "busybox",
+					Tag:       "latest",
+					Digest:    busyboxLatestSHA,
+					MediaType: ociIndexMediaType,
+					Source:    update.OCILayoutDigestSource,
+				},
+			},
+		},
+		{
+			Name: "Shared Tag",
+			Images: []*parse.Image{
+				{Name: "app", Tag: "v1"},
+				{Name: "other", Tag: "v1"},
+			},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := makeOCILayout(t)
+			defer os.RemoveAll(tempDir)
+
+			updater, err := update.NewOCILayoutImageDigestUpdater(tempDir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			images := make(chan *parse.Image, len(test.Images))
+			for _, image := range test.Images {
+				images <- image
+			}
+			close(images)
+
+			done := make(chan struct{})
+			defer close(done)
+
+			var gotImages []*parse.Image
+
+			for updatedImage := range updater.UpdateDigests(images, done) {
+				if updatedImage.Err != nil {
+					err = updatedImage.Err
+					continue
+				}
+
+				gotImages = append(gotImages, updatedImage.Image)
+			}
+
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Slice(gotImages, func(i, j int) bool {
+				return gotImages[i].Name < gotImages[j].Name
+			})
+
+			assertImagesEqual(t, test.ExpectedImages, gotImages)
+		})
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name                          string
+		MaxConcurrentRequests         int
+		RegistryMaxConcurrentRequests map[string]int
+		Hosts                         []string
+		ExpectedMaxConcurrentRequests int32
+		ShouldFail                    bool
+	}{
+		{
+			Name:                          "Max Concurrent Requests",
+			MaxConcurrentRequests:         2,
+			Hosts:                         []string{"docker.io", "ghcr.io"},
+			ExpectedMaxConcurrentRequests: 2,
+		},
+		{
+			Name: "Registry Max Concurrent Requests",
+			RegistryMaxConcurrentRequests: map[string]int{
+				"docker.io": 1,
+			},
+			Hosts:                         []string{"docker.io"},
+			ExpectedMaxConcurrentRequests: 1,
+		},
+		{
+			Name: "Registry Max Concurrent Requests Normalized Host",
+			RegistryMaxConcurrentRequests: map[string]int{
+				"index.docker.io": 1,
+			},
+			Hosts:                         []string{"docker.io"},
+			ExpectedMaxConcurrentRequests: 1,
+		},
+		{
+			Name: "Duplicate Registry Max Concurrent Requests",
+			RegistryMaxConcurrentRequests: map[string]int{
+				"docker.io":       1,
+				"index.docker.io": 2,
+			},
+			ShouldFail: true,
+		},
+		{
+			Name:                  "Negative Max Concurrent Requests",
+			MaxConcurrentRequests: -1,
+			ShouldFail:            true,
+		},
+		{
+			Name: "Negative Registry Max Concurrent Requests",
+			RegistryMaxConcurrentRequests: map[string]int{
+				"docker.io": -1,
+			},
+			ShouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter, err := update.NewRequestLimiter(
+				test.MaxConcurrentRequests, test.RegistryMaxConcurrentRequests,
+			)
+			if test.ShouldFail {
+				if err == nil {
+					t.Fatal("expected error but did not get one")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const numRequestsPerHost = 10
+
+			var concurrentRequests, gotMaxConcurrentRequests int32
+
+			var waitGroup sync.WaitGroup
+
+			for _, host := range test.Hosts {
+				for i := 0; i < numRequestsPerHost; i++ {
+					host := host
+
+					waitGroup.Add(1)
+
+					go func() {
+						defer waitGroup.Done()
+
+						limiter.Acquire(host)
+						defer limiter.Release(host)
+
+						n := atomic.AddInt32(&concurrentRequests, 1)
+						defer atomic.AddInt32(&concurrentRequests, -1)
+
+						for {
+							max := atomic.LoadInt32(&gotMaxConcurrentRequests)
+							if n <= max || atomic.CompareAndSwapInt32(
+								&gotMaxConcurrentRequests, max, n,
+							) {
+								break
+							}
+						}
+
+						time.Sleep(time.Millisecond)
+					}()
+				}
+			}
+
+			waitGroup.Wait()
+
+			if gotMaxConcurrentRequests > test.ExpectedMaxConcurrentRequests {
+				t.Fatalf(
+					"expected at most %d concurrent requests, got %d",
+					test.ExpectedMaxConcurrentRequests,
+					gotMaxConcurrentRequests,
+				)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
 	"github.com/safe-waters/docker-lock/pkg/generate/update"
 )
 
@@ -15,6 +16,13 @@ type ImageDigestUpdater struct {
 	IgnoreMissingDigests bool
 }
 
+// imageKey identifies images that share the same digest.
+type imageKey struct {
+	name     string
+	tag      string
+	platform string
+}
+
 // IImageDigestUpdater provides an interface for ImageDigestUpdater's exported
 // methods, which are used by Generator.
 type IImageDigestUpdater interface {
@@ -59,7 +67,7 @@ func (i *ImageDigestUpdater) UpdateDigests(
 		defer waitGroup.Done()
 
 		imagesWithoutDigests := make(chan *parse.Image)
-		digestsToUpdate := map[parse.Image][]*AnyImage{}
+		digestsToUpdate := map[imageKey][]*AnyImage{}
 
 		var imagesWithoutDigestsWaitGroup sync.WaitGroup
 
@@ -78,77 +86,32 @@ func (i *ImageDigestUpdater) UpdateDigests(
 					return
 				}
 
-				switch {
-				case anyImage.DockerfileImage != nil:
-					if anyImage.DockerfileImage.Image.Digest != "" {
-						select {
-						case <-done:
-							return
-						case updatedAnyImages <- anyImage:
-						}
-
-						continue
-					}
-
-					if _, ok := digestsToUpdate[*anyImage.DockerfileImage.Image]; !ok { // nolint: lll
-						select {
-						case <-done:
-							return
-						case imagesWithoutDigests <- anyImage.DockerfileImage.Image: // nolint: lll
-						}
-					}
+				image := anyImage.image()
+				if image == nil {
+					continue
+				}
 
-					digestsToUpdate[*anyImage.DockerfileImage.Image] = append(
-						digestsToUpdate[*anyImage.DockerfileImage.Image],
-						anyImage,
-					)
-				case anyImage.ComposefileImage != nil:
-					if anyImage.ComposefileImage.Image.Digest != "" {
-						select {
-						case <-done:
-							return
-						case updatedAnyImages <- anyImage:
-						}
-
-						continue
+				if image.Digest != "" {
+					select {
+					case <-done:
+						return
+					case updatedAnyImages <- anyImage:
 					}
 
-					if _, ok := digestsToUpdate[*anyImage.ComposefileImage.Image]; !ok { // nolint: lll
-						select {
-						case <-done:
-							return
-						case imagesWithoutDigests <- anyImage.ComposefileImage.Image: // nolint: lll
-						}
-					}
+					continue
+				}
 
-					digestsToUpdate[*anyImage.ComposefileImage.Image] = append(
-						digestsToUpdate[*anyImage.ComposefileImage.Image],
-						anyImage,
-					)
-				case anyImage.KubernetesfileImage != nil:
-					if anyImage.KubernetesfileImage.Image.Digest != "" {
-						select {
-						case <-done:
-							return
-						case updatedAnyImages <- anyImage:
-						}
-
-						continue
-					}
+				key := newImageKey(image)
 
-					if _, ok := digestsToUpdate[*anyImage.KubernetesfileImage.Image]; !ok { // nolint: lll
-						select {
-						case <-done:
-							return
-						case imagesWithoutDigests <- anyImage.KubernetesfileImage.Image: // nolint: lll
-						}
+				if _, ok := digestsToUpdate[key]; !ok {
+					select {
+					case <-done:
+						return
+					case imagesWithoutDigests <- image:
 					}
-
-					digestsToUpdate[*anyImage.KubernetesfileImage.Image] = append( // nolint: lll
-						digestsToUpdate[*anyImage.KubernetesfileImage.Image],
-						anyImage,
-					)
 				}
+
+				digestsToUpdate[key] = append(digestsToUpdate[key], anyImage)
 			}
 		}()
 
@@ -177,17 +140,14 @@ func (i *ImageDigestUpdater) UpdateDigests(
 		}
 
 		for _, updatedImage := range allUpdatedImages {
-			key := parse.Image{Name: updatedImage.Name, Tag: updatedImage.Tag}
+			key := newImageKey(updatedImage)
 
 			for _, anyImage := range digestsToUpdate[key] {
-				switch {
-				case anyImage.DockerfileImage != nil:
-					anyImage.DockerfileImage.Digest = updatedImage.Digest
-				case anyImage.ComposefileImage != nil:
-					anyImage.ComposefileImage.Digest = updatedImage.Digest
-				case anyImage.KubernetesfileImage != nil:
-					anyImage.KubernetesfileImage.Digest = updatedImage.Digest
-				}
+				image := anyImage.image()
+				image.Digest = updatedImage.Digest
+				image.MediaType = updatedImage.MediaType
+				image.Source = updatedImage.Source
+				image.Platforms = updatedImage.Platforms
 
 				select {
 				case <-done:
@@ -205,3 +165,26 @@ func (i *ImageDigestUpdater) UpdateDigests(
 
 	return updatedAnyImages
 }
+
+func newImageKey(image *parse.Image) imageKey {
+	return imageKey{
+		name:     reference.Normalize(image.Name),
+		tag:      image.Tag,
+		platform: image.Platform,
+	}
+}
+
+// image returns the Image embedded in whichever kind of image is set, or nil
+// if none are.
+func (a *AnyImage) image() *parse.Image {
+	switch {
+	case a.DockerfileImage != nil:
+		return a.DockerfileImage.Image
+	case a.ComposefileImage != nil:
+		return a.ComposefileImage.Image
+	case a.KubernetesfileImage != nil:
+		return a.KubernetesfileImage.Image
+	}
+
+	return nil
+}
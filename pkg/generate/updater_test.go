@@ -208,6 +208,56 @@ func TestImageDigestUpdater(t *testing.T) {
 			},
 			ExpectedNumNetworkCalls: 3,
 		},
+		{
+			Name: "Normalized Names",
+			AnyImages: []*generate.AnyImage{
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image: &parse.Image{
+							Name: "busybox",
+							Tag:  "latest",
+						},
+						Position: 0,
+						Path:     "Dockerfile",
+					},
+				},
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image: &parse.Image{
+							Name: "docker.io/library/busybox",
+							Tag:  "latest",
+						},
+						Position: 1,
+						Path:     "Dockerfile",
+					},
+				},
+			},
+			Expected: []*generate.AnyImage{
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: busyboxLatestSHA,
+						},
+						Position: 0,
+						Path:     "Dockerfile",
+					},
+				},
+				{
+					DockerfileImage: &parse.DockerfileImage{
+						Image: &parse.Image{
+							Name:   "docker.io/library/busybox",
+							Tag:    "latest",
+							Digest: busyboxLatestSHA,
+						},
+						Position: 1,
+						Path:     "Dockerfile",
+					},
+				},
+			},
+			ExpectedNumNetworkCalls: 1,
+		},
 	}
 
 	for _, test := range tests {
@@ -224,17 +274,18 @@ func TestImageDigestUpdater(t *testing.T) {
 			client := &registry.HTTPClient{
 				Client:      server.Client(),
 				RegistryURL: server.URL,
-				TokenURL:    server.URL + "?scope=repository%s",
 			}
 
 			wrapperManager, err := cmd_generate.DefaultWrapperManager(
-				client, cmd_generate.DefaultConfigPath(),
+				client, cmd_generate.DefaultConfigPath(), nil, nil, nil,
 			)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			innerUpdater, err := update.NewImageDigestUpdater(wrapperManager)
+			innerUpdater, err := update.NewImageDigestUpdater(
+				wrapperManager, nil, false, nil, nil,
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
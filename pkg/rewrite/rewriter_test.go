@@ -20,6 +20,7 @@ func TestRewriter(t *testing.T) {
 	tests := []struct {
 		Name       string
 		Contents   [][]byte
+		Variant    string
 		Expected   [][]byte
 		ShouldFail bool
 	}{
@@ -380,6 +381,74 @@ services:
 		]
 	}
 }
+`,
+				),
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Variant",
+			Contents: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:${PY_VERSION}
+`,
+				),
+				[]byte(`
+{
+	"dockerfiles": {
+		"Dockerfile": [
+			{
+				"name": "python",
+				"tag": "3.8",
+				"digest": "python38",
+				"variant": "py3.8"
+			},
+			{
+				"name": "python",
+				"tag": "3.9",
+				"digest": "python39",
+				"variant": "py3.9"
+			}
+		]
+	}
+}
+`,
+				),
+			},
+			Variant: "py3.9",
+			Expected: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:3.9@sha256:python39
+`,
+				),
+			},
+		},
+		{
+			Name: "Several Variants Without Variant",
+			Contents: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:${PY_VERSION}
+`,
+				),
+				[]byte(`
+{
+	"dockerfiles": {
+		"Dockerfile": [
+			{
+				"name": "python",
+				"tag": "3.8",
+				"digest": "python38",
+				"variant": "py3.8"
+			},
+			{
+				"name": "python",
+				"tag": "3.9",
+				"digest": "python39",
+				"variant": "py3.9"
+			}
+		]
+	}
+}
 `,
 				),
 			},
@@ -451,7 +520,9 @@ services:
 				t, tempDir, pathsToWrite, test.Contents[:len(test.Contents)-1],
 			)
 
-			flags, err := cmd_rewrite.NewFlags("", tempDir, false)
+			flags, err := cmd_rewrite.NewFlags(
+				"", tempDir, false, "", test.Variant,
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
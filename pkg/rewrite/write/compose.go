@@ -16,9 +16,12 @@ import (
 )
 
 // ComposefileWriter contains information for writing new Composefiles.
+// If Platform is set, images are pinned to their platform specific digests
+// rather than the digests of their manifest lists.
 type ComposefileWriter struct {
 	DockerfileWriter *DockerfileWriter
 	ExcludeTags      bool
+	Platform         string
 	Directory        string
 }
 
@@ -292,9 +295,14 @@ func (c *ComposefileWriter) filterComposefileServices(
 				)
 			}
 
-			serviceImageLines[image.ServiceName] = convertImageToImageLine(
-				image.Image, c.ExcludeTags,
+			imageLine, err := convertImageToImageLine(
+				image.Image, c.ExcludeTags, c.Platform,
 			)
+			if err != nil {
+				return nil, err
+			}
+
+			serviceImageLines[image.ServiceName] = imageLine
 		}
 
 		uniqueServices[image.ServiceName] = struct{}{}
@@ -363,8 +371,10 @@ func (c *ComposefileWriter) filterDockerfilePathImages(
 						serviceDockerfileImages[image.ServiceName] = append(
 							serviceDockerfileImages[image.ServiceName],
 							&parse.DockerfileImage{
-								Image: image.Image,
-								Path:  dockerfilePath,
+								Image:       image.Image,
+								Instruction: image.Instruction,
+								Arg:         image.Arg,
+								Path:        dockerfilePath,
 							},
 						)
 					}
@@ -377,8 +387,10 @@ func (c *ComposefileWriter) filterDockerfilePathImages(
 						dockerfilePathImages[image.Path] = append(
 							dockerfilePathImages[image.Path],
 							&parse.DockerfileImage{
-								Image: image.Image,
-								Path:  image.Path,
+								Image:       image.Image,
+								Instruction: image.Instruction,
+								Arg:         image.Arg,
+								Path:        image.Path,
 							},
 						)
 					}
@@ -41,7 +41,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc",
@@ -71,7 +71,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc",
 					},
@@ -141,7 +141,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc",
 					},
@@ -181,7 +181,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -189,7 +189,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -233,7 +233,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -264,7 +264,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -272,7 +272,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-unknown",
 					},
@@ -280,7 +280,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -313,7 +313,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -321,7 +321,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-another-docker",
@@ -330,7 +330,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -371,7 +371,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -379,7 +379,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -390,7 +390,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -398,7 +398,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -431,7 +431,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -439,7 +439,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-another-docker",
@@ -448,7 +448,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -504,7 +504,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -512,7 +512,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -523,7 +523,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -531,7 +531,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile",
 						ServiceName:    "svc-docker",
@@ -599,7 +599,7 @@ services:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -607,7 +607,7 @@ services:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						DockerfilePath: "Dockerfile-1",
 						ServiceName:    "svc-docker",
@@ -618,7 +618,7 @@ services:
 						Image: &parse.Image{
 							Name:   "node",
 							Tag:    "latest",
-							Digest: "node",
+							Digest: "sha256:node",
 						},
 						ServiceName: "svc-compose",
 					},
@@ -626,7 +626,7 @@ services:
 						Image: &parse.Image{
 							Name:   "python",
 							Tag:    "latest",
-							Digest: "python",
+							Digest: "sha256:python",
 						},
 						DockerfilePath: "Dockerfile-2",
 						ServiceName:    "svc-another-docker",
@@ -5,7 +5,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -14,8 +15,14 @@ import (
 )
 
 // DockerfileWriter contains information for writing new Dockerfiles.
+// If Platform is set, images are pinned to their platform specific digests
+// rather than the digests of their manifest lists. Dockerfiles that were
+// locked once for each of several build arg variants are pinned to the
+// images of Variant.
 type DockerfileWriter struct {
 	ExcludeTags bool
+	Platform    string
+	Variant     string
 	Directory   string
 }
 
@@ -98,32 +105,69 @@ func (d *DockerfileWriter) writeFile(
 	path string,
 	images []*parse.DockerfileImage,
 ) (string, error) {
-	f, err := os.Open(path)
+	images, err := d.variantImages(path, images)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
 
-	loadedDockerfile, err := parser.Parse(f)
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	loadedDockerfile, err := parser.Parse(bytes.NewReader(byt))
 	if err != nil {
 		return "", err
 	}
 
 	stageNames := map[string]bool{}
 
-	var imageIndex int
+	var imageIndex, numStages int
+
+	// the syntax directive is a comment of the first instruction
+	syntaxImageLine := parse.SyntaxImageLine(byt)
+
+	var syntaxReplacementImageLine string
+
+	if syntaxImageLine != "" {
+		if err = checkImage(
+			path, images, imageIndex, parse.SyntaxInstruction,
+		); err != nil {
+			return "", err
+		}
+
+		syntaxReplacementImageLine, err = convertImageToImageLine(
+			images[imageIndex].Image, d.ExcludeTags, d.Platform,
+		)
+		if err != nil {
+			return "", err
+		}
+
+		imageIndex++
+	}
 
 	const maxNumFields = 3
 
-	var (
-		outputBuffer bytes.Buffer
-		lastEndLine  int
-	)
+	children := loadedDockerfile.AST.Children
+	outputLines := make([]string, len(children))
 
-	for _, child := range loadedDockerfile.AST.Children {
+	// images in FROM instructions that are a single ARG, as in
+	// FROM ${BASE_IMAGE}, are pinned in the ARG's default value instead
+	argChildIndices := map[string]int{} // ARG before first FROM -> child
+	argReplacements := map[string]string{}
+	globalContext := true
+
+	for i, child := range children {
 		outputLine := child.Original
 
-		if child.Value == "from" {
+		switch child.Value {
+		case "arg":
+			if globalContext {
+				for n := child.Next; n != nil; n = n.Next {
+					argChildIndices[argName(n.Value)] = i
+				}
+			}
+		case "from":
 			var raw []string
 			for n := child.Next; n != nil; n = n.Next {
 				raw = append(raw, n.Value)
@@ -135,19 +179,37 @@ func (d *DockerfileWriter) writeFile(
 				)
 			}
 
+			globalContext = false
+			numStages++
+
+			var isArg bool
+
 			if !stageNames[raw[0]] {
-				if imageIndex >= len(images) {
-					return "", fmt.Errorf(
-						"more images exist in '%s' than in the Lockfile",
-						path,
-					)
+				if err := checkImage(path, images, imageIndex, ""); err != nil {
+					return "", err
 				}
 
-				replacementImageLine := convertImageToImageLine(
-					images[imageIndex].Image, d.ExcludeTags,
+				replacementImageLine, err := convertImageToImageLine(
+					images[imageIndex].Image, d.ExcludeTags, d.Platform,
 				)
+				if err != nil {
+					return "", err
+				}
+
+				if arg := images[imageIndex].Arg; arg != "" {
+					if err := checkArg(
+						path, raw[0], arg, replacementImageLine,
+						argChildIndices, argReplacements,
+					); err != nil {
+						return "", err
+					}
+
+					argReplacements[arg] = replacementImageLine
+					isArg = true
+				} else {
+					raw[0] = replacementImageLine
+				}
 
-				raw[0] = replacementImageLine
 				imageIndex++
 			}
 			// Ensure stage is added to the stage name set:
@@ -161,9 +223,92 @@ func (d *DockerfileWriter) writeFile(
 				stageNames[raw[stageIndex]] = true
 			}
 
-			outputLine = d.formatASTLine(child, raw)
+			if !isArg {
+				outputLine = d.formatASTLine(child, raw)
+			}
+		case parse.CopyInstruction, parse.RunInstruction,
+			parse.OnbuildInstruction:
+			var (
+				cursor int
+				values []string
+			)
+
+			if child.Value == parse.OnbuildInstruction {
+				values = parse.OnbuildImageFlagValues(child)
+			} else {
+				for _, value := range parse.ImageFlagValues(
+					child.Value, child.Flags,
+				) {
+					if !isStage(value, stageNames, numStages) {
+						values = append(values, value)
+					}
+				}
+			}
+
+			for _, value := range values {
+				if err := checkImage(
+					path, images, imageIndex, child.Value,
+				); err != nil {
+					return "", err
+				}
+
+				replacementImageLine, err := convertImageToImageLine(
+					images[imageIndex].Image, d.ExcludeTags, d.Platform,
+				)
+				if err != nil {
+					return "", err
+				}
+
+				// values with variables, as in COPY --from=${IMAGE}, are
+				// left as they are written so that the variables still
+				// apply, which is done by replacing them with themselves
+				if strings.Contains(value, "$") {
+					replacementImageLine = value
+				}
+
+				outputLine, cursor, err = replaceFlagValue(
+					outputLine, cursor, value, replacementImageLine,
+				)
+				if err != nil {
+					return "", fmt.Errorf("in '%s', %s", path, err)
+				}
+
+				imageIndex++
+			}
+		}
+
+		outputLines[i] = outputLine
+	}
+
+	for arg := range argReplacements {
+		i := argChildIndices[arg]
+
+		var raw []string
+
+		for n := children[i].Next; n != nil; n = n.Next {
+			name := argName(n.Value)
+
+			replacementImageLine, ok := argReplacements[name]
+			if ok && argChildIndices[name] == i {
+				raw = append(
+					raw, fmt.Sprintf("%s=%s", name, replacementImageLine),
+				)
+			} else {
+				raw = append(raw, n.Value)
+			}
 		}
 
+		outputLines[i] = d.formatASTLine(children[i], raw)
+	}
+
+	var (
+		outputBuffer bytes.Buffer
+		lastEndLine  int
+	)
+
+	for i, child := range children {
+		outputLine := outputLines[i]
+
 		expectedLineNo := lastEndLine + len(child.PrevComment) + 1
 		if expectedLineNo != child.StartLine {
 			newlines := strings.Repeat("\n", child.StartLine-expectedLineNo)
@@ -173,12 +318,28 @@ func (d *DockerfileWriter) writeFile(
 		lastEndLine = child.EndLine
 
 		for _, comment := range child.PrevComment {
+			if syntaxImageLine != "" &&
+				parse.SyntaxImageLine(
+					[]byte(fmt.Sprintf("# %s", comment)),
+				) == syntaxImageLine {
+				comment = strings.Replace(
+					comment, syntaxImageLine, syntaxReplacementImageLine, 1,
+				)
+				syntaxImageLine = ""
+			}
+
 			fmt.Fprintf(&outputBuffer, "# %s\n", comment)
 		}
 
 		outputBuffer.WriteString(fmt.Sprintf("%s\n", outputLine))
 	}
 
+	if syntaxImageLine != "" {
+		return "", fmt.Errorf(
+			"cannot rewrite the syntax directive in '%s'", path,
+		)
+	}
+
 	if imageIndex < len(images) {
 		return "", fmt.Errorf(
 			"fewer images exist in '%s' than asked to rewrite", path,
@@ -201,6 +362,48 @@ func (d *DockerfileWriter) writeFile(
 	return writtenFile.Name(), err
 }
 
+// variantImages returns the images of the writer's variant, since a
+// Dockerfile can only be pinned to the images of a single variant. If the
+// Dockerfile was not locked with several variants, all of its images are
+// returned.
+func (d *DockerfileWriter) variantImages(
+	path string,
+	images []*parse.DockerfileImage,
+) ([]*parse.DockerfileImage, error) {
+	variants := map[string]bool{}
+
+	var selectedImages []*parse.DockerfileImage
+
+	for _, image := range images {
+		variants[image.Variant] = true
+
+		if image.Variant == d.Variant {
+			selectedImages = append(selectedImages, image)
+		}
+	}
+
+	if len(variants) <= 1 {
+		return images, nil
+	}
+
+	if len(selectedImages) == 0 {
+		variantNames := make([]string, 0, len(variants))
+		for variant := range variants {
+			variantNames = append(variantNames, fmt.Sprintf("'%s'", variant))
+		}
+
+		sort.Strings(variantNames)
+
+		return nil, fmt.Errorf(
+			"cannot rewrite '%s' without choosing one of its build arg "+
+				"variants %s, as with --variant, but got '%s'",
+			path, strings.Join(variantNames, ", "), d.Variant,
+		)
+	}
+
+	return selectedImages, nil
+}
+
 func (d *DockerfileWriter) formatASTLine(
 	child *parser.Node, raw []string,
 ) string {
@@ -214,16 +417,173 @@ func (d *DockerfileWriter) formatASTLine(
 	return strings.Join(line, " ")
 }
 
-func convertImageToImageLine(image *parse.Image, excludeTags bool) string {
+// checkImage returns an error if there is no image at imageIndex, or if the
+// image was not referenced by the instruction, since the Dockerfile no
+// longer matches the Lockfile.
+func checkImage(
+	path string,
+	images []*parse.DockerfileImage,
+	imageIndex int,
+	instruction string,
+) error {
+	if imageIndex >= len(images) {
+		return fmt.Errorf(
+			"more images exist in '%s' than in the Lockfile", path,
+		)
+	}
+
+	if images[imageIndex].Instruction != instruction {
+		return fmt.Errorf(
+			"image '%s' in '%s' is referenced by %s in the Lockfile, "+
+				"but by %s in the Dockerfile", images[imageIndex].Name, path,
+			instructionName(images[imageIndex].Instruction),
+			instructionName(instruction),
+		)
+	}
+
+	return nil
+}
+
+// checkArg returns an error if the image line of a FROM instruction is not
+// the ARG that the image comes from in the Lockfile, if the ARG is not
+// declared before the first FROM, or if another FROM instruction pins the
+// ARG to a different image.
+func checkArg(
+	path string,
+	imageLine string,
+	arg string,
+	replacementImageLine string,
+	argChildIndices map[string]int,
+	argReplacements map[string]string,
+) error {
+	if parse.ImageLineArg(imageLine) != arg {
+		return fmt.Errorf(
+			"image '%s' in '%s' comes from ARG %s in the Lockfile, but "+
+				"from '%s' in the Dockerfile", replacementImageLine, path,
+			arg, imageLine,
+		)
+	}
+
+	if _, ok := argChildIndices[arg]; !ok {
+		return fmt.Errorf(
+			"cannot find ARG %s before the first FROM in '%s'", arg, path,
+		)
+	}
+
+	if existingImageLine, ok := argReplacements[arg]; ok &&
+		existingImageLine != replacementImageLine {
+		return fmt.Errorf(
+			"ARG %s in '%s' is used by images '%s' and '%s'",
+			arg, path, existingImageLine, replacementImageLine,
+		)
+	}
+
+	return nil
+}
+
+// argName returns the name of an ARG from its declaration, as in BASE_IMAGE
+// from BASE_IMAGE="python:3.8".
+func argName(declaration string) string {
+	return strings.Trim(strings.SplitN(declaration, "=", 2)[0], `"'`)
+}
+
+// instructionName returns the name of an instruction as it is written in a
+// Dockerfile. Images in FROM instructions have no instruction.
+func instructionName(instruction string) string {
+	if instruction == "" {
+		return "FROM"
+	}
+
+	return strings.ToUpper(instruction)
+}
+
+// isStage returns true if a flag value refers to a build stage by name, or
+// by its index among the numStages stages declared so far, as in
+// COPY --from=0.
+func isStage(value string, stageNames map[string]bool, numStages int) bool {
+	if stageNames[value] {
+		return true
+	}
+
+	index, err := strconv.Atoi(value)
+
+	return err == nil && index >= 0 && index < numStages
+}
+
+// replaceFlagValue replaces the first flag value after cursor in an
+// instruction, as in golang:1.15 in COPY --from=golang:1.15, returning the
+// instruction and the position after the replacement. The rest of the
+// instruction, such as the command of RUN, is left as it is written.
+func replaceFlagValue(
+	line string,
+	cursor int,
+	value string,
+	replacement string,
+) (string, int, error) {
+	const fromKey = "from="
+
+	fromIndex := strings.Index(strings.ToLower(line[cursor:]), fromKey)
+	if fromIndex == -1 {
+		return "", 0, fmt.Errorf("cannot find '%s' in '%s'", value, line)
+	}
+
+	start := cursor + fromIndex + len(fromKey)
+
+	valueIndex := strings.Index(line[start:], value)
+	if valueIndex == -1 {
+		return "", 0, fmt.Errorf("cannot find '%s' in '%s'", value, line)
+	}
+
+	start += valueIndex
+	line = line[:start] + replacement + line[start+len(value):]
+
+	return line, start + len(replacement), nil
+}
+
+func convertImageToImageLine(
+	image *parse.Image,
+	excludeTags bool,
+	platform string,
+) (string, error) {
 	imageLine := image.Name
 
 	if image.Tag != "" && !excludeTags {
 		imageLine = fmt.Sprintf("%s:%s", imageLine, image.Tag)
 	}
 
-	if image.Digest != "" {
-		imageLine = fmt.Sprintf("%s@sha256:%s", imageLine, image.Digest)
+	digest, err := selectDigest(image, platform)
+	if err != nil {
+		return "", err
+	}
+
+	if digest != "" {
+		imageLine = fmt.Sprintf("%s@%s", imageLine, digest)
+	}
+
+	return imageLine, nil
+}
+
+// selectDigest returns the image's digest. If platform is set, the digest
+// of the platform specific manifest is returned instead, using the
+// image's own platform if it requested one. Images whose digests do not
+// refer to manifest lists have no platform specific digests, so their
+// digests are already specific to a platform.
+func selectDigest(image *parse.Image, platform string) (string, error) {
+	if platform == "" || len(image.Platforms) == 0 {
+		return image.Digest, nil
+	}
+
+	if image.Platform != "" {
+		platform = image.Platform
+	}
+
+	digest, ok := image.Platforms[platform]
+	if !ok {
+		return "", fmt.Errorf(
+			"no digest for platform '%s' exists for image '%s:%s'",
+			platform, image.Name, image.Tag,
+		)
 	}
 
-	return imageLine
+	return digest, nil
 }
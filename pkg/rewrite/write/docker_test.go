@@ -19,6 +19,7 @@ func TestDockerfileWriter(t *testing.T) {
 		Expected    [][]byte
 		PathImages  map[string][]*parse.DockerfileImage
 		ExcludeTags bool
+		Variant     string
 		ShouldFail  bool
 	}{
 		{
@@ -36,21 +37,21 @@ FROM golang:latest@sha256:12345
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 					},
 				},
@@ -60,6 +61,28 @@ FROM golang:latest@sha256:12345
 COPY . .
 FROM redis:latest@sha256:redis
 FROM golang:latest@sha256:golang
+`),
+			},
+		},
+		{
+			Name: "SHA512 Digest",
+			Contents: [][]byte{
+				[]byte(`FROM redis@sha256:redis
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "redis",
+							Tag:    "latest",
+							Digest: "sha512:redis",
+						},
+					},
+				},
+			},
+			Expected: [][]byte{
+				[]byte(`FROM redis:latest@sha512:redis
 `),
 			},
 		},
@@ -84,7 +107,7 @@ RUN touch foo
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 				},
@@ -142,21 +165,21 @@ FROM redis
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox-1",
+							Digest: "sha256:busybox-1",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis-1",
+							Digest: "sha256:redis-1",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang-1",
+							Digest: "sha256:golang-1",
 						},
 					},
 				},
@@ -165,21 +188,21 @@ FROM redis
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang-2",
+							Digest: "sha256:golang-2",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox-2",
+							Digest: "sha256:busybox-2",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis-2",
+							Digest: "sha256:redis-2",
 						},
 					},
 				},
@@ -210,21 +233,21 @@ FROM golang
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 					},
 				},
@@ -251,21 +274,21 @@ FROM golang
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 					},
 				},
@@ -293,14 +316,14 @@ FROM --platform=$BUILDPLATFORM base AS anotherbase
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 					},
 				},
@@ -313,6 +336,464 @@ FROM --platform=$BUILDPLATFORM base AS anotherbase
 `),
 			},
 		},
+		{
+			Name: "Copy From And Run Mount",
+			Contents: [][]byte{
+				[]byte(`FROM busybox AS base
+COPY --from=golang:1.15 /usr/local/go /usr/local/go
+COPY --from=base /bin /bin
+RUN --mount=type=bind,from=alpine,target=/src ["ls", "/src"]
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "1.15",
+							Digest: "sha256:golang",
+						},
+						Instruction: parse.CopyInstruction,
+					},
+					{
+						Image: &parse.Image{
+							Name:   "alpine",
+							Tag:    "latest",
+							Digest: "sha256:alpine",
+						},
+						Instruction: parse.RunInstruction,
+					},
+				},
+			},
+			Expected: [][]byte{
+				// nolint: lll
+				[]byte(`FROM busybox:latest@sha256:busybox AS base
+COPY --from=golang:1.15@sha256:golang /usr/local/go /usr/local/go
+COPY --from=base /bin /bin
+RUN --mount=type=bind,from=alpine:latest@sha256:alpine,target=/src ["ls", "/src"]
+`),
+			},
+		},
+		{
+			Name: "Copy From And Run Mount With Variables",
+			Contents: [][]byte{
+				[]byte(`ARG IMAGE=golang:1.15
+FROM busybox
+COPY --from=${IMAGE} /usr/local/go /usr/local/go
+RUN --mount=from=$IMAGE,target=/go --mount=from=alpine,target=/src ls
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "1.15",
+							Digest: "sha256:golang",
+						},
+						Instruction: parse.CopyInstruction,
+					},
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "1.15",
+							Digest: "sha256:golang",
+						},
+						Instruction: parse.RunInstruction,
+					},
+					{
+						Image: &parse.Image{
+							Name:   "alpine",
+							Tag:    "latest",
+							Digest: "sha256:alpine",
+						},
+						Instruction: parse.RunInstruction,
+					},
+				},
+			},
+			Expected: [][]byte{
+				// nolint: lll
+				[]byte(`ARG IMAGE=golang:1.15
+FROM busybox:latest@sha256:busybox
+COPY --from=${IMAGE} /usr/local/go /usr/local/go
+RUN --mount=from=$IMAGE,target=/go --mount=from=alpine:latest@sha256:alpine,target=/src ls
+`),
+			},
+		},
+		{
+			Name: "Platform And Onbuild",
+			Contents: [][]byte{
+				[]byte(`FROM --platform=$BUILDPLATFORM busybox
+ONBUILD COPY --from=golang:1.15 /usr/local/go /usr/local/go
+ONBUILD COPY --from=build /app /app
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "1.15",
+							Digest: "sha256:golang",
+						},
+						Instruction: parse.OnbuildInstruction,
+					},
+				},
+			},
+			Expected: [][]byte{
+				// nolint: lll
+				[]byte(`FROM --platform=$BUILDPLATFORM busybox:latest@sha256:busybox
+ONBUILD COPY --from=golang:1.15@sha256:golang /usr/local/go /usr/local/go
+ONBUILD COPY --from=build /app /app
+`),
+			},
+		},
+		{
+			Name: "Arg Default",
+			Contents: [][]byte{
+				[]byte(`ARG DEBIAN_VERSION=buster BASE_IMAGE="python:3.8"
+ARG BUILDER_IMAGE
+FROM ${BUILDER_IMAGE} AS build
+FROM $BASE_IMAGE
+ARG BASE_IMAGE=ubuntu
+FROM ${BASE_IMAGE}
+FROM debian:${DEBIAN_VERSION}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "1.15",
+							Digest: "sha256:golang",
+						},
+						Arg: "BUILDER_IMAGE",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "debian",
+							Tag:    "buster",
+							Digest: "sha256:debian",
+						},
+					},
+				},
+			},
+			Expected: [][]byte{
+				// nolint: lll
+				[]byte(`ARG DEBIAN_VERSION=buster BASE_IMAGE=python:3.8@sha256:python
+ARG BUILDER_IMAGE=golang:1.15@sha256:golang
+FROM ${BUILDER_IMAGE} AS build
+FROM $BASE_IMAGE
+ARG BASE_IMAGE=ubuntu
+FROM ${BASE_IMAGE}
+FROM debian:buster@sha256:debian
+`),
+			},
+		},
+		{
+			Name: "Arg Overridden By Build Arg",
+			Contents: [][]byte{
+				[]byte(`ARG BASE_IMAGE=python:3.8
+FROM ${BASE_IMAGE}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.9",
+							Digest: "sha256:python",
+						},
+					},
+				},
+			},
+			Expected: [][]byte{
+				[]byte(`ARG BASE_IMAGE=python:3.8
+FROM python:3.9@sha256:python
+`),
+			},
+		},
+		{
+			Name: "Arg Used By Different Images",
+			Contents: [][]byte{
+				[]byte(`ARG BASE_IMAGE=python:3.8
+FROM ${BASE_IMAGE}
+FROM ${BASE_IMAGE}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.9",
+							Digest: "sha256:python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Arg Not In Dockerfile",
+			Contents: [][]byte{
+				[]byte(`FROM python:3.8
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Variant",
+			Contents: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:${PY_VERSION}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.9",
+							Digest: "sha256:python",
+						},
+						Variant: "py3.9",
+					},
+				},
+			},
+			Expected: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:3.9@sha256:python
+`),
+			},
+		},
+		{
+			Name: "Several Variants",
+			Contents: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:${PY_VERSION}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python-3.8",
+						},
+						Variant: "py3.8",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.9",
+							Digest: "sha256:python-3.9",
+						},
+						Variant: "py3.9",
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Several Variants With Variant",
+			Contents: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:${PY_VERSION}
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "sha256:python-3.8",
+						},
+						Variant: "py3.8",
+					},
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.9",
+							Digest: "sha256:python-3.9",
+						},
+						Variant: "py3.9",
+					},
+				},
+			},
+			Variant: "py3.8",
+			Expected: [][]byte{
+				[]byte(`ARG PY_VERSION=3.8
+FROM python:3.8@sha256:python-3.8
+`),
+			},
+		},
+		{
+			Name: "Syntax Directive",
+			Contents: [][]byte{
+				[]byte(`# syntax = docker/dockerfile:1.2 --ignored
+# escape=\
+# my comment
+FROM busybox
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "docker/dockerfile",
+							Tag:    "1.2",
+							Digest: "sha256:dockerfile",
+						},
+						Instruction: parse.SyntaxInstruction,
+					},
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+				},
+			},
+			Expected: [][]byte{
+				// nolint: lll
+				[]byte(`# syntax = docker/dockerfile:1.2@sha256:dockerfile --ignored
+# escape=\
+# my comment
+FROM busybox:latest@sha256:busybox
+`),
+			},
+		},
+		{
+			Name: "Empty Syntax Directive",
+			Contents: [][]byte{
+				[]byte("# syntax= \nFROM busybox\n"),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+				},
+			},
+			Expected: [][]byte{
+				[]byte("# syntax=\nFROM busybox:latest@sha256:busybox\n"),
+			},
+		},
+		{
+			Name: "Syntax Directive Not In Lockfile",
+			Contents: [][]byte{
+				[]byte(`# syntax=docker/dockerfile:1.2
+FROM busybox
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Different Instruction",
+			Contents: [][]byte{
+				[]byte(`FROM busybox
+COPY --from=golang /usr/local/go /usr/local/go
+`),
+			},
+			PathImages: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "latest",
+							Digest: "sha256:busybox",
+						},
+					},
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "latest",
+							Digest: "sha256:golang",
+						},
+					},
+				},
+			},
+			ShouldFail: true,
+		},
 		{
 			Name: "Fewer Images In Dockerfile",
 			Contents: [][]byte{
@@ -324,14 +805,14 @@ FROM --platform=$BUILDPLATFORM base AS anotherbase
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 					},
 				},
@@ -351,7 +832,7 @@ FROM redis
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 				},
@@ -369,7 +850,7 @@ FROM redis
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 				},
@@ -387,7 +868,7 @@ FROM redis
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 				},
@@ -425,6 +906,7 @@ FROM redis
 			writer := &write.DockerfileWriter{
 				Directory:   tempDir,
 				ExcludeTags: test.ExcludeTags,
+				Variant:     test.Variant,
 			}
 			done := make(chan struct{})
 			writtenPathResults := writer.WriteFiles(
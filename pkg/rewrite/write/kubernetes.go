@@ -14,8 +14,11 @@ import (
 )
 
 // KubernetesfileWriter contains information for writing new Kubernetesfiles.
+// If Platform is set, images are pinned to their platform specific digests
+// rather than the digests of their manifest lists.
 type KubernetesfileWriter struct {
 	ExcludeTags bool
+	Platform    string
 	Directory   string
 }
 
@@ -180,9 +183,14 @@ func (k *KubernetesfileWriter) encodeDoc(
 				)
 			}
 
-			doc[imageLineIndex].Value = convertImageToImageLine(
-				images[*imagePosition].Image, k.ExcludeTags,
+			imageLine, err := convertImageToImageLine(
+				images[*imagePosition].Image, k.ExcludeTags, k.Platform,
 			)
+			if err != nil {
+				return err
+			}
+
+			doc[imageLineIndex].Value = imageLine
 
 			*imagePosition++
 		}
@@ -48,7 +48,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ContainerName: "busybox",
 					},
@@ -56,7 +56,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						ContainerName: "golang",
 					},
@@ -142,7 +142,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						ContainerName: "golang",
 					},
@@ -150,7 +150,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "python",
 							Tag:    "latest",
-							Digest: "python",
+							Digest: "sha256:python",
 						},
 						ContainerName: "python",
 					},
@@ -158,7 +158,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 						ContainerName: "redis",
 					},
@@ -166,7 +166,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "bash",
 							Tag:    "latest",
-							Digest: "bash",
+							Digest: "sha256:bash",
 						},
 						ContainerName: "bash",
 					},
@@ -335,7 +335,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 						ContainerName: "golang",
 					},
@@ -343,7 +343,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "python",
 							Tag:    "latest",
-							Digest: "python",
+							Digest: "sha256:python",
 						},
 						ContainerName: "python",
 					},
@@ -351,7 +351,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "redis",
 							Tag:    "latest",
-							Digest: "redis",
+							Digest: "sha256:redis",
 						},
 						ContainerName: "redis",
 					},
@@ -359,7 +359,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "bash",
 							Tag:    "latest",
-							Digest: "bash",
+							Digest: "sha256:bash",
 						},
 						ContainerName: "bash",
 					},
@@ -369,7 +369,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ContainerName: "busybox",
 					},
@@ -377,7 +377,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "java",
 							Tag:    "latest",
-							Digest: "java",
+							Digest: "sha256:java",
 						},
 						ContainerName: "java",
 					},
@@ -385,7 +385,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "alpine",
 							Tag:    "latest",
-							Digest: "alpine",
+							Digest: "sha256:alpine",
 						},
 						ContainerName: "alpine",
 					},
@@ -393,7 +393,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "ruby",
 							Tag:    "latest",
-							Digest: "ruby",
+							Digest: "sha256:ruby",
 						},
 						ContainerName: "ruby",
 					},
@@ -525,7 +525,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 						ContainerName: "busybox",
 					},
@@ -575,21 +575,21 @@ spec:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "golang",
 							Tag:    "latest",
-							Digest: "golang",
+							Digest: "sha256:golang",
 						},
 					},
 					{
 						Image: &parse.Image{
 							Name:   "extra",
 							Tag:    "latest",
-							Digest: "extra",
+							Digest: "sha256:extra",
 						},
 					},
 				},
@@ -623,7 +623,7 @@ spec:
 						Image: &parse.Image{
 							Name:   "busybox",
 							Tag:    "latest",
-							Digest: "busybox",
+							Digest: "sha256:busybox",
 						},
 					},
 				},
@@ -30,7 +30,7 @@ func TestWriter(t *testing.T) {
 							Image: &parse.Image{
 								Name:   "golang",
 								Tag:    "latest",
-								Digest: "golang",
+								Digest: "sha256:golang",
 							},
 						},
 					},
@@ -41,7 +41,7 @@ func TestWriter(t *testing.T) {
 							Image: &parse.Image{
 								Name:   "busybox",
 								Tag:    "latest",
-								Digest: "busybox",
+								Digest: "sha256:busybox",
 							},
 							ServiceName: "svc-compose",
 						},
@@ -53,7 +53,7 @@ func TestWriter(t *testing.T) {
 							Image: &parse.Image{
 								Name:   "redis",
 								Tag:    "latest",
-								Digest: "redis",
+								Digest: "sha256:redis",
 							},
 							ContainerName: "redis",
 						},
@@ -104,9 +104,12 @@ func (c *ComposefileDifferentiator) Differentiate(
 
 						existingImage := parse.ComposefileImage{
 							Image: &parse.Image{
-								Name:   existingImages[i].Name,
-								Tag:    existingImages[i].Tag,
-								Digest: existingImages[i].Digest,
+								Name:      existingImages[i].Name,
+								Tag:       existingImages[i].Tag,
+								Digest:    existingImages[i].Digest,
+								MediaType: existingImages[i].MediaType,
+								Platform:  existingImages[i].Platform,
+								Platforms: existingImages[i].Platforms,
 							},
 							DockerfilePath: existingImages[i].DockerfilePath,
 							ServiceName:    existingImages[i].ServiceName,
@@ -114,9 +117,12 @@ func (c *ComposefileDifferentiator) Differentiate(
 
 						newImage := parse.ComposefileImage{
 							Image: &parse.Image{
-								Name:   newImages[i].Name,
-								Tag:    newImages[i].Tag,
-								Digest: newImages[i].Digest,
+								Name:      newImages[i].Name,
+								Tag:       newImages[i].Tag,
+								Digest:    newImages[i].Digest,
+								MediaType: newImages[i].MediaType,
+								Platform:  newImages[i].Platform,
+								Platforms: newImages[i].Platforms,
 							},
 							DockerfilePath: newImages[i].DockerfilePath,
 							ServiceName:    newImages[i].ServiceName,
@@ -127,12 +133,44 @@ func (c *ComposefileDifferentiator) Differentiate(
 							newImage.Tag = ""
 						}
 
-						if *existingImage.Image != *newImage.Image {
+						// lockfiles generated before media types were
+						// recorded cannot be compared by media type
+						if existingImage.MediaType == "" {
+							newImage.MediaType = ""
+						}
+
+						if existingImages[i].Arg != newImages[i].Arg {
 							select {
-							case errCh <- fmt.Errorf(
-								"on path %s existing image %v differs "+
-									"from the new image %v",
-								path, *existingImage.Image, *newImage.Image,
+							case errCh <- argDiffError(
+								path, existingImages[i].Image,
+								existingImages[i].Arg, newImages[i].Arg,
+							):
+							case <-done:
+							}
+
+							return
+						}
+
+						if existingImages[i].Instruction !=
+							newImages[i].Instruction {
+							select {
+							case errCh <- instructionDiffError(
+								path, existingImages[i].Image,
+								existingImages[i].Instruction,
+								newImages[i].Instruction,
+							):
+							case <-done:
+							}
+
+							return
+						}
+
+						if !imagesEqual(
+							existingImage.Image, newImage.Image,
+						) {
+							select {
+							case errCh <- imageDiffError(
+								path, existingImage.Image, newImage.Image,
 							):
 							case <-done:
 							}
@@ -104,17 +104,23 @@ func (d *DockerfileDifferentiator) Differentiate(
 
 						existingImage := parse.DockerfileImage{
 							Image: &parse.Image{
-								Name:   existingImages[i].Name,
-								Tag:    existingImages[i].Tag,
-								Digest: existingImages[i].Digest,
+								Name:      existingImages[i].Name,
+								Tag:       existingImages[i].Tag,
+								Digest:    existingImages[i].Digest,
+								MediaType: existingImages[i].MediaType,
+								Platform:  existingImages[i].Platform,
+								Platforms: existingImages[i].Platforms,
 							},
 						}
 
 						newImage := parse.DockerfileImage{
 							Image: &parse.Image{
-								Name:   newImages[i].Name,
-								Tag:    newImages[i].Tag,
-								Digest: newImages[i].Digest,
+								Name:      newImages[i].Name,
+								Tag:       newImages[i].Tag,
+								Digest:    newImages[i].Digest,
+								MediaType: newImages[i].MediaType,
+								Platform:  newImages[i].Platform,
+								Platforms: newImages[i].Platforms,
 							},
 						}
 
@@ -123,12 +129,58 @@ func (d *DockerfileDifferentiator) Differentiate(
 							newImage.Tag = ""
 						}
 
-						if *existingImage.Image != *newImage.Image {
+						// lockfiles generated before media types were
+						// recorded cannot be compared by media type
+						if existingImage.MediaType == "" {
+							newImage.MediaType = ""
+						}
+
+						if existingImages[i].Variant !=
+							newImages[i].Variant {
+							select {
+							case errCh <- variantDiffError(
+								path, existingImages[i].Image,
+								existingImages[i].Variant,
+								newImages[i].Variant,
+							):
+							case <-done:
+							}
+
+							return
+						}
+
+						if existingImages[i].Arg != newImages[i].Arg {
+							select {
+							case errCh <- argDiffError(
+								path, existingImages[i].Image,
+								existingImages[i].Arg, newImages[i].Arg,
+							):
+							case <-done:
+							}
+
+							return
+						}
+
+						if existingImages[i].Instruction !=
+							newImages[i].Instruction {
+							select {
+							case errCh <- instructionDiffError(
+								path, existingImages[i].Image,
+								existingImages[i].Instruction,
+								newImages[i].Instruction,
+							):
+							case <-done:
+							}
+
+							return
+						}
+
+						if !imagesEqual(
+							existingImage.Image, newImage.Image,
+						) {
 							select {
-							case errCh <- fmt.Errorf(
-								"on path %s existing image %v differs "+
-									"from the new image %v",
-								path, *existingImage.Image, *newImage.Image,
+							case errCh <- imageDiffError(
+								path, existingImage.Image, newImage.Image,
 							):
 							case <-done:
 							}
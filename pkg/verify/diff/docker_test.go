@@ -104,6 +104,87 @@ func TestDockerfileDifferentiator(t *testing.T) {
 			},
 			ShouldFail: true,
 		},
+		{
+			Name: "Different Instructions",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "latest",
+							Digest: "golang",
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "golang",
+							Tag:    "latest",
+							Digest: "golang",
+						},
+						Instruction: parse.CopyInstruction,
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Different Args",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "python",
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "python",
+						},
+						Arg: "BASE_IMAGE",
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "Different Variants",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "python",
+						},
+						Variant: "py3.8",
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "python",
+							Tag:    "3.8",
+							Digest: "python",
+						},
+					},
+				},
+			},
+			ShouldFail: true,
+		},
 		{
 			Name: "Exclude Tags",
 			Existing: map[string][]*parse.DockerfileImage{
@@ -130,6 +211,111 @@ func TestDockerfileDifferentiator(t *testing.T) {
 			},
 			ExcludeTags: true,
 		},
+		{
+			Name: "Different Media Types",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:      "busybox",
+							Tag:       "busybox",
+							Digest:    "busybox",
+							MediaType: "application/vnd.oci.image.index.v1+json", // nolint: lll
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:      "busybox",
+							Tag:       "busybox",
+							Digest:    "busybox",
+							MediaType: "application/vnd.docker.distribution.manifest.list.v2+json", // nolint: lll
+						},
+					},
+				},
+			},
+			ShouldFail: true,
+		},
+		{
+			Name: "No Existing Media Type",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "busybox",
+							Digest: "busybox",
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:      "busybox",
+							Tag:       "busybox",
+							Digest:    "busybox",
+							MediaType: "application/vnd.oci.image.index.v1+json", // nolint: lll
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "Normalized Names",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "docker.io/library/busybox",
+							Tag:    "busybox",
+							Digest: "busybox",
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "busybox",
+							Digest: "busybox",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "Different Registries",
+			Existing: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "ghcr.io/busybox",
+							Tag:    "busybox",
+							Digest: "busybox",
+						},
+					},
+				},
+			},
+			New: map[string][]*parse.DockerfileImage{
+				"Dockerfile": {
+					{
+						Image: &parse.Image{
+							Name:   "busybox",
+							Tag:    "busybox",
+							Digest: "busybox",
+						},
+					},
+				},
+			},
+			ShouldFail: true,
+		},
 		{
 			Name: "Nil",
 		},
@@ -0,0 +1,103 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/safe-waters/docker-lock/pkg/generate/parse"
+	"github.com/safe-waters/docker-lock/pkg/generate/reference"
+)
+
+// imagesEqual returns true if the images are the same, treating names that
+// refer to the same image, such as busybox and docker.io/library/busybox,
+// as equal.
+func imagesEqual(existingImage *parse.Image, newImage *parse.Image) bool {
+	normalizedExistingImage := *existingImage
+	normalizedExistingImage.Name = reference.Normalize(existingImage.Name)
+
+	normalizedNewImage := *newImage
+	normalizedNewImage.Name = reference.Normalize(newImage.Name)
+
+	return reflect.DeepEqual(normalizedExistingImage, normalizedNewImage)
+}
+
+// imageDiffError describes how the new image differs from the existing
+// image. If the media types differ, the registry has converted the manifest
+// to another format, such as from an OCI image index to a Docker manifest
+// list, which also changes the digest.
+func imageDiffError(
+	path string,
+	existingImage *parse.Image,
+	newImage *parse.Image,
+) error {
+	if existingImage.MediaType != newImage.MediaType {
+		return fmt.Errorf(
+			"on path %s existing image %s has media type %s, but the "+
+				"registry now returns media type %s",
+			path, existingImage.Name, existingImage.MediaType,
+			newImage.MediaType,
+		)
+	}
+
+	return fmt.Errorf(
+		"on path %s existing image %v differs from the new image %v",
+		path, *existingImage, *newImage,
+	)
+}
+
+// instructionDiffError describes an image that is referenced by a different
+// instruction, such as COPY --from rather than FROM. Images in FROM
+// instructions have no instruction.
+func instructionDiffError(
+	path string,
+	existingImage *parse.Image,
+	existingInstruction string,
+	newInstruction string,
+) error {
+	instructionName := func(instruction string) string {
+		if instruction == "" {
+			return "FROM"
+		}
+
+		return strings.ToUpper(instruction)
+	}
+
+	return fmt.Errorf(
+		"on path %s existing image %s is referenced by %s, but the new "+
+			"image is referenced by %s", path, existingImage.Name,
+		instructionName(existingInstruction), instructionName(newInstruction),
+	)
+}
+
+// variantDiffError describes an image that was parsed with a different build
+// arg variant, such as when variants are added to or removed from
+// .docker-lock.yml.
+func variantDiffError(
+	path string,
+	existingImage *parse.Image,
+	existingVariant string,
+	newVariant string,
+) error {
+	return fmt.Errorf(
+		"on path %s existing image %s has build arg variant '%s', but the "+
+			"new image has variant '%s'", path, existingImage.Name,
+		existingVariant, newVariant,
+	)
+}
+
+// argDiffError describes an image whose value comes from a different ARG,
+// as in FROM ${BASE_IMAGE}. Images that do not come from an ARG have no
+// ARG.
+func argDiffError(
+	path string,
+	existingImage *parse.Image,
+	existingArg string,
+	newArg string,
+) error {
+	return fmt.Errorf(
+		"on path %s existing image %s comes from ARG '%s', but the new "+
+			"image comes from ARG '%s'", path, existingImage.Name,
+		existingArg, newArg,
+	)
+}
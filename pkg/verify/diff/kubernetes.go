@@ -105,18 +105,24 @@ func (k *KubernetesfileDifferentiator) Differentiate(
 
 						existingImage := parse.KubernetesfileImage{
 							Image: &parse.Image{
-								Name:   existingImages[i].Name,
-								Tag:    existingImages[i].Tag,
-								Digest: existingImages[i].Digest,
+								Name:      existingImages[i].Name,
+								Tag:       existingImages[i].Tag,
+								Digest:    existingImages[i].Digest,
+								MediaType: existingImages[i].MediaType,
+								Platform:  existingImages[i].Platform,
+								Platforms: existingImages[i].Platforms,
 							},
 							ContainerName: existingImages[i].ContainerName,
 						}
 
 						newImage := parse.KubernetesfileImage{
 							Image: &parse.Image{
-								Name:   newImages[i].Name,
-								Tag:    newImages[i].Tag,
-								Digest: newImages[i].Digest,
+								Name:      newImages[i].Name,
+								Tag:       newImages[i].Tag,
+								Digest:    newImages[i].Digest,
+								MediaType: newImages[i].MediaType,
+								Platform:  newImages[i].Platform,
+								Platforms: newImages[i].Platforms,
 							},
 							ContainerName: newImages[i].ContainerName,
 						}
@@ -126,12 +132,18 @@ func (k *KubernetesfileDifferentiator) Differentiate(
 							newImage.Tag = ""
 						}
 
-						if *existingImage.Image != *newImage.Image {
+						// lockfiles generated before media types were
+						// recorded cannot be compared by media type
+						if existingImage.MediaType == "" {
+							newImage.MediaType = ""
+						}
+
+						if !imagesEqual(
+							existingImage.Image, newImage.Image,
+						) {
 							select {
-							case errCh <- fmt.Errorf(
-								"on path %s existing image %v differs "+
-									"from the new image %v",
-								path, *existingImage.Image, *newImage.Image,
+							case errCh <- imageDiffError(
+								path, existingImage.Image, newImage.Image,
 							):
 							case <-done:
 							}
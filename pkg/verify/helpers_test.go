@@ -12,25 +12,42 @@ import (
 	"testing"
 )
 
-func mockServer(t *testing.T) *httptest.Server {
-	t.Helper()
+const busyboxLatestSHA = "sha256:bae015c28bc7cdee3b7ef20d35db4299e3068554a769070950229d9f53f58572" // nolint: lll
+const golangLatestSHA = "sha256:6cb55c08bbf44793f16e3572bd7d2ae18f7a858f6ae4faa474c0a6eae1174a5d"  // nolint: lll
+const redisLatestSHA = "sha256:09c33840ec47815dc0351f1eca3befe741d7105b3e95bc8fdb9a7e4985b9e1e5"   // nolint: lll
 
-	const busyboxLatestSHA = "busybox"
+// ociBusyboxLatestSHA differs from busyboxLatestSHA, so that verifying
+// against the registry instead of the OCI image layout fails.
+const ociBusyboxLatestSHA = "sha256:8a4a3be2ab3b3d0a4cd6ea9a4cb1d0bd7e4bc5e4a37d5c3d0d4e6a4e1f5b2c3d" // nolint: lll
 
-	const golangLatestSHA = "golang"
+func mockServer(t *testing.T) *httptest.Server {
+	t.Helper()
 
-	const redisLatestSHA = "redis"
+	var server *httptest.Server
 
-	server := httptest.NewServer(
+	// like Docker Hub, the registry challenges clients to request a bearer
+	// token from its realm
+	server = httptest.NewServer(
 		http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 			switch url := req.URL.String(); {
+			case url == "/":
+				res.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="registry.docker.io"`,
+					server.URL,
+				))
+				res.WriteHeader(http.StatusUnauthorized)
 			case strings.Contains(url, "scope"):
-				byt := []byte(`{"token": "NOT_USED"}`)
+				byt := []byte(`{"token": "token"}`)
 				_, err := res.Write(byt)
 				if err != nil {
 					t.Fatal(err)
 				}
 			case strings.Contains(url, "manifests"):
+				if req.Header.Get("Authorization") != "Bearer token" {
+					res.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
 				urlParts := strings.Split(url, "/")
 				repo, ref := urlParts[2], urlParts[len(urlParts)-1]
 
@@ -121,3 +138,35 @@ func generateUUID(t *testing.T) string {
 
 	return uuid
 }
+
+// makeOCILayout writes an OCI image layout to dir with busybox:latest as an
+// image manifest whose digest is ociBusyboxLatestSHA.
+func makeOCILayout(t *testing.T, dir string) {
+	t.Helper()
+
+	makeDir(t, dir)
+
+	layoutIndex := fmt.Sprintf(`{
+		"manifests": [
+			{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"digest": "%s",
+				"annotations": {
+					"io.containerd.image.name":
+						"docker.io/library/busybox:latest"
+				}
+			}
+		]
+	}`, ociBusyboxLatestSHA)
+
+	layoutFiles := map[string]string{
+		filepath.Join(dir, "oci-layout"): `{"imageLayoutVersion": "1.0.0"}`,
+		filepath.Join(dir, "index.json"): layoutIndex,
+	}
+
+	for path, contents := range layoutFiles {
+		if err := ioutil.WriteFile(path, []byte(contents), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
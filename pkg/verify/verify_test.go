@@ -20,6 +20,7 @@ func TestVerifier(t *testing.T) {
 		Name        string
 		Contents    [][]byte
 		ExcludeTags bool
+		OCILayout   bool
 		ShouldFail  bool
 	}{
 		{
@@ -37,7 +38,7 @@ FROM busybox
 			{
 				"name": "busybox",
 				"tag": "latest",
-				"digest": "busybox"
+				"digest": "` + busyboxLatestSHA + `"
 			}
 		]
 	}
@@ -65,7 +66,7 @@ services:
 			{
 				"name": "busybox",
 				"tag": "latest",
-				"digest": "busybox",
+				"digest": "` + busyboxLatestSHA + `",
 				"service": "svc-two"
 			}
 		]
@@ -100,7 +101,7 @@ spec:
 			{
 			"name": "busybox",
 			"tag": "latest",
-			"digest": "busybox",
+			"digest": "` + busyboxLatestSHA + `",
 			"container": "busybox"
 			}
 		]
@@ -128,7 +129,7 @@ services:
 			{
 				"name": "busybox",
 				"tag": "latest",
-				"digest": "busybox",
+				"digest": "` + busyboxLatestSHA + `",
 				"service": "svc"
 			}
 		]
@@ -155,7 +156,7 @@ services:
 			{
 				"name": "busybox",
 				"tag": "",
-				"digest": "busybox",
+				"digest": "` + busyboxLatestSHA + `",
 				"service": "svc"
 			}
 		]
@@ -166,6 +167,29 @@ services:
 			},
 			ExcludeTags: true,
 		},
+		{
+			Name: "OCI Layout Digest Source",
+			Contents: [][]byte{
+				[]byte(`
+FROM busybox
+`,
+				),
+				[]byte(`
+{
+	"dockerfiles": {
+		"Dockerfile": [
+			{
+				"name": "busybox",
+				"tag": "latest",
+				"digest": "` + ociBusyboxLatestSHA + `"
+			}
+		]
+	}
+}
+`),
+			},
+			OCILayout: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -259,7 +283,6 @@ services:
 			client := &registry.HTTPClient{
 				Client:      server.Client(),
 				RegistryURL: server.URL,
-				TokenURL:    server.URL + "?scope=repository%s",
 			}
 
 			flags := &cmd_verify.Flags{
@@ -268,6 +291,13 @@ services:
 				ExcludeTags:  test.ExcludeTags,
 			}
 
+			if test.OCILayout {
+				ociLayoutDir := filepath.Join(tempDir, "oci")
+				makeOCILayout(t, ociLayoutDir)
+
+				flags.DigestSource = "oci-layout:" + ociLayoutDir
+			}
+
 			verifier, err := cmd_verify.SetupVerifier(client, flags)
 			if err != nil {
 				t.Fatal(err)